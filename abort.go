@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Process exit codes, so orchestration can tell how a run ended
+const (
+	exitOK      = 0 // Every document was exported
+	exitFatal   = 1 // Invalid options, or the export failed (log.Fatalf)
+	exitPartial = 2 // --best-effort export where some collections or sources failed
+	exitAborted = 3 // Stopped by SIGINT/SIGTERM or --max-duration
+)
+
+// Recorded in the summary when a run is stopped before it finishes
+var errAborted = errors.New("export aborted")
+
+// Parent of every batch query's context, cancelled with the abort reason
+// when the run is aborted
+var rootCtx, cancelRoot = context.WithCancelCause(context.Background())
+
+// How long an aborted run gets to wind down before it's reported and ended
+// from here, in case something it's waiting on doesn't honour rootCtx
+const abortGrace = 30 * time.Second
+
+// Abort the run on SIGINT, SIGTERM or once --max-duration has passed by
+// cancelling rootCtx, so in-flight queries fail and main reports the run as
+// aborted and exits with exitAborted. Checkpoints only ever point past fully
+// written batches, so the export can be resumed from there. A second signal
+// exits at once, without waiting for the webhook.
+func watchAbort(cfg *config, summary *exportSummary) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var timeout <-chan time.Time
+	if cfg.maxDuration > 0 {
+		timeout = time.After(cfg.maxDuration)
+	}
+
+	go func() {
+		var reason error
+		select {
+		case sig := <-signals:
+			reason = fmt.Errorf("%w: received %s", errAborted, sig)
+		case <-timeout:
+			reason = fmt.Errorf("%w: --max-duration of %s reached", errAborted, cfg.maxDuration)
+		}
+		log.Printf("🛑 %v, stopping. Progress up to the last completed batch is checkpointed.\n", reason)
+		cancelRoot(reason)
+
+		select {
+		case <-signals:
+			os.Exit(exitAborted)
+		case <-time.After(abortGrace):
+			log.Printf("🛑 The export hasn't stopped %s after the abort, exiting anyway\n", abortGrace)
+			os.Exit(finishRun(cfg, summary, reason))
+		}
+	}()
+}
+
+// The error a run ends with once it has been aborted. Queries cancelled with
+// rootCtx fail with context.Canceled, which the abort reason replaces.
+func abortError(err error) error {
+	if reason := context.Cause(rootCtx); reason != nil {
+		return reason
+	}
+	return fmt.Errorf("%w: %v", errAborted, err)
+}
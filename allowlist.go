@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Fields a --field-allowlist lets into the output, as a tree of path
+// segments. A node without children allows everything under it, so listing
+// address allows the whole sub-document and address.city only that field.
+// Everything not on the list is stripped.
+type fieldAllowlist struct {
+	children map[string]*fieldAllowlist
+}
+
+// Read an allowlist file: one dotted field path per line, with blank lines
+// and lines starting with # ignored. It must allow _id, which resuming,
+// sidecars and consumers rely on.
+func loadFieldAllowlist(path string) (*fieldAllowlist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	root := &fieldAllowlist{children: map[string]*fieldAllowlist{}}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		field := strings.TrimSpace(scanner.Text())
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+		segments := strings.Split(field, ".")
+		for _, segment := range segments {
+			if segment == "" || strings.HasPrefix(segment, "$") {
+				return nil, fmt.Errorf("%s line %d: invalid field %q", path, line, field)
+			}
+		}
+		root.allow(segments)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if id, ok := root.children["_id"]; !ok || id.children != nil {
+		return nil, fmt.Errorf("%s must allow the whole _id field", path)
+	}
+	return root, nil
+}
+
+func (a *fieldAllowlist) allow(path []string) {
+	child, ok := a.children[path[0]]
+	if !ok {
+		child = &fieldAllowlist{}
+		if len(path) > 1 {
+			child.children = map[string]*fieldAllowlist{}
+		}
+		a.children[path[0]] = child
+	}
+	// A shorter path already allows everything below it
+	if len(path) > 1 && child.children != nil {
+		child.allow(path[1:])
+	} else if len(path) == 1 {
+		child.children = nil
+	}
+}
+
+// Strip the fields the allowlist doesn't name from a decoded document
+func (a *fieldAllowlist) filterM(doc bson.M) bson.M {
+	for key, value := range doc {
+		child, ok := a.children[key]
+		if !ok {
+			delete(doc, key)
+			continue
+		}
+		if filtered, keep := child.filterValue(value); keep {
+			doc[key] = filtered
+		} else {
+			delete(doc, key)
+		}
+	}
+	return doc
+}
+
+func (a *fieldAllowlist) filterD(doc bson.D) bson.D {
+	out := doc[:0]
+	for _, e := range doc {
+		child, ok := a.children[e.Key]
+		if !ok {
+			continue
+		}
+		if filtered, keep := child.filterValue(e.Value); keep {
+			out = append(out, bson.E{Key: e.Key, Value: filtered})
+		}
+	}
+	return out
+}
+
+// Filter a value the allowlist reaches. Below a fully allowed node it is
+// kept as is. Below a partly allowed node only documents can be filtered,
+// so other values are dropped, and arrays keep their filtered documents.
+func (a *fieldAllowlist) filterValue(value interface{}) (interface{}, bool) {
+	if a.children == nil {
+		return value, true
+	}
+	switch value := value.(type) {
+	case bson.M:
+		return a.filterM(value), true
+	case bson.D:
+		return a.filterD(value), true
+	case bson.A:
+		out := bson.A{}
+		for _, elem := range value {
+			if filtered, keep := a.filterValue(elem); keep {
+				out = append(out, filtered)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
@@ -0,0 +1,729 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Block codecs --avro-codec accepts, as the Avro spec names them
+var avroCodecs = map[string]bool{"null": true, "deflate": true, "snappy": true}
+
+// Documents are buffered into blocks of about this many bytes before being
+// compressed and written
+const avroBlockBytes = 64 << 10
+
+// Name of the top-level record of an inferred schema
+const avroRecordName = "Document"
+
+// An Avro schema, parsed from --avro-schema or inferred from a batch
+type avroSchema struct {
+	kind     string // A primitive type name, record, enum, array, map, fixed or union
+	logical  string // logicalType, e.g. timestamp-millis
+	name     string // Full name of a record, enum or fixed
+	fields   []avroField
+	items    *avroSchema   // Items of an array, values of a map
+	branches []*avroSchema // Types of a union
+	symbols  []string      // Enum symbols
+	size     int           // Bytes of a fixed
+	source   []byte        // The --avro-schema text for the file header, nil if inferred
+}
+
+type avroField struct {
+	name     string
+	bsonName string // Document field it's read from
+	schema   *avroSchema
+}
+
+var avroPrimitives = map[string]bool{"null": true, "boolean": true, "int": true, "long": true, "float": true, "double": true, "bytes": true, "string": true}
+
+// Read --avro-schema, which must describe a record. Its fields are read from
+// the document fields of the same name.
+func loadAvroSchema(path string) (*avroSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	p := &avroParser{named: map[string]*avroSchema{}}
+	schema, err := p.parse(raw, "")
+	if err != nil {
+		return nil, err
+	}
+	if schema.kind != "record" {
+		return nil, fmt.Errorf("the schema must be a record, not %s", schema.kind)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return nil, err
+	}
+	schema.source = compact.Bytes()
+	return schema, nil
+}
+
+// Resolves named types as a schema defines them
+type avroParser struct {
+	named map[string]*avroSchema
+}
+
+func (p *avroParser) parse(v interface{}, namespace string) (*avroSchema, error) {
+	switch v := v.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroSchema{kind: v}, nil
+		}
+		if s := p.lookup(v, namespace); s != nil {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown type %q", v)
+	case []interface{}:
+		union := &avroSchema{kind: "union"}
+		for _, item := range v {
+			branch, err := p.parse(item, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if branch.kind == "union" {
+				return nil, fmt.Errorf("a union can't directly contain another union")
+			}
+			union.branches = append(union.branches, branch)
+		}
+		return union, nil
+	case map[string]interface{}:
+		return p.parseObject(v, namespace)
+	}
+	return nil, fmt.Errorf("invalid schema %v", v)
+}
+
+func (p *avroParser) parseObject(v map[string]interface{}, namespace string) (*avroSchema, error) {
+	kind, ok := v["type"].(string)
+	if !ok {
+		return p.parse(v["type"], namespace)
+	}
+	switch kind {
+	case "record", "error", "enum", "fixed":
+		s := &avroSchema{kind: kind}
+		if kind == "error" {
+			s.kind = "record"
+		}
+		name, _ := v["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("%s without a name", kind)
+		}
+		if ns, ok := v["namespace"].(string); ok {
+			namespace = ns
+		}
+		s.name = name
+		if !strings.Contains(name, ".") && namespace != "" {
+			s.name = namespace + "." + name
+		} else if i := strings.LastIndex(name, "."); i >= 0 {
+			namespace = name[:i]
+		}
+		if p.named[s.name] != nil {
+			return nil, fmt.Errorf("type %q is defined twice", s.name)
+		}
+		// Registered before its fields, which may refer back to it
+		p.named[s.name] = s
+		return s, p.parseNamed(s, v, namespace)
+	case "array", "map":
+		key := "items"
+		if kind == "map" {
+			key = "values"
+		}
+		items, err := p.parse(v[key], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", kind, key, err)
+		}
+		return &avroSchema{kind: kind, items: items}, nil
+	}
+	s, err := p.parse(kind, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if logical, ok := v["logicalType"].(string); ok && avroPrimitives[s.kind] {
+		return &avroSchema{kind: s.kind, logical: logical}, nil
+	}
+	return s, nil
+}
+
+// Fill in the fields, symbols or size of a named type
+func (p *avroParser) parseNamed(s *avroSchema, v map[string]interface{}, namespace string) error {
+	switch s.kind {
+	case "enum":
+		symbols, _ := v["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			name, ok := symbol.(string)
+			if !ok {
+				return fmt.Errorf("enum %s: symbols must be strings", s.name)
+			}
+			s.symbols = append(s.symbols, name)
+		}
+	case "fixed":
+		size, ok := v["size"].(float64)
+		if !ok || size < 0 {
+			return fmt.Errorf("fixed %s: invalid size", s.name)
+		}
+		s.size = int(size)
+	default:
+		fields, ok := v["fields"].([]interface{})
+		if !ok {
+			return fmt.Errorf("record %s: fields must be a list", s.name)
+		}
+		seen := map[string]bool{}
+		for _, item := range fields {
+			field, _ := item.(map[string]interface{})
+			name, _ := field["name"].(string)
+			if name == "" || seen[name] {
+				return fmt.Errorf("record %s: missing or repeated field name %q", s.name, name)
+			}
+			seen[name] = true
+			schema, err := p.parse(field["type"], namespace)
+			if err != nil {
+				return fmt.Errorf("record %s field %s: %w", s.name, name, err)
+			}
+			s.fields = append(s.fields, avroField{name: name, bsonName: name, schema: schema})
+		}
+	}
+	return nil
+}
+
+// A named type by its name as written, relative to the namespace
+func (p *avroParser) lookup(name, namespace string) *avroSchema {
+	if s := p.named[name]; s != nil {
+		return s
+	}
+	if namespace != "" {
+		return p.named[namespace+"."+name]
+	}
+	return nil
+}
+
+// The schema's JSON for a file header
+func (s *avroSchema) marshal() ([]byte, error) {
+	if s.source != nil {
+		return s.source, nil
+	}
+	return json.Marshal(s.jsonValue())
+}
+
+func (s *avroSchema) jsonValue() interface{} {
+	switch s.kind {
+	case "union":
+		branches := make([]interface{}, len(s.branches))
+		for i, branch := range s.branches {
+			branches[i] = branch.jsonValue()
+		}
+		return branches
+	case "array":
+		return map[string]interface{}{"type": "array", "items": s.items.jsonValue()}
+	case "record":
+		fields := make([]map[string]interface{}, len(s.fields))
+		for i, f := range s.fields {
+			field := map[string]interface{}{"name": f.name, "type": f.schema.jsonValue()}
+			if f.schema.kind == "union" && f.schema.branches[0].kind == "null" {
+				field["default"] = nil
+			}
+			if f.bsonName != f.name {
+				field["doc"] = fmt.Sprintf("Document field %q", f.bsonName)
+			}
+			fields[i] = field
+		}
+		return map[string]interface{}{"type": "record", "name": s.name, "fields": fields}
+	}
+	if s.logical != "" {
+		return map[string]interface{}{"type": s.kind, "logicalType": s.logical}
+	}
+	return s.kind
+}
+
+// The BSON types seen in one field across a batch
+type avroShape struct {
+	null, boolean, int, long, double, str, bytes, date bool
+	doc                                                *avroRecordShape
+	items                                              *avroShape // Set once an array was seen
+}
+
+// The fields seen in the documents or sub-documents at one place in a batch
+type avroRecordShape struct {
+	count  int
+	names  []string // In the order they were first seen
+	fields map[string]*avroShape
+	seen   map[string]int // Documents having each field
+}
+
+func newAvroRecordShape() *avroRecordShape {
+	return &avroRecordShape{fields: map[string]*avroShape{}, seen: map[string]int{}}
+}
+
+func (r *avroRecordShape) add(doc bson.Raw) {
+	elements, _ := doc.Elements()
+	r.count++
+	for _, element := range elements {
+		key := element.Key()
+		shape := r.fields[key]
+		if shape == nil {
+			shape = &avroShape{}
+			r.fields[key] = shape
+			r.names = append(r.names, key)
+		}
+		r.seen[key]++
+		shape.add(element.Value())
+	}
+}
+
+func (s *avroShape) add(v bson.RawValue) {
+	switch v.Type {
+	case bson.TypeNull, bson.TypeUndefined:
+		s.null = true
+	case bson.TypeBoolean:
+		s.boolean = true
+	case bson.TypeInt32:
+		s.int = true
+	case bson.TypeInt64, bson.TypeTimestamp:
+		s.long = true
+	case bson.TypeDouble:
+		s.double = true
+	case bson.TypeDateTime:
+		s.date = true
+	case bson.TypeBinary:
+		s.bytes = true
+	case bson.TypeEmbeddedDocument:
+		if s.doc == nil {
+			s.doc = newAvroRecordShape()
+		}
+		s.doc.add(v.Document())
+	case bson.TypeArray:
+		if s.items == nil {
+			s.items = &avroShape{}
+		}
+		values, _ := v.Array().Values()
+		for _, item := range values {
+			s.items.add(item)
+		}
+	default:
+		s.str = true
+	}
+}
+
+// Infer a schema covering every document of a batch. A field gets a union
+// of the types it was seen with, including null if some documents lack it.
+func inferAvroSchema(docs []bson.Raw) *avroSchema {
+	shape := newAvroRecordShape()
+	for _, doc := range docs {
+		shape.add(doc)
+	}
+	return shape.schema(avroRecordName, map[string]bool{})
+}
+
+// Record names must be unique in a schema, so nested records are named
+// after their path and numbered if that's taken
+func (r *avroRecordShape) schema(name string, names map[string]bool) *avroSchema {
+	base := name
+	for i := 2; names[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	names[name] = true
+	s := &avroSchema{kind: "record", name: name}
+	used := map[string]bool{}
+	for _, key := range r.names {
+		fieldName := avroFieldName(key)
+		for i := 2; used[fieldName]; i++ {
+			fieldName = fmt.Sprintf("%s_%d", avroFieldName(key), i)
+		}
+		used[fieldName] = true
+		shape := *r.fields[key]
+		if r.seen[key] < r.count {
+			shape.null = true
+		}
+		s.fields = append(s.fields, avroField{name: fieldName, bsonName: key, schema: shape.schema(name+"_"+fieldName, names)})
+	}
+	return s
+}
+
+func (s *avroShape) schema(name string, names map[string]bool) *avroSchema {
+	var branches []*avroSchema
+	if s.null {
+		branches = append(branches, &avroSchema{kind: "null"})
+	}
+	if s.boolean {
+		branches = append(branches, &avroSchema{kind: "boolean"})
+	}
+	// A union can only hold one long, so dates share it with integers
+	switch {
+	case s.long || s.int && s.date:
+		branches = append(branches, &avroSchema{kind: "long"})
+	case s.int:
+		branches = append(branches, &avroSchema{kind: "int"})
+	case s.date:
+		branches = append(branches, &avroSchema{kind: "long", logical: "timestamp-millis"})
+	}
+	if s.double {
+		branches = append(branches, &avroSchema{kind: "double"})
+	}
+	if s.str {
+		branches = append(branches, &avroSchema{kind: "string"})
+	}
+	if s.bytes {
+		branches = append(branches, &avroSchema{kind: "bytes"})
+	}
+	if s.doc != nil {
+		branches = append(branches, s.doc.schema(name, names))
+	}
+	if s.items != nil {
+		branches = append(branches, &avroSchema{kind: "array", items: s.items.schema(name, names)})
+	}
+	switch len(branches) {
+	case 0: // Only ever seen in empty arrays
+		return &avroSchema{kind: "null"}
+	case 1:
+		return branches[0]
+	}
+	return &avroSchema{kind: "union", branches: branches}
+}
+
+// A field name Avro accepts: letters, digits and underscores, not starting
+// with a digit
+func avroFieldName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] >= '0' && name[0] <= '9' {
+		name = append([]byte{'_'}, name...)
+	}
+	return string(name)
+}
+
+// Whether a value can be written as the type. Strictly, only the BSON types
+// that map to it; loosely, also those that convert, such as an int32 to a
+// double or an ObjectID to a string.
+func (s *avroSchema) accepts(v bson.RawValue, loose bool) bool {
+	switch s.kind {
+	case "null":
+		return v.Type == 0 || v.Type == bson.TypeNull || v.Type == bson.TypeUndefined
+	case "boolean":
+		return v.Type == bson.TypeBoolean
+	case "int":
+		return v.Type == bson.TypeInt32
+	case "long":
+		return v.Type == bson.TypeInt64 || v.Type == bson.TypeDateTime || v.Type == bson.TypeTimestamp || loose && v.Type == bson.TypeInt32
+	case "float", "double":
+		return v.Type == bson.TypeDouble || loose && (v.Type == bson.TypeInt32 || v.Type == bson.TypeInt64)
+	case "string":
+		if v.Type == bson.TypeString {
+			return true
+		}
+		switch v.Type {
+		case 0, bson.TypeNull, bson.TypeUndefined, bson.TypeEmbeddedDocument, bson.TypeArray:
+			return false
+		}
+		return loose
+	case "bytes":
+		return v.Type == bson.TypeBinary
+	case "fixed":
+		if _, data, ok := v.BinaryOK(); ok {
+			return len(data) == s.size
+		}
+		return v.Type == bson.TypeObjectID && s.size == 12
+	case "enum":
+		str, ok := v.StringValueOK()
+		return ok && s.symbol(str) >= 0
+	case "array":
+		return v.Type == bson.TypeArray
+	case "map", "record":
+		return v.Type == bson.TypeEmbeddedDocument
+	case "union":
+		return s.branch(v) >= 0
+	}
+	return false
+}
+
+// The union branch a value is written as: the first that takes its type,
+// else the first it converts to. -1 if none does.
+func (s *avroSchema) branch(v bson.RawValue) int {
+	for _, loose := range []bool{false, true} {
+		for i, branch := range s.branches {
+			if branch.accepts(v, loose) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (s *avroSchema) symbol(str string) int {
+	for i, symbol := range s.symbols {
+		if symbol == str {
+			return i
+		}
+	}
+	return -1
+}
+
+// Append a value in Avro's binary encoding. A zero RawValue is a missing field.
+func appendAvro(buf []byte, s *avroSchema, v bson.RawValue) ([]byte, error) {
+	if !s.accepts(v, true) {
+		if v.Type == 0 {
+			return nil, fmt.Errorf("missing, and Avro %s isn't nullable", s.describe())
+		}
+		return nil, fmt.Errorf("BSON %s can't be written as Avro %s", v.Type, s.describe())
+	}
+	switch s.kind {
+	case "union":
+		i := s.branch(v)
+		return appendAvro(appendAvroLong(buf, int64(i)), s.branches[i], v)
+	case "boolean":
+		if v.Boolean() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case "int", "long":
+		return appendAvroLong(buf, avroInteger(s, v)), nil
+	case "float":
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(avroFloat(v)))), nil
+	case "double":
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(avroFloat(v))), nil
+	case "string":
+		return appendAvroBytes(buf, []byte(avroString(v))), nil
+	case "bytes":
+		_, data := v.Binary()
+		return appendAvroBytes(buf, data), nil
+	case "fixed":
+		if id, ok := v.ObjectIDOK(); ok {
+			return append(buf, id[:]...), nil
+		}
+		_, data := v.Binary()
+		return append(buf, data...), nil
+	case "enum":
+		return appendAvroLong(buf, int64(s.symbol(v.StringValue()))), nil
+	case "array":
+		values, err := v.Array().Values()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) > 0 {
+			buf = appendAvroLong(buf, int64(len(values)))
+			for i, item := range values {
+				if buf, err = appendAvro(buf, s.items, item); err != nil {
+					return nil, fmt.Errorf("item %d: %w", i, err)
+				}
+			}
+		}
+		return appendAvroLong(buf, 0), nil
+	case "map":
+		elements, err := v.Document().Elements()
+		if err != nil {
+			return nil, err
+		}
+		if len(elements) > 0 {
+			buf = appendAvroLong(buf, int64(len(elements)))
+			for _, element := range elements {
+				buf = appendAvroBytes(buf, []byte(element.Key()))
+				if buf, err = appendAvro(buf, s.items, element.Value()); err != nil {
+					return nil, fmt.Errorf("key %q: %w", element.Key(), err)
+				}
+			}
+		}
+		return appendAvroLong(buf, 0), nil
+	case "record":
+		return appendAvroRecord(buf, s, v.Document())
+	}
+	return buf, nil // null
+}
+
+// Append a document as a record, field by field in schema order. Document
+// fields the schema doesn't have are left out.
+func appendAvroRecord(buf []byte, s *avroSchema, doc bson.Raw) ([]byte, error) {
+	var err error
+	for _, f := range s.fields {
+		if buf, err = appendAvro(buf, f.schema, doc.Lookup(f.bsonName)); err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.bsonName, err)
+		}
+	}
+	return buf, nil
+}
+
+// An integer for an int or long: dates in the unit of the logical type,
+// BSON timestamps as their seconds and increment in one 64-bit value
+func avroInteger(s *avroSchema, v bson.RawValue) int64 {
+	switch v.Type {
+	case bson.TypeDateTime:
+		ms := v.DateTime()
+		if s.logical == "timestamp-micros" {
+			return ms * 1000
+		}
+		return ms
+	case bson.TypeTimestamp:
+		t, i := v.Timestamp()
+		return int64(t)<<32 | int64(i)
+	}
+	n, _ := v.AsInt64OK()
+	return n
+}
+
+// A double, or an integer converted to one, for a float or double
+func avroFloat(v bson.RawValue) float64 {
+	if f, ok := v.DoubleOK(); ok {
+		return f
+	}
+	n, _ := v.AsInt64OK()
+	return float64(n)
+}
+
+// A scalar value as a string: ObjectIDs in hex, dates in RFC 3339, and
+// anything else without a natural text form as relaxed extended JSON
+func avroString(v bson.RawValue) string {
+	switch v.Type {
+	case bson.TypeString:
+		return v.StringValue()
+	case bson.TypeObjectID:
+		return v.ObjectID().Hex()
+	case bson.TypeDecimal128:
+		return v.Decimal128().String()
+	case bson.TypeDateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case bson.TypeSymbol:
+		return v.Symbol()
+	case bson.TypeJavaScript:
+		return v.JavaScript()
+	}
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: v}}, false, false)
+	if err != nil {
+		return v.String()
+	}
+	var wrapped map[string]json.RawMessage
+	if json.Unmarshal(data, &wrapped) != nil {
+		return v.String()
+	}
+	return string(wrapped["v"])
+}
+
+// The type for error messages
+func (s *avroSchema) describe() string {
+	switch {
+	case s.name != "":
+		return s.kind + " " + s.name
+	case s.logical != "":
+		return s.kind + " (" + s.logical + ")"
+	case s.kind == "union":
+		kinds := make([]string, len(s.branches))
+		for i, branch := range s.branches {
+			kinds[i] = branch.describe()
+		}
+		return "union [" + strings.Join(kinds, ", ") + "]"
+	}
+	return s.kind
+}
+
+// A long in Avro's zig-zag varint encoding, which is Go's signed varint
+func appendAvroLong(buf []byte, n int64) []byte {
+	return binary.AppendVarint(buf, n)
+}
+
+func appendAvroBytes(buf, data []byte) []byte {
+	return append(appendAvroLong(buf, int64(len(data))), data...)
+}
+
+// Writes an Avro Object Container File: a header with the schema, codec and
+// a random sync marker, then blocks of documents, each compressed with the
+// codec and followed by the marker
+type avroEncoder struct {
+	out    io.Writer
+	schema *avroSchema
+	codec  string
+	sync   [16]byte
+	block  []byte
+	count  int
+}
+
+func newAvroEncoder(out io.Writer, schema *avroSchema, codec string) (*avroEncoder, error) {
+	e := &avroEncoder{out: out, schema: schema, codec: codec}
+	if _, err := rand.Read(e.sync[:]); err != nil {
+		return nil, err
+	}
+	schemaJSON, err := schema.marshal()
+	if err != nil {
+		return nil, err
+	}
+	header := []byte("Obj\x01")
+	header = appendAvroLong(header, 2)
+	header = appendAvroBytes(header, []byte("avro.schema"))
+	header = appendAvroBytes(header, schemaJSON)
+	header = appendAvroBytes(header, []byte("avro.codec"))
+	header = appendAvroBytes(header, []byte(codec))
+	header = appendAvroLong(header, 0)
+	header = append(header, e.sync[:]...)
+	if _, err := out.Write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *avroEncoder) writeDoc(b *batch, i int) error {
+	block, err := appendAvroRecord(e.block, e.schema, b.raw[i])
+	if err != nil {
+		return err
+	}
+	e.block = block
+	e.count++
+	if len(e.block) >= avroBlockBytes {
+		return e.flush()
+	}
+	return nil
+}
+
+// Write the buffered documents as one block
+func (e *avroEncoder) flush() error {
+	if e.count == 0 {
+		return nil
+	}
+	data := e.block
+	switch e.codec {
+	case "deflate":
+		var compressed bytes.Buffer
+		w, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(e.block); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		data = compressed.Bytes()
+	case "snappy":
+		data = snappy.Encode(nil, e.block)
+		data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(e.block))
+	}
+	header := appendAvroLong(nil, int64(e.count))
+	header = appendAvroLong(header, int64(len(data)))
+	for _, part := range [][]byte{header, data, e.sync[:]} {
+		if _, err := e.out.Write(part); err != nil {
+			return err
+		}
+	}
+	e.block, e.count = e.block[:0], 0
+	return nil
+}
+
+func (e *avroEncoder) close() error {
+	return e.flush()
+}
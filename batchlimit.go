@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Returned by a worker that stopped because --max-batches was reached
+var errBatchLimit = errors.New("--max-batches reached")
+
+// Caps the batches a run writes, across all workers, collections and
+// sources, for --max-batches. Workers claim a batch before fetching it, so
+// none is read and then dropped, and every checkpoint points past the last
+// batch written. Each collection exports with its own view of the run's
+// limit, so it knows whether it was cut short itself. A nil *batchLimit has
+// no limit.
+type batchLimit struct {
+	max    int64
+	taken  *atomic.Int64 // Shared by the run and every collection's view
+	hit    atomic.Bool   // A worker stopped with documents left
+	parent *batchLimit   // The run's limit, for a collection's view
+}
+
+func newBatchLimit(max int) *batchLimit {
+	if max == 0 {
+		return nil
+	}
+	return &batchLimit{max: int64(max), taken: new(atomic.Int64)}
+}
+
+// A view of the limit for one collection, sharing the run's count
+func (l *batchLimit) collection() *batchLimit {
+	if l == nil {
+		return nil
+	}
+	return &batchLimit{max: l.max, taken: l.taken, parent: l}
+}
+
+// Claim the next batch, false once the limit is used up
+func (l *batchLimit) take() bool {
+	if l == nil {
+		return true
+	}
+	for {
+		n := l.taken.Load()
+		if n >= l.max {
+			return false
+		}
+		if l.taken.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// Give back a claimed batch that wrote nothing, because the read came back
+// empty or every document was skipped
+func (l *batchLimit) giveBack() {
+	if l != nil {
+		l.taken.Add(-1)
+	}
+}
+
+// Record that a worker was refused a batch with documents still to export,
+// for the collection and the run
+func (l *batchLimit) stop() {
+	for ; l != nil; l = l.parent {
+		l.hit.Store(true)
+	}
+}
+
+// Whether the collection, or for the run's limit any collection, stopped
+// short because of the limit
+func (l *batchLimit) stopped() bool {
+	return l != nil && l.hit.Load()
+}
+
+// Whether documents are left after lastID once a worker is refused a batch,
+// so a range that ends exactly at the limit counts as finished
+func moreAfter(cfg *config, collection *mongo.Collection, filter bson.M, lastID docID) (bool, error) {
+	ctx, cancel := opContext(cfg)
+	defer cancel()
+	err := collection.FindOne(ctx, paginationFilter(filter, lastID, cfg.descending), options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Whether a cursor has documents left once a worker is refused a batch. The
+// document read to find out is dropped, since the scan stops here.
+func cursorHasMore(cfg *config, cursor *mongo.Cursor) (bool, error) {
+	ctx, cancel := opContext(cfg)
+	defer cancel()
+	if cursor.Next(ctx) {
+		return true, nil
+	}
+	return false, cursor.Err()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestBatchLimitPerCollection(t *testing.T) {
+	run := newBatchLimit(2)
+	first, second := run.collection(), run.collection()
+
+	if !first.take() || !second.take() {
+		t.Fatal("take() refused a batch under the limit")
+	}
+	// Both views share the run's count
+	if first.take() || second.take() {
+		t.Fatal("take() allowed a batch past the limit")
+	}
+	if run.stopped() {
+		t.Error("refusing a batch stopped the run before any documents were found left")
+	}
+
+	second.stop()
+	if first.stopped() {
+		t.Error("a collection that finished counts as stopped because another was cut short")
+	}
+	if !second.stopped() || !run.stopped() {
+		t.Error("a collection cut short isn't recorded for itself and the run")
+	}
+
+	var none *batchLimit
+	if !none.collection().take() || none.collection().stopped() {
+		t.Error("no --max-batches limits or stops a collection")
+	}
+}
+
+func TestExportCollectionEndsAtMaxBatches(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	tests := []struct {
+		name string
+		left bool // Documents remain after the batch the limit allows
+	}{
+		{name: "data ends at the limit"},
+		{name: "documents left", left: true},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			ns := "db." + mt.Coll.Name()
+			id := primitive.NewObjectID()
+			probe := mtest.CreateCursorResponse(0, ns, mtest.FirstBatch)
+			if tt.left {
+				probe = mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: primitive.NewObjectID()}})
+			}
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "ns", Value: ns}, bson.E{Key: "count", Value: 2}, bson.E{Key: "size", Value: 60}),
+				mtest.CreateCursorResponse(0, "db.$cmd.listCollections", mtest.FirstBatch),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: id}}),
+				probe,
+			)
+			dir := mt.TempDir()
+			run := newBatchLimit(1)
+			cfg := &config{checkpointDir: dir, idType: idTypeObjectID, format: "json", workers: 1, maxBatches: 1, batches: run}
+			if err := exportCollection(cfg, mt.Client, mt.DB, mt.Coll.Name(), dir, newExportSummary()); err != nil {
+				mt.Fatalf("exportCollection() error = %v", err)
+			}
+			if cfg.batches.stopped() != tt.left || run.stopped() != tt.left {
+				mt.Errorf("stopped at --max-batches = %t, run %t, want %t", cfg.batches.stopped(), run.stopped(), tt.left)
+			}
+			if got := loadLastID(cfg); got != objectDocID(id) {
+				mt.Errorf("%s = %s, want %s", lastIDFile, got, id.Hex())
+			}
+		})
+	}
+}
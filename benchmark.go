@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Stages of the export timed by --benchmark
+type benchPhase int
+
+const (
+	benchRead   benchPhase = iota // Queries and cursor reads, including network time
+	benchDecode                   // Decoding BSON and applying transforms
+	benchOutput                   // WriteBatch as a whole, encoding plus benchWrite
+	benchWrite                    // Writes to files or stdout
+	benchPhases
+)
+
+// Time spent in each stage, summed over all workers. A nil *benchmark
+// records nothing, so the export loop can time stages unconditionally.
+type benchmark struct {
+	nanos [benchPhases]atomic.Int64
+}
+
+func (b *benchmark) start() time.Time {
+	if b == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (b *benchmark) stop(phase benchPhase, start time.Time) {
+	if b == nil {
+		return
+	}
+	b.nanos[phase].Add(int64(time.Since(start)))
+}
+
+// Writer that times every write into benchWrite
+func (b *benchmark) timed(w io.Writer) io.Writer {
+	if b == nil {
+		return w
+	}
+	return &timedWriter{bench: b, w: w}
+}
+
+type timedWriter struct {
+	bench *benchmark
+	w     io.Writer
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	t.bench.stop(benchWrite, start)
+	return n, err
+}
+
+// Batch writer that times WriteBatch into benchOutput
+type benchWriter struct {
+	batchWriter
+	bench *benchmark
+}
+
+func (w *benchWriter) WriteBatch(workerID, batchNum int, b *batch) (string, error) {
+	start := w.bench.start()
+	defer w.bench.stop(benchOutput, start)
+	return w.batchWriter.WriteBatch(workerID, batchNum, b)
+}
+
+// Log how the time was split between the stages, with a hint about the
+// largest one
+func (b *benchmark) report(summary *exportSummary) {
+	if b == nil {
+		return
+	}
+	read := time.Duration(b.nanos[benchRead].Load())
+	decode := time.Duration(b.nanos[benchDecode].Load())
+	write := time.Duration(b.nanos[benchWrite].Load())
+	encode := max(time.Duration(b.nanos[benchOutput].Load())-write, 0)
+	total := read + decode + encode + write
+	if total == 0 {
+		log.Println("⏱️ Benchmark: nothing was exported")
+		return
+	}
+
+	stages := []struct {
+		name string
+		d    time.Duration
+		hint string
+	}{
+		{"MongoDB reads", read, "add --workers or read from a closer member with --read-preference"},
+		{"BSON decode", decode, "--format=bson or ejson write documents without decoding them"},
+		{"encoding", encode, "--format=bson or ejson encode fastest, and gzip costs CPU"},
+		{"writes", write, "use a faster disk, or gzip to write fewer bytes"},
+	}
+	log.Printf("⏱️ Benchmark: %d documents, time summed over all workers:\n", summary.Documents)
+	largest := 0
+	for i, s := range stages {
+		log.Printf("⏱️   %-14s %6.1f%%  %s\n", s.name, 100*float64(s.d)/float64(total), s.d.Round(time.Millisecond))
+		if s.d > stages[largest].d {
+			largest = i
+		}
+	}
+	if seconds := read.Seconds(); seconds > 0 {
+		log.Printf("⏱️   Read throughput: %.0f documents/s per worker\n", float64(summary.Documents)/seconds)
+	}
+	log.Printf("⏱️ Most time goes to %s: %s\n", stages[largest].name, stages[largest].hint)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Export state kept alongside last_id.txt. It tracks what a run needs to
+// know about previous runs, as opposed to the _id progress of the current one.
+const checkpointFile = "checkpoint.json"
+
+type checkpointState struct {
+	Format string                     `json:"format,omitempty"` // Output format of the files in exportDir, e.g. "json-gz"
+	Deltas map[string]*deltaState     `json:"deltas,omitempty"` // Keyed by "db.collection"
+	Tails  map[string]json.RawMessage `json:"tails,omitempty"`  // --tail-seconds change stream resume tokens, keyed by "db.collection"
+	Oplog  map[string]*oplogState     `json:"oplog,omitempty"`  // --since-oplog positions, keyed by "db.collection"
+
+	// Per-partition progress of parallel exports, keyed by "db.collection"
+	Partitions map[string]*partitionCheckpoint `json:"partitions,omitempty"`
+
+	// --hashed-shard-scan chunk progress, keyed by "db.collection"
+	Hashed map[string]*hashedCheckpoint `json:"hashed,omitempty"`
+
+	path string // File the state was loaded from and is saved to
+}
+
+// Load the checkpoint state, returning an empty one if none was saved yet
+func loadCheckpointState(cfg *config) (*checkpointState, error) {
+	state := &checkpointState{path: checkpointPath(cfg, checkpointFile)}
+	data, err := os.ReadFile(state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", state.path, err)
+	}
+	return state, nil
+}
+
+// Save the checkpoint state atomically
+func saveCheckpointState(state *checkpointState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(state.path, data)
+}
+
+// Path of a checkpoint file in the configured checkpoint directory
+func checkpointPath(cfg *config, name string) string {
+	return filepath.Join(cfg.checkpointDir, name)
+}
+
+// Refuse to resume into an export directory written in a different format
+// (or compression), unless forced, then record the format of this run
+func guardOutputFormat(cfg *config, state *checkpointState, resuming bool) error {
+	format := cfg.format
+	if cfg.compressEachBatch {
+		format += "-gz"
+	}
+	if resuming && state.Format != "" && state.Format != format {
+		if !cfg.force {
+			return fmt.Errorf("the export being resumed was written as --format=%s, resuming as --format=%s would mix incompatible files in %s (use the same format, --no-checkpoint to start over, or --force)", state.Format, format, exportDir)
+		}
+		log.Printf("⚠️ Warning: Resuming a --format=%s export as --format=%s because of --force, %s will contain both.\n", state.Format, format, exportDir)
+	}
+	if state.Format == format {
+		return nil
+	}
+	state.Format = format
+	if err := saveCheckpointState(state); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Algorithms --checksum-algorithm accepts. sha256 and sha1 guard against
+// tampering as well as corruption; crc32 and xxhash only catch corruption,
+// but are much cheaper on large exports.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"xxhash": func() hash.Hash { return xxhash.New() },
+}
+
+func validateChecksumAlgorithm(name string) error {
+	if _, ok := checksumAlgorithms[name]; !ok {
+		return fmt.Errorf("unknown --checksum-algorithm %q, expected sha256, sha1, crc32 or xxhash", name)
+	}
+	return nil
+}
+
+// Hex digest of a finished file
+func fileChecksum(algorithm, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := checksumAlgorithms[algorithm]()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Returned by a --best-effort export where some, but not all, collections failed
+var errPartialExport = errors.New("some collections failed to export")
+
+// Collections to export from the collection prompt: a single name, a
+// comma-separated list, or * for every collection in the database
+func collectionNames(db *mongo.Database, input string) ([]string, error) {
+	if input == "*" {
+		names, err := db.ListCollectionNames(context.TODO(), bson.M{"type": "collection", "name": bson.M{"$not": bson.M{"$regex": "^system\\."}}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list collections: %w", err)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("database %s has no collections", db.Name())
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(input, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no collection name given")
+	}
+	return names, nil
+}
+
+// Export several collections one after another, each into its own
+// subdirectory of exportDir. A failed collection stops the run, or with
+// --best-effort is recorded in the summary while the rest carry on.
+func exportCollections(cfg *config, client *mongo.Client, db *mongo.Database, names []string, summary *exportSummary) error {
+	if cfg.deltaField != "" {
+		return fmt.Errorf("--delta-field needs the checkpoint and can only export one collection at a time")
+	}
+	if !cfg.noCheckpoint {
+		log.Printf("⚠️ Warning: %s only tracks a single collection, checkpointing is disabled for this %d-collection export.\n", lastIDFile, len(names))
+	}
+
+	var failed []string
+	for i, name := range names {
+		log.Printf("📂 Exporting collection %s (%d/%d)...\n", name, i+1, len(names))
+
+		// Each collection gets a fresh copy, since exporting adjusts the config
+		// (filters, capped or time-series detection, worker count)
+		collectionCfg := *cfg
+		collectionCfg.noCheckpoint = true
+		collectionSummary := newExportSummary()
+		err := exportCollection(&collectionCfg, client, db, name, filepath.Join(exportDir, name), collectionSummary)
+		summary.addCollection(name, collectionSummary, err)
+		if err == nil {
+			log.Printf("✅ Collection %s: exported %d documents\n", name, collectionSummary.Documents)
+			continue
+		}
+		if !cfg.bestEffort {
+			return fmt.Errorf("collection %s: %w", name, err)
+		}
+		log.Printf("❌ Collection %s failed, continuing with the rest: %v\n", name, err)
+		failed = append(failed, name)
+	}
+
+	switch {
+	case len(failed) == len(names):
+		return fmt.Errorf("all %d collections failed to export", len(names))
+	case len(failed) > 0:
+		return fmt.Errorf("%w: %s", errPartialExport, strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Held while a --interactive-confirm summary is shown and answered, so
+// --sources exported at once ask one at a time
+var confirmMu sync.Mutex
+
+// For --interactive-confirm, log what the export is about to read and
+// write, after the preflight checks, and ask before starting. --yes starts
+// without asking. Without a terminal to ask on, the export is refused rather
+// than started unconfirmed.
+func confirmExport(cfg *config, dbName, collectionName, dir string, stats *collStats) error {
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+
+	log.Printf("📋 About to export %s.%s\n", dbName, collectionName)
+	readPref := "primary"
+	if cfg.readPref != nil {
+		readPref = cfg.readPref.Mode().String()
+	}
+	log.Printf("📋   Read preference: %s\n", readPref)
+	filter := "none, every document"
+	if len(cfg.filter) > 0 {
+		if data, err := bson.MarshalExtJSON(cfg.filter, false, false); err == nil {
+			filter = string(data)
+		}
+	}
+	log.Printf("📋   Filter: %s\n", filter)
+	compression := "uncompressed"
+	switch {
+	case cfg.compressEachBatch:
+		compression = "gzip per batch"
+	case cfg.compressFinal:
+		compression = "gzip"
+	}
+	log.Printf("📋   Format: %s, %s\n", cfg.format, compression)
+	log.Printf("📋   Destination: %s\n", exportDestination(cfg, dir))
+	switch {
+	case stats == nil:
+		log.Println("📋   Estimate: unknown, collStats failed")
+	case len(cfg.filter) > 0:
+		log.Printf("📋   Estimate: up to %d documents, ~%s on disk (the filter isn't counted)\n", stats.Count, formatBytes(estimateOutputSize(cfg, stats.Size)))
+	default:
+		log.Printf("📋   Estimate: %d documents, ~%s on disk\n", stats.Count, formatBytes(estimateOutputSize(cfg, stats.Size)))
+	}
+	if !cfg.noCheckpoint {
+		log.Println("📋   A checkpoint from an earlier run, if any, narrows this to what's left")
+	}
+
+	if cfg.yes {
+		log.Println("✅ Starting without asking, --yes was given")
+		return nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("--interactive-confirm needs a terminal to ask on, pass --yes to start without asking")
+	}
+	answer := promptLine(bufio.NewReader(os.Stdin), "Start the export? [y/N]: ")
+	if answer = strings.ToLower(answer); answer != "y" && answer != "yes" {
+		return fmt.Errorf("export of %s.%s wasn't confirmed, nothing was exported", dbName, collectionName)
+	}
+	log.Printf("✅ Export of %s.%s confirmed\n", dbName, collectionName)
+	return nil
+}
+
+// Where the export writes, as shown in the confirmation summary
+func exportDestination(cfg *config, dir string) string {
+	switch {
+	case cfg.outputStdout:
+		return "stdout"
+	case cfg.singleFile:
+		path := filepath.Join(dir, cfg.filePrefix+"export.json")
+		if cfg.compressFinal {
+			path += ".gz"
+		}
+		dir = path
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
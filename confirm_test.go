@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExportDestination(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "exports", "orders")
+	tests := []struct {
+		name string
+		cfg  *config
+		want string
+	}{
+		{name: "batch files", cfg: &config{}, want: dir},
+		{name: "single file in the collection's directory", cfg: &config{singleFile: true}, want: filepath.Join(dir, "export.json")},
+		{name: "compressed single file of a source", cfg: &config{singleFile: true, compressFinal: true, filePrefix: "eu_"}, want: filepath.Join(dir, "eu_export.json.gz")},
+		{name: "stdout", cfg: &config{outputStdout: true, singleFile: true}, want: "stdout"},
+	}
+	for _, tt := range tests {
+		if got := exportDestination(tt.cfg, dir); got != tt.want {
+			t.Errorf("%s: exportDestination() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Document count of one collection
+type collectionCount struct {
+	Collection string `json:"collection"`
+	Count      int64  `json:"count"`
+}
+
+// The count subcommand: print accurate document counts for a list of
+// collections, or every collection in the database, without exporting
+func runCount(args []string) error {
+	cfg := &config{}
+	fs := flag.NewFlagSet("count", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s count [flags] [collection ...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	addConnectionFlags(fs, cfg)
+	allCollections := fs.Bool("all-collections", false, "count every collection in the database")
+	filterJSON := fs.String("filter", "", "only count documents matching this extended JSON query, e.g. '{\"status\": \"active\"}'")
+	output := fs.String("output", "table", "output format: table or json")
+	fs.Parse(args)
+
+	if err := validateConnectionFlags(cfg); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown --output %q, expected table or json", *output)
+	}
+	collections := fs.Args()
+	if *allCollections == (len(collections) > 0) {
+		return fmt.Errorf("give either a list of collections or --all-collections")
+	}
+	filter := bson.M{}
+	if *filterJSON != "" {
+		if err := bson.UnmarshalExtJSON([]byte(*filterJSON), false, &filter); err != nil {
+			return fmt.Errorf("invalid --filter: %w", err)
+		}
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	mongoURI := promptURI(cfg, reader)
+	dbName := promptLine(reader, "Enter database name: ")
+
+	client, err := connectMongo(cfg, mongoURI)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.TODO())
+	db := client.Database(dbName)
+
+	if *allCollections {
+		collections, err = db.ListCollectionNames(context.TODO(), bson.M{"type": "collection"})
+		if err != nil {
+			return fmt.Errorf("failed to list collections: %w", err)
+		}
+		sort.Strings(collections)
+	}
+
+	counts := make([]collectionCount, 0, len(collections))
+	for _, name := range collections {
+		count, err := db.Collection(name).CountDocuments(context.TODO(), filter)
+		if err != nil {
+			return fmt.Errorf("failed to count %s: %w", name, err)
+		}
+		counts = append(counts, collectionCount{Collection: name, Count: count})
+	}
+	return printCounts(counts, *output)
+}
+
+// Print counts to stdout as an aligned table with a total, or as JSON
+func printCounts(counts []collectionCount, output string) error {
+	if output == "json" {
+		return printJSON(counts)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tDOCUMENTS")
+	var total int64
+	for _, c := range counts {
+		fmt.Fprintf(w, "%s\t%d\n", c.Collection, c.Count)
+		total += c.Count
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\n", total)
+	return w.Flush()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Connection secrets read from --credentials-file, so they never appear on
+// the command line or in the shell history
+type credentials struct {
+	URI        string `json:"uri"`         // Connection string, used instead of prompting for one
+	Username   string `json:"username"`    // Overrides any user in the connection string
+	Password   string `json:"password"`    // Overrides any password in the connection string
+	AuthSource string `json:"auth_source"` // Database the user is defined in, default "admin"
+}
+
+// Read and parse the credentials file, warning if other users can read it
+func loadCredentials(path string) (*credentials, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		log.Printf("⚠️ Warning: Credentials file %s is world-readable, restrict it with chmod 600\n", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	var creds credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		// Don't echo the file's contents through the decoder's error
+		return nil, fmt.Errorf("credentials file %s is not valid JSON", path)
+	}
+	if creds.Password != "" && creds.Username == "" {
+		return nil, fmt.Errorf("credentials file %s has a password but no username", path)
+	}
+
+	redactor.add(creds.Password)
+	redactor.add(creds.URI)
+	if u, err := url.Parse(creds.URI); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			redactor.add(password)
+			redactor.add(url.QueryEscape(password))
+		}
+	}
+	return &creds, nil
+}
+
+// The connection string from the credentials file if it has one, otherwise
+// prompted for
+func promptURI(cfg *config, reader *bufio.Reader) string {
+	if cfg.credentials != nil && cfg.credentials.URI != "" {
+		return cfg.credentials.URI
+	}
+	return promptLine(reader, "Enter MongoDB connection string: ")
+}
+
+// Replaces every secret read from the credentials file with *** in the log
+var redactor = &secretRedactor{}
+
+type secretRedactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+func (r *secretRedactor) add(secret string) {
+	if secret == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets = append(r.secrets, secret)
+}
+
+// Writer that redacts the secrets from everything passed on to w
+func (r *secretRedactor) wrap(w io.Writer) io.Writer {
+	return &redactingWriter{redactor: r, w: w}
+}
+
+type redactingWriter struct {
+	redactor *secretRedactor
+	w        io.Writer
+}
+
+// The log package writes each message in a single call, so a secret is never
+// split across writes
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.redactor.mu.RLock()
+	text := string(p)
+	for _, secret := range w.redactor.secrets {
+		text = strings.ReplaceAll(text, secret, "***")
+	}
+	w.redactor.mu.RUnlock()
+	if _, err := io.WriteString(w.w, text); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// False positive rate the bloom filter is sized for. A false positive drops
+// a document that wasn't a duplicate, so keep this tiny.
+const bloomFalsePositiveRate = 1e-6
+
+// Remembers the _ids written in this run, shared by all workers
+type idDeduper interface {
+	// Record id and report whether it had been recorded before
+	seen(id bson.RawValue) bool
+}
+
+// dedupe key of a raw _id, including its type so 1 and "1" stay distinct
+func dedupeKey(id bson.RawValue) string {
+	return string(append([]byte{byte(id.Type)}, id.Value...))
+}
+
+// Exact dedupe, holding every _id in memory
+type exactDeduper struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+func newExactDeduper() *exactDeduper {
+	return &exactDeduper{ids: map[string]struct{}{}}
+}
+
+func (d *exactDeduper) seen(id bson.RawValue) bool {
+	key := dedupeKey(id)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.ids[key]; ok {
+		return true
+	}
+	d.ids[key] = struct{}{}
+	return false
+}
+
+// Probabilistic dedupe with a bloom filter: a fixed, much smaller amount of
+// memory, at the cost of rarely treating a new _id as a duplicate
+type bloomDeduper struct {
+	mu     sync.Mutex
+	bits   []uint64
+	m      uint64 // Number of bits
+	k      int    // Hash functions per _id
+	seed1  maphash.Seed
+	seed2  maphash.Seed
+	maxIDs int64 // Expected number of _ids the filter was sized for
+}
+
+// Size a bloom filter for expected _ids at bloomFalsePositiveRate
+func newBloomDeduper(expected int64) *bloomDeduper {
+	expected = max(expected, 1000)
+	m := uint64(math.Ceil(-float64(expected) * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := int(math.Round(float64(m) / float64(expected) * math.Ln2))
+	return &bloomDeduper{
+		bits:   make([]uint64, (m+63)/64),
+		m:      m,
+		k:      max(k, 1),
+		seed1:  maphash.MakeSeed(),
+		seed2:  maphash.MakeSeed(),
+		maxIDs: expected,
+	}
+}
+
+// Bytes of memory the filter's bit array uses
+func (d *bloomDeduper) size() int64 {
+	return int64(len(d.bits) * 8)
+}
+
+func (d *bloomDeduper) seen(id bson.RawValue) bool {
+	key := dedupeKey(id)
+	// Double hashing: bit i is h1 + i*h2
+	h1 := maphash.String(d.seed1, key)
+	h2 := maphash.String(d.seed2, key) | 1
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	present := true
+	for i := 0; i < d.k; i++ {
+		bit := (h1 + uint64(i)*h2) % d.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if d.bits[word]&mask == 0 {
+			present = false
+			d.bits[word] |= mask
+		}
+	}
+	return present
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// High-watermark of a delta export on a monotonic field. Values are stored as
+// canonical extended JSON so dates, numbers and timestamps keep their type.
+type deltaState struct {
+	Field     string          `json:"field"`
+	Watermark json.RawMessage `json:"watermark,omitempty"` // Everything up to this value has been exported
+	Target    json.RawMessage `json:"target,omitempty"`    // Upper bound of the run in progress
+}
+
+// Build the filter for this run's slice of the delta: documents with field
+// above the stored watermark and at or below a target fixed when the run
+// started. Pinning the target means documents updated mid-run are picked up
+// by the next run instead of being missed. An interrupted run keeps its
+// target so resuming from last_id.txt sees the same range.
+// Returns a nil filter if there is nothing to export.
+func prepareDelta(cfg *config, collection *mongo.Collection, state *checkpointState, key string) (bson.M, error) {
+	if state.Deltas == nil {
+		state.Deltas = map[string]*deltaState{}
+	}
+	delta := state.Deltas[key]
+	if delta == nil || delta.Field != cfg.deltaField {
+		if delta != nil {
+			log.Printf("⚠️ Warning: Delta field changed from %s to %s, starting a new watermark\n", delta.Field, cfg.deltaField)
+		}
+		delta = &deltaState{Field: cfg.deltaField}
+		state.Deltas[key] = delta
+	}
+
+	if delta.Target == nil {
+		target, err := findMaxValue(collection, cfg.deltaField)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find current %s high-watermark: %w", cfg.deltaField, err)
+		}
+		if target == nil {
+			log.Printf("🔄 No documents have %s, nothing to export\n", cfg.deltaField)
+			return nil, nil
+		}
+		if delta.Target, err = encodeWatermark(target); err != nil {
+			return nil, err
+		}
+		if err := saveCheckpointState(state); err != nil {
+			return nil, fmt.Errorf("failed to save %s: %w", checkpointFile, err)
+		}
+	}
+
+	rangeFilter := bson.M{}
+	if delta.Watermark != nil {
+		low, err := decodeWatermark(delta.Watermark)
+		if err != nil {
+			return nil, err
+		}
+		rangeFilter["$gt"] = low
+	}
+	high, err := decodeWatermark(delta.Target)
+	if err != nil {
+		return nil, err
+	}
+	rangeFilter["$lte"] = high
+
+	log.Printf("🔄 Delta export on %s: (%s, %s]\n", cfg.deltaField, watermarkString(delta.Watermark), watermarkString(delta.Target))
+	return bson.M{cfg.deltaField: rangeFilter}, nil
+}
+
+// Advance the watermark once a delta run has fully completed. The _id
+// checkpoint is cleared too, as the next run scans a new range from the start.
+func completeDelta(cfg *config, state *checkpointState, key string) error {
+	delta := state.Deltas[key]
+	if delta == nil || delta.Target == nil {
+		return nil
+	}
+	delta.Watermark, delta.Target = delta.Target, nil
+	if err := saveCheckpointState(state); err != nil {
+		return err
+	}
+	if err := resetManifest(cfg); err != nil {
+		return err
+	}
+	if err := os.Remove(checkpointPath(cfg, lastIDFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	log.Printf("🔄 Delta watermark advanced to %s\n", watermarkString(delta.Watermark))
+	return nil
+}
+
+// Find the largest value of field in the collection (nil if no document has
+// it), using the field's index for the sort
+func findMaxValue(collection *mongo.Collection, field string) (interface{}, error) {
+	raw, err := collection.FindOne(
+		context.TODO(),
+		bson.M{field: bson.M{"$exists": true}},
+		options.FindOne().
+			SetSort(bson.D{{Key: field, Value: -1}}).
+			SetProjection(bson.M{field: 1}),
+	).Raw()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := raw.LookupErr(strings.Split(field, ".")...)
+	if err != nil {
+		return nil, nil
+	}
+	return value, nil
+}
+
+func encodeWatermark(value interface{}) (json.RawMessage, error) {
+	return bson.MarshalExtJSON(bson.D{{Key: "value", Value: value}}, true, false)
+}
+
+func decodeWatermark(data json.RawMessage) (interface{}, error) {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(data, true, &doc); err != nil || len(doc) != 1 {
+		return nil, fmt.Errorf("invalid watermark %s in %s", data, checkpointFile)
+	}
+	return doc[0].Value, nil
+}
+
+// Short form of a stored watermark for logs
+func watermarkString(data json.RawMessage) string {
+	if data == nil {
+		return "start"
+	}
+	return string(data)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Replace the sub-documents and arrays of v nested more than maxDepth levels
+// deep with their JSON encoding as a string, so a pathologically deep
+// document comes out as a bounded structure. v itself is at level. Returns
+// the possibly replaced value and whether anything was cut.
+func limitDepth(v interface{}, level, maxDepth int) (interface{}, bool) {
+	switch v := v.(type) {
+	case bson.M:
+		if level > maxDepth {
+			return encodeSubtree(v), true
+		}
+		cut := false
+		for field, value := range v {
+			if limited, ok := limitDepth(value, level+1, maxDepth); ok {
+				v[field], cut = limited, true
+			}
+		}
+		return v, cut
+	case bson.D:
+		if level > maxDepth {
+			return encodeSubtree(v), true
+		}
+		cut := false
+		for i := range v {
+			if limited, ok := limitDepth(v[i].Value, level+1, maxDepth); ok {
+				v[i].Value, cut = limited, true
+			}
+		}
+		return v, cut
+	case bson.A:
+		if level > maxDepth {
+			return encodeSubtree(v), true
+		}
+		cut := false
+		for i := range v {
+			if limited, ok := limitDepth(v[i], level+1, maxDepth); ok {
+				v[i], cut = limited, true
+			}
+		}
+		return v, cut
+	}
+	return v, false
+}
+
+// Compact JSON of a cut-off subtree, keeping the field order of bson.D
+func encodeSubtree(v interface{}) string {
+	data, err := json.Marshal(orderedValue(v))
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
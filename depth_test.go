@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A document nested levels deep through "child" sub-documents, every third
+// level holding it in an array instead
+func deepDocument(levels int) bson.D {
+	doc := bson.D{{Key: "leaf", Value: true}}
+	for level := levels - 1; level >= 1; level-- {
+		var child interface{} = doc
+		if level%3 == 0 {
+			child = bson.A{doc}
+		}
+		doc = bson.D{{Key: "level", Value: level}, {Key: "child", Value: child}}
+	}
+	return doc
+}
+
+func TestLimitDepthThousandLevels(t *testing.T) {
+	const levels, maxDepth = 1000, 5
+	raw, err := bson.Marshal(append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, deepDocument(levels)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, preserveOrder := range []bool{false, true} {
+		name := "bson.M"
+		if preserveOrder {
+			name = "bson.D"
+		}
+		t.Run(name, func(t *testing.T) {
+			cfg := &config{idType: idTypeObjectID, format: "json", maxDepth: maxDepth, preserveFieldOrder: preserveOrder}
+			b := &batch{}
+			if err := b.add(1, 1, cfg, raw); err != nil {
+				t.Fatalf("add() error = %v", err)
+			}
+			if b.count() != 1 {
+				t.Fatalf("batch holds %d documents, want 1", b.count())
+			}
+
+			// Follow "child" down to the first value that was cut off
+			var v interface{}
+			if preserveOrder {
+				v = b.ordered[0]
+			} else {
+				v = b.docs[0]
+			}
+			level := 1
+			for {
+				if a, ok := v.(bson.A); ok {
+					v, level = a[0], level+1
+					continue
+				}
+				var child interface{}
+				switch doc := v.(type) {
+				case bson.M:
+					child = doc["child"]
+				case bson.D:
+					child = lookupD(doc, "child")
+				}
+				if child == nil {
+					break
+				}
+				v, level = child, level+1
+			}
+			cut, ok := v.(string)
+			if !ok {
+				t.Fatalf("level %d holds %T, want the subtree below --max-depth as a string", level, v)
+			}
+			if level != maxDepth+1 {
+				t.Errorf("subtree stringified at level %d, want %d", level, maxDepth+1)
+			}
+
+			// The string holds everything below the cut, and the document as
+			// a whole still encodes
+			if !json.Valid([]byte(cut)) {
+				t.Fatalf("stringified subtree isn't valid JSON: %.100s...", cut)
+			}
+			if !strings.Contains(cut, `"leaf":true`) {
+				t.Error("stringified subtree lost the innermost level")
+			}
+			var buf bytes.Buffer
+			enc, err := newJSONArrayWriter(&buf, "", false, "_id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.writeDoc(b, 0); err != nil {
+				t.Fatalf("writeDoc() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Export the unique values of cfg.distinctField, matching the base filter,
+// to dir/distinct_<field>.json (or .jsonl with one value per line)
+func runDistinct(cfg *config, collection *mongo.Collection, dir string, summary *exportSummary) error {
+	log.Printf("🔎 Fetching distinct values of %q...\n", cfg.distinctField)
+	filter := andFilters(cfg.filter, nil)
+	values, err := collection.Distinct(context.TODO(), cfg.distinctField, filter)
+	if err != nil {
+		return fmt.Errorf("failed to fetch distinct values (the result must fit in one 16MB document, use an aggregation for high cardinality fields): %w", err)
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	path := filepath.Join(dir, cfg.filePrefix+"distinct_"+cfg.distinctField+".json")
+	if cfg.distinctOutput == "lines" {
+		path += "l"
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+
+	if cfg.distinctOutput == "lines" {
+		encoder := json.NewEncoder(out)
+		for _, value := range values {
+			if err := encoder.Encode(value); err != nil {
+				return fmt.Errorf("failed to write JSON: %w", err)
+			}
+		}
+	} else {
+		data, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to write JSON: %w", err)
+		}
+		if _, err := out.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write JSON: %w", err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to flush file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	summary.addBatch(len(values), 0, 0)
+	log.Printf("✅ Exported %d distinct values of %q -> %s\n", len(values), cfg.distinctField, path)
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The dump-sample subcommand: print the first documents of a collection as
+// indented relaxed extended JSON, to see its shape before setting up an export
+func runDumpSample(args []string) error {
+	cfg := &config{}
+	fs := flag.NewFlagSet("dump-sample", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s dump-sample [flags] <collection>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	addConnectionFlags(fs, cfg)
+	limit := fs.Int("limit", 5, "number of documents to print")
+	filterJSON := fs.String("filter", "", "only print documents matching this extended JSON query, e.g. '{\"status\": \"active\"}'")
+	projectionJSON := fs.String("projection", "", "only print these fields, as an extended JSON projection, e.g. '{\"name\": 1, \"address.city\": 1}'")
+	arraySlices := fs.String("array-slice", "", "comma-separated field:n or field:skip:n slices of array fields to print, as for the export")
+	fs.Parse(args)
+
+	if err := validateConnectionFlags(cfg); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("give exactly one collection")
+	}
+	if *limit < 1 {
+		return fmt.Errorf("--limit must be at least 1")
+	}
+	filter := bson.M{}
+	if *filterJSON != "" {
+		if err := bson.UnmarshalExtJSON([]byte(*filterJSON), false, &filter); err != nil {
+			return fmt.Errorf("invalid --filter: %w", err)
+		}
+	}
+	findOpts := options.Find().SetLimit(int64(*limit))
+	var projection bson.D
+	if *projectionJSON != "" {
+		if err := bson.UnmarshalExtJSON([]byte(*projectionJSON), false, &projection); err != nil {
+			return fmt.Errorf("invalid --projection: %w", err)
+		}
+	}
+	if *arraySlices != "" {
+		slices, err := parseArraySlices(*arraySlices)
+		if err != nil {
+			return fmt.Errorf("invalid --array-slice: %w", err)
+		}
+		if projection, err = addArraySlices(projection, slices); err != nil {
+			return err
+		}
+	}
+	if projection != nil {
+		findOpts.SetProjection(projection)
+	}
+	collectionName := fs.Arg(0)
+
+	reader := bufio.NewReader(os.Stdin)
+	mongoURI := promptURI(cfg, reader)
+	dbName := promptLine(reader, "Enter database name: ")
+
+	client, err := connectMongo(cfg, mongoURI)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.TODO())
+
+	// No sort, so the server returns whichever documents it reaches first
+	// without scanning an index or the whole collection
+	cursor, err := client.Database(dbName).Collection(collectionName).Find(context.TODO(), filter, findOpts)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", collectionName, err)
+	}
+	defer cursor.Close(context.TODO())
+
+	printed := 0
+	for cursor.Next(context.TODO()) {
+		data, err := bson.MarshalExtJSONIndent(cursor.Current, false, false, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode document %d: %w", printed+1, err)
+		}
+		fmt.Println(string(data))
+		printed++
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", collectionName, err)
+	}
+	log.Printf("🔬 Printed %d documents from %s.%s\n", printed, dbName, collectionName)
+	if printed == 0 {
+		fmt.Fprintf(console, "📭 No documents in %s match\n", collectionName)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Same layout relaxed extended JSON uses for the dates it writes as strings
+const ejsonDateLayout = "2006-01-02T15:04:05.999Z07:00"
+
+// Relaxed extended JSON, with every date written as {"$date": "<ISO-8601>"}.
+// The driver's relaxed mode only does that for years 1970 to 9999 and falls
+// back to {"$date": {"$numberLong": ...}} otherwise, so a field holding dates
+// on both sides of 1970 comes out in two shapes. Years outside 0 to 9999
+// have no four-digit ISO form and keep the $numberLong one.
+func marshalNormalizedExtJSON(doc bson.Raw) ([]byte, error) {
+	if !hasCanonicalDate(bsoncore.Document(doc)) {
+		return bson.MarshalExtJSON(doc, false, false)
+	}
+	var buf bytes.Buffer
+	if err := writeNormalizedDoc(&buf, bsoncore.Document(doc), false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Whether relaxed mode would write any date in doc, at any depth, as $numberLong
+func hasCanonicalDate(doc bsoncore.Document) bool {
+	elements, err := doc.Elements()
+	if err != nil {
+		return false // Left to the driver to report
+	}
+	for _, element := range elements {
+		value := element.Value()
+		switch value.Type {
+		case bsontype.DateTime:
+			if _, ok := normalizedDate(value.DateTime()); ok {
+				return true
+			}
+		case bsontype.EmbeddedDocument:
+			if hasCanonicalDate(value.Document()) {
+				return true
+			}
+		case bsontype.Array:
+			if hasCanonicalDate(bsoncore.Document(value.Array())) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ISO form of a date that relaxed mode writes as $numberLong, if it has one
+func normalizedDate(ms int64) (string, bool) {
+	t := time.UnixMilli(ms).UTC()
+	if t.Year() < 0 || t.Year() >= 1970 {
+		return "", false
+	}
+	return t.Format(ejsonDateLayout), true
+}
+
+// Write a document or array, transcoding each value. Anything but dates
+// and nested containers is handed to the driver, one value at a time.
+func writeNormalizedDoc(buf *bytes.Buffer, doc bsoncore.Document, array bool) error {
+	elements, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	open, end := byte('{'), byte('}')
+	if array {
+		open, end = '[', ']'
+	}
+	buf.WriteByte(open)
+	for i, element := range elements {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if !array {
+			// Let the driver escape the key, cutting it out of {"key":null}
+			wrapped := bsoncore.NewDocumentBuilder().AppendNull(element.Key()).Build()
+			data, err := bson.MarshalExtJSON(bson.Raw(wrapped), false, false)
+			if err != nil {
+				return err
+			}
+			buf.Write(data[1 : len(data)-len("null}")])
+		}
+		if err := writeNormalizedValue(buf, element.Value()); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(end)
+	return nil
+}
+
+func writeNormalizedValue(buf *bytes.Buffer, value bsoncore.Value) error {
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		return writeNormalizedDoc(buf, value.Document(), false)
+	case bsontype.Array:
+		return writeNormalizedDoc(buf, bsoncore.Document(value.Array()), true)
+	case bsontype.DateTime:
+		if date, ok := normalizedDate(value.DateTime()); ok {
+			fmt.Fprintf(buf, `{"$date":%q}`, date)
+			return nil
+		}
+	}
+	// Wrap the value in a one-field document and cut the value back out of {"":...}
+	wrapped := bsoncore.NewDocumentBuilder().AppendValue("", value).Build()
+	data, err := bson.MarshalExtJSON(bson.Raw(wrapped), false, false)
+	if err != nil {
+		return err
+	}
+	buf.Write(data[len(`{"":`) : len(data)-1])
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMarshalNormalizedExtJSONRoundTrip(t *testing.T) {
+	decimal, err := primitive.ParseDecimal128("1234567890.123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(year int, month time.Month, day, hour, min, sec, ms int) primitive.DateTime {
+		return primitive.NewDateTimeFromTime(time.Date(year, month, day, hour, min, sec, ms*int(time.Millisecond), time.UTC))
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string // Expected JSON of the value
+	}{
+		{name: "date after 1970", value: date(2024, 3, 1, 12, 30, 0, 250), want: `{"$date":"2024-03-01T12:30:00.25Z"}`},
+		{name: "date before 1970", value: date(1969, 12, 31, 23, 59, 59, 123), want: `{"$date":"1969-12-31T23:59:59.123Z"}`},
+		{name: "date in year 1", value: date(1, 1, 1, 0, 0, 0, 0), want: `{"$date":"0001-01-01T00:00:00Z"}`},
+		{name: "date after year 9999", value: date(12000, 6, 15, 0, 0, 0, 0), want: fmt.Sprintf(`{"$date":{"$numberLong":"%d"}}`, date(12000, 6, 15, 0, 0, 0, 0))},
+		{name: "decimal128", value: decimal, want: `{"$numberDecimal":"1234567890.123456789"}`},
+		{name: "generic binary", value: primitive.Binary{Subtype: bson.TypeBinaryGeneric, Data: []byte{1, 2, 3}}, want: `{"$binary":{"base64":"AQID","subType":"00"}}`},
+		{name: "uuid binary", value: primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: bytes.Repeat([]byte{0xab}, 16)}, want: `{"$binary":{"base64":"q6urq6urq6urq6urq6urqw==","subType":"04"}}`},
+		{name: "md5 binary", value: primitive.Binary{Subtype: bson.TypeBinaryMD5, Data: bytes.Repeat([]byte{0x01}, 16)}, want: `{"$binary":{"base64":"AQEBAQEBAQEBAQEBAQEBAQ==","subType":"05"}}`},
+		{name: "user defined binary", value: primitive.Binary{Subtype: bson.TypeBinaryUserDefined, Data: []byte("x")}, want: `{"$binary":{"base64":"eA==","subType":"80"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The value sits at the top level, in a sub-document and in an
+			// array, and next to a post-1970 date so the normalizing path is
+			// taken whenever the value needs it
+			doc, err := bson.Marshal(bson.D{
+				{Key: "v", Value: tt.value},
+				{Key: "sub", Value: bson.D{{Key: "v", Value: tt.value}}},
+				{Key: "list", Value: bson.A{tt.value, date(1960, 1, 1, 0, 0, 0, 0)}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := marshalNormalizedExtJSON(doc)
+			if err != nil {
+				t.Fatalf("marshalNormalizedExtJSON() error = %v", err)
+			}
+			want := `{"v":` + tt.want + `,"sub":{"v":` + tt.want + `},"list":[` + tt.want + `,{"$date":"1960-01-01T00:00:00Z"}]}`
+			if string(data) != want {
+				t.Errorf("marshalNormalizedExtJSON() = %s\nwant %s", data, want)
+			}
+
+			var back bson.Raw
+			if err := bson.UnmarshalExtJSON(data, false, &back); err != nil {
+				t.Fatalf("UnmarshalExtJSON(%s) error = %v", data, err)
+			}
+			if !bytes.Equal(back, doc) {
+				t.Errorf("round trip changed the document:\n got %s\nwant %s", back, bson.Raw(doc))
+			}
+		})
+	}
+}
+
+func TestMarshalNormalizedExtJSONDatesShareOneShape(t *testing.T) {
+	doc, err := bson.Marshal(bson.D{
+		{Key: "before", Value: primitive.NewDateTimeFromTime(time.Date(1950, 5, 5, 0, 0, 0, 0, time.UTC))},
+		{Key: "after", Value: primitive.NewDateTimeFromTime(time.Date(2050, 5, 5, 0, 0, 0, 0, time.UTC))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := marshalNormalizedExtJSON(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "$numberLong") {
+		t.Errorf("marshalNormalizedExtJSON() = %s, want every date as an ISO string", data)
+	}
+}
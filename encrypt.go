@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Prefix of every encrypted value, naming the cipher so consumers know how to
+// decrypt it and future algorithms can be told apart
+const encryptedPrefix = "enc:aes-256-gcm:"
+
+// Encrypts the --encrypt-fields values of decoded documents with AES-256-GCM.
+// Each value is replaced by encryptedPrefix followed by the base64 of a random
+// 12-byte nonce and the ciphertext of the value's canonical extended JSON,
+// {"v": <value>}, so decrypting gives back the exact BSON type.
+type fieldEncryptor struct {
+	aead  cipher.AEAD
+	paths [][]string // Dotted field paths, split
+}
+
+// Load the key file and set up encryption of the comma-separated fields
+func newFieldEncryptor(fields, keyFile string) (*fieldEncryptor, error) {
+	key, err := readEncryptionKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e := &fieldEncryptor{aead: aead}
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		e.paths = append(e.paths, strings.Split(field, "."))
+	}
+	if len(e.paths) == 0 {
+		return nil, fmt.Errorf("no fields given")
+	}
+	return e, nil
+}
+
+// Read a 256-bit key stored as 64 hex characters or as base64
+func readEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	text := strings.TrimSpace(string(data))
+	key, err := hex.DecodeString(text)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(text)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("key file %s must hold a 32-byte key as hex or base64 (e.g. from `openssl rand -hex 32`)", path)
+	}
+	return key, nil
+}
+
+// Encrypt the configured fields of doc in place. Fields the document doesn't
+// have are left out; a path through an array applies to each element.
+func (e *fieldEncryptor) apply(doc interface{}) error {
+	for _, path := range e.paths {
+		if err := e.encryptPath(doc, path); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", strings.Join(path, "."), err)
+		}
+	}
+	return nil
+}
+
+func (e *fieldEncryptor) encryptPath(v interface{}, path []string) error {
+	switch v := v.(type) {
+	case bson.M:
+		value, ok := v[path[0]]
+		if !ok {
+			return nil
+		}
+		if len(path) > 1 {
+			return e.encryptPath(value, path[1:])
+		}
+		sealed, err := e.seal(value)
+		if err != nil {
+			return err
+		}
+		v[path[0]] = sealed
+	case bson.D:
+		for i := range v {
+			if v[i].Key != path[0] {
+				continue
+			}
+			if len(path) > 1 {
+				return e.encryptPath(v[i].Value, path[1:])
+			}
+			sealed, err := e.seal(v[i].Value)
+			if err != nil {
+				return err
+			}
+			v[i].Value = sealed
+			return nil
+		}
+	case bson.A:
+		for _, elem := range v {
+			if err := e.encryptPath(elem, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Encrypt one value into its tagged base64 form
+func (e *fieldEncryptor) seal(value interface{}) (string, error) {
+	plaintext, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, true, false)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := e.aead.Seal(nonce, nonce, plaintext, nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
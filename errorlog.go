@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Documents skipped by --skip-errors, one JSON object per line in the
+// export directory, so they can be inspected and re-exported by hand
+const errorsFile = "errors.jsonl"
+
+type skippedDocument struct {
+	Time     time.Time       `json:"time"`
+	Worker   int             `json:"worker"`
+	Batch    int             `json:"batch"`
+	Position int             `json:"position"`     // 1-based position in the batch
+	ID       string          `json:"id,omitempty"` // Extended JSON of the _id, if it could be read
+	Stage    string          `json:"stage"`        // "decode" or "transform"
+	Error    string          `json:"error"`
+	Document json.RawMessage `json:"document,omitempty"` // Relaxed extended JSON of the source document
+	Raw      []byte          `json:"raw,omitempty"`      // Base64 of the source BSON, if it isn't valid
+}
+
+// Appends skipped documents to errorsFile, created on the first one so runs
+// without errors don't leave an empty file behind. A nil *errorLog records
+// nothing.
+type errorLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func newErrorLog(path string) *errorLog {
+	return &errorLog{path: path}
+}
+
+func (l *errorLog) record(workerID, batchNum, position int, stage string, current bson.Raw, err error) {
+	if l == nil {
+		return
+	}
+	entry := skippedDocument{
+		Time:     time.Now().UTC(),
+		Worker:   workerID,
+		Batch:    batchNum,
+		Position: position,
+		Stage:    stage,
+		Error:    err.Error(),
+	}
+	if id, idErr := current.LookupErr("_id"); idErr == nil {
+		entry.ID = id.String()
+	}
+	if doc, jsonErr := bson.MarshalExtJSON(current, false, false); jsonErr == nil {
+		entry.Document = doc
+	} else {
+		entry.Raw = append([]byte(nil), current...)
+	}
+	if err := l.append(entry); err != nil {
+		log.Printf("⚠️ Warning: Failed to record skipped document in %s: %v\n", errorsFile, err)
+	}
+}
+
+func (l *errorLog) append(entry skippedDocument) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		if l.file, err = os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+			return err
+		}
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write %s: %w", errorsFile, err)
+	}
+	return nil
+}
+
+func (l *errorLog) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import "sync"
+
+// Keeps the batch, sidecar and spool files open at once, across all workers
+// and sources, under --max-open-files. A nil *fileLimiter has no limit.
+// Files the run keeps open regardless, such as export.log, the single output
+// file and the checkpoint, aren't counted, so the limit should leave room for
+// them below the OS limit.
+type fileLimiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	max  int
+	open int
+}
+
+func newFileLimiter(max int) *fileLimiter {
+	if max == 0 {
+		return nil
+	}
+	l := &fileLimiter{max: max}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// Take a slot for a file about to be opened, waiting until one is free
+func (l *fileLimiter) acquire() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.open >= l.max {
+		l.cond.Wait()
+	}
+	l.open++
+}
+
+// Take a slot if one is free right now
+func (l *fileLimiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open >= l.max {
+		return false
+	}
+	l.open++
+	return true
+}
+
+// Give back the slot of a file that has been closed
+func (l *fileLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.open--
+	l.mu.Unlock()
+	l.cond.Signal()
+}
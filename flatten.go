@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A batch flattened into CSV rows, one column per flattened field. Columns
+// are the union of all fields in the batch, sorted, with _id first. Explicit
+// nulls render as null and fields a document lacks as missing. A document
+// has one row, or one per array element with --unwind.
+type csvTable struct {
+	columns []string
+	rows    [][][]string // By document
+}
+
+func flattenBatch(results []bson.M, flattenDepth int, null, missing string, unwind []string) *csvTable {
+	flat := make([][]map[string]string, len(results))
+	seen := map[string]bool{}
+	var columns []string
+	for i, doc := range results {
+		for _, variant := range unwindDoc(doc, unwind) {
+			row := map[string]string{}
+			flattenInto(row, "", variant, 1, flattenDepth, null)
+			flat[i] = append(flat[i], row)
+			for key := range row {
+				if !seen[key] {
+					seen[key] = true
+					columns = append(columns, key)
+				}
+			}
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		if columns[i] == "_id" || columns[j] == "_id" {
+			return columns[i] == "_id"
+		}
+		return columns[i] < columns[j]
+	})
+
+	table := &csvTable{columns: columns, rows: make([][][]string, len(flat))}
+	for i, rows := range flat {
+		for _, row := range rows {
+			record := make([]string, len(columns))
+			for j, column := range columns {
+				value, ok := row[column]
+				if !ok {
+					value = missing
+				}
+				record[j] = value
+			}
+			table.rows[i] = append(table.rows[i], record)
+		}
+	}
+	return table
+}
+
+// Parse --unwind, a comma-separated list of dotted field paths
+func parseUnwindFields(s string) ([]string, error) {
+	var fields []string
+	seen := map[string]bool{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		for _, segment := range strings.Split(field, ".") {
+			if segment == "" || strings.HasPrefix(segment, "$") {
+				return nil, fmt.Errorf("invalid field %q", field)
+			}
+		}
+		if field == "_id" || strings.HasPrefix(field, "_id.") {
+			return nil, fmt.Errorf("can't unwind _id")
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("%s is listed twice", field)
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields given")
+	}
+	return fields, nil
+}
+
+// Expand a document into one copy per element of each --unwind array, like
+// $unwind in a pipeline. Several fields are unwound in the order given, so
+// a document gets a row for every combination of their elements. A missing,
+// null or empty array leaves a single row with the field missing, so no
+// document drops out of the export, and any other value is kept as is.
+func unwindDoc(doc bson.M, fields []string) []bson.M {
+	docs := []bson.M{doc}
+	for _, field := range fields {
+		path := strings.Split(field, ".")
+		var out []bson.M
+		for _, d := range docs {
+			value, _ := lookupPath(d, path)
+			array, ok := value.(bson.A)
+			switch {
+			case !ok && value != nil:
+				out = append(out, d)
+			case len(array) == 0:
+				out = append(out, withPath(d, path, nil, false))
+			default:
+				for _, elem := range array {
+					out = append(out, withPath(d, path, elem, true))
+				}
+			}
+		}
+		docs = out
+	}
+	return docs
+}
+
+func lookupPath(doc bson.M, path []string) (interface{}, bool) {
+	value, ok := doc[path[0]]
+	if !ok || len(path) == 1 {
+		return value, ok
+	}
+	sub, isDoc := value.(bson.M)
+	if !isDoc {
+		return nil, false
+	}
+	return lookupPath(sub, path[1:])
+}
+
+// Copy doc with the field at path set to value, or removed if set is false.
+// Only the sub-documents along the path are copied, the rest is shared.
+func withPath(doc bson.M, path []string, value interface{}, set bool) bson.M {
+	out := make(bson.M, len(doc))
+	for k, v := range doc {
+		out[k] = v
+	}
+	if len(path) == 1 {
+		if set {
+			out[path[0]] = value
+		} else {
+			delete(out, path[0])
+		}
+		return out
+	}
+	if sub, ok := doc[path[0]].(bson.M); ok {
+		out[path[0]] = withPath(sub, path[1:], value, set)
+	}
+	return out
+}
+
+// Flatten a document into dotted column names. Sub-documents below
+// flattenDepth levels (0 = no limit) are kept whole as a JSON string, and
+// arrays are always a single JSON string column.
+func flattenInto(row map[string]string, prefix string, doc bson.M, level, flattenDepth int, null string) {
+	for field, value := range doc {
+		key := field
+		if prefix != "" {
+			key = prefix + "." + field
+		}
+		if sub, ok := value.(bson.M); ok && len(sub) > 0 && (flattenDepth == 0 || level < flattenDepth) {
+			flattenInto(row, key, sub, level+1, flattenDepth, null)
+			continue
+		}
+		if value == nil {
+			row[key] = null
+			continue
+		}
+		row[key] = csvValue(value)
+	}
+}
+
+// Render a single BSON value as a CSV cell
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case primitive.Decimal128:
+		return v.String()
+	case bool, int32, int64, float64:
+		return fmt.Sprint(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
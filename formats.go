@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// What each output format supports
+type formatInfo struct {
+	extension  string  // File extension, before any .gz
+	raw        bool    // Written straight from raw BSON, without decoding into bson.M
+	singleFile bool    // Can be streamed into one --single-file output
+	sizeFactor float64 // Output size relative to the BSON data size, for preflight estimates
+}
+
+var formats = map[string]formatInfo{
+	"json":  {extension: "json", singleFile: true, sizeFactor: 1.6},
+	"csv":   {extension: "csv", sizeFactor: 0.9},
+	"bson":  {extension: "bson", raw: true, sizeFactor: 1.0},
+	"ejson": {extension: "jsonl", raw: true, sizeFactor: 1.3},
+	"avro":  {extension: "avro", raw: true, sizeFactor: 0.8},
+}
+
+// Alternative names accepted by --format
+var formatAliases = map[string]string{
+	"jsonl":  "ejson",
+	"ndjson": "ejson",
+}
+
+// Rough gzip ratio for exported documents, for preflight estimates
+const gzipSizeFactor = 0.25
+
+// Formats written straight from the raw BSON, without decoding into bson.M
+func isRawFormat(format string) bool {
+	return formats[format].raw
+}
+
+// Estimated output size for this many bytes of BSON data
+func estimateOutputSize(cfg *config, dataSize int64) int64 {
+	estimate := float64(dataSize) * formats[cfg.format].sizeFactor
+	if cfg.compressEachBatch || cfg.compressFinal {
+		estimate *= gzipSizeFactor
+	}
+	return int64(estimate)
+}
+
+// Expand --format shorthands (a "-gz" suffix turns on gzip for whichever
+// output mode is in use) and reject format, compression and output mode
+// combinations that can't produce a valid export
+func validateOutput(cfg *config) error {
+	name, gz := strings.CutSuffix(cfg.format, "-gz")
+	if alias, ok := formatAliases[name]; ok {
+		name = alias
+	}
+	info, ok := formats[name]
+	if !ok {
+		return fmt.Errorf("unknown --format %q, expected one of %s, optionally with a -gz suffix", cfg.format, formatNames())
+	}
+	cfg.format = name
+	if gz {
+		if cfg.compressEachBatch || cfg.compressFinal {
+			return fmt.Errorf("--format=%s-gz already enables compression, drop --compress-each-batch/--compress-final", name)
+		}
+		if cfg.singleFile {
+			cfg.compressFinal = true
+		} else {
+			cfg.compressEachBatch = true
+		}
+	}
+
+	switch {
+	case cfg.singleFile && !info.singleFile:
+		return fmt.Errorf("--format=%s writes one file per batch and can't be used with --single-file", name)
+	case cfg.jsonRoot != "" && name != "json":
+		return fmt.Errorf("--json-root only applies to --format=json")
+	case cfg.jsonRootMeta && cfg.jsonRoot == "":
+		return fmt.Errorf("--json-root-meta requires --json-root")
+	case cfg.flattenDepth < 0:
+		return fmt.Errorf("--flatten-depth must be 0 (no limit) or more")
+	case cfg.idKey == "_id" || strings.HasPrefix(cfg.idKey, "$") || strings.Contains(cfg.idKey, "."):
+		return fmt.Errorf("--id-as must be a plain field name other than _id, without dots or a leading $")
+	case cfg.preserveFieldOrder && name != "json":
+		return fmt.Errorf("--preserve-field-order only applies to --format=json (csv columns are sorted, bson and ejson already keep field order)")
+	case cfg.maxDepth < 0:
+		return fmt.Errorf("--max-depth must be 0 (no limit) or more")
+	case cfg.maxDepth > 0 && info.raw:
+		return fmt.Errorf("--max-depth only applies to --format=json and csv, %s documents are written as stored", name)
+	case cfg.outputStdout && name != "json" && name != "ejson":
+		return fmt.Errorf("--output-stdout writes one document per line and only supports --format=json or ejson")
+	case cfg.outputStdout && (cfg.singleFile || cfg.compressEachBatch || cfg.jsonRoot != "" || cfg.fileMaxBytes > 0 || cfg.mergeOnComplete || cfg.writeManifest || cfg.distinctField != ""):
+		return fmt.Errorf("--output-stdout writes no files and can't be used with --single-file, compression (pipe into gzip instead), --json-root, --file-max-bytes, --merge-on-complete, --manifest or --distinct")
+	case !avroCodecs[cfg.avroCodec]:
+		return fmt.Errorf("unknown --avro-codec %q, expected null, deflate or snappy", cfg.avroCodec)
+	case cfg.avroCodec != "null" && name != "avro":
+		return fmt.Errorf("--avro-codec only applies to --format=avro")
+	case name == "avro" && cfg.compressEachBatch:
+		return fmt.Errorf("--format=avro compresses the blocks inside each file, use --avro-codec instead of gzip")
+	case cfg.normalizeEJSON && name != "ejson":
+		return fmt.Errorf("--normalize-ejson only applies to --format=ejson")
+	case (cfg.csvNull != "" || cfg.csvMissing != "") && name != "csv":
+		return fmt.Errorf("--csv-null and --csv-missing only apply to --format=csv")
+	case cfg.flattenDepth > 0 && name != "csv":
+		return fmt.Errorf("--flatten-depth only applies to --format=csv")
+	case cfg.fileMaxBytes < 0:
+		return fmt.Errorf("--file-max-bytes must be 0 (no limit) or more")
+	case cfg.fileMaxBytes > 0 && cfg.singleFile:
+		return fmt.Errorf("--file-max-bytes splits batch files and can't be used with --single-file")
+	case cfg.flushInterval < 0 || cfg.flushDocs < 0:
+		return fmt.Errorf("--flush-interval and --flush-docs must be 0 (disabled) or more")
+	case (cfg.flushInterval > 0 || cfg.flushDocs > 0) && !cfg.singleFile:
+		return fmt.Errorf("--flush-interval and --flush-docs require --single-file, batch files are closed after every batch")
+	case cfg.emitSequence && cfg.distinctField != "":
+		return fmt.Errorf("--emit-sequence numbers documents and can't be used with --distinct")
+	case cfg.emitSidecars && (cfg.singleFile || cfg.outputStdout):
+		return fmt.Errorf("--emit-sidecars describes batch files and can't be used with --single-file or --output-stdout")
+	case cfg.withMetadata && (cfg.outputStdout || cfg.distinctField != ""):
+		return fmt.Errorf("--with-metadata describes a collection export and can't be used with --output-stdout or --distinct")
+	case cfg.mergeOnComplete && cfg.singleFile:
+		return fmt.Errorf("--merge-on-complete has nothing to merge with --single-file")
+	case cfg.preserveOrder && !cfg.singleFile:
+		return fmt.Errorf("--preserve-order requires --single-file")
+	case cfg.compressEachBatch && cfg.compressFinal:
+		return fmt.Errorf("--compress-each-batch and --compress-final are mutually exclusive")
+	case cfg.compressFinal && !cfg.singleFile:
+		return fmt.Errorf("--compress-final requires --single-file")
+	case cfg.compressEachBatch && cfg.singleFile:
+		return fmt.Errorf("--compress-each-batch can't be used with --single-file, use --compress-final instead")
+	}
+	return nil
+}
+
+// Sorted list of format names for messages
+func formatNames() string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
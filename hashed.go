@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// One chunk of a hashed shard key, scanned in a single pass by
+// --hashed-shard-scan
+type hashedChunk struct {
+	index    int      // Position among the collection's chunks, in shard key order
+	min, max bson.Raw // Shard key bounds, min inclusive and max exclusive
+	shard    string   // Owner when the scan was planned
+}
+
+// Progress of a --hashed-shard-scan, saved in checkpoint.json. The chunk
+// bounds are kept as planned, since they stay valid ranges of the hashed
+// index however the chunks are split or migrated later.
+type hashedCheckpoint struct {
+	Key     string            `json:"key"`     // Shard key pattern, as extended JSON
+	Bounds  []json.RawMessage `json:"bounds"`  // Chunk i spans Bounds[i] to Bounds[i+1], as canonical extended JSON, which keeps MinKey, MaxKey and NumberLong hashes exact
+	Shards  []string          `json:"shards"`  // Owner of each chunk when the scan was planned
+	Workers [][]int           `json:"workers"` // Chunks each worker scans, in order
+	Done    []int             `json:"done"`    // Chunks each worker has finished
+	Batches []int             `json:"batches"` // Batches each worker has written
+}
+
+// Saves each worker's finished chunks in the checkpoint state. A nil
+// tracker saves nothing.
+type hashedTracker struct {
+	mu    sync.Mutex
+	state *checkpointState
+	key   string
+}
+
+// The shard key to scan by for --hashed-shard-scan, if the collection is
+// sharded on a hashed key. Returns nil, after logging why, when the export
+// should paginate by _id as usual.
+func detectHashedShardKey(collection *mongo.Collection) (bson.D, error) {
+	meta, err := loadShardingMeta(collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the shard key: %w", err)
+	}
+	if meta == nil {
+		log.Printf("🧩 %s isn't sharded, or this isn't a mongos, so --hashed-shard-scan paginates by _id\n", collection.Name())
+		return nil, nil
+	}
+	for _, elem := range meta.Key {
+		if elem.Value == "hashed" {
+			log.Printf("🧩 %s is sharded on the hashed key %s, scanning it chunk by chunk\n", collection.Name(), shardKeyString(meta.Key))
+			return meta.Key, nil
+		}
+	}
+	log.Printf("🧩 %s is sharded on the ranged key %s, so --hashed-shard-scan paginates by _id\n", collection.Name(), shardKeyString(meta.Key))
+	return nil, nil
+}
+
+func shardKeyString(key bson.D) string {
+	data, err := bson.MarshalExtJSON(key, false, false)
+	if err != nil {
+		return fmt.Sprint(key)
+	}
+	return string(data)
+}
+
+// Work items for a --hashed-shard-scan: the collection's chunks grouped by
+// the shard that owns them, so each worker reads from one shard, or from a
+// few one after another when there are more shards than workers. A
+// checkpointed scan resumes the plan saved by the previous run.
+func planHashedScan(cfg *config, collection *mongo.Collection, key bson.D, state *checkpointState, stateKey string) ([]workItem, error) {
+	if !cfg.noCheckpoint {
+		cfg.hashed = &hashedTracker{state: state, key: stateKey}
+		if saved := state.Hashed[stateKey]; saved != nil {
+			return resumeHashedScan(key, saved)
+		}
+	}
+
+	chunks, err := readHashedChunks(collection)
+	if err != nil {
+		return nil, err
+	}
+	n := min(max(cfg.workers, 1), len(chunks))
+	order := make([]hashedChunk, len(chunks))
+	copy(order, chunks)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].shard < order[j].shard })
+	saved := &hashedCheckpoint{Key: shardKeyString(key), Workers: make([][]int, n), Done: make([]int, n), Batches: make([]int, n)}
+	for w := range saved.Workers {
+		for _, chunk := range order[w*len(order)/n : (w+1)*len(order)/n] {
+			saved.Workers[w] = append(saved.Workers[w], chunk.index)
+		}
+	}
+	bounds := make([]bson.Raw, 0, len(chunks)+1)
+	for _, chunk := range chunks {
+		bounds = append(bounds, chunk.min)
+		saved.Shards = append(saved.Shards, chunk.shard)
+	}
+	bounds = append(bounds, chunks[len(chunks)-1].max)
+	for _, bound := range bounds {
+		data, err := canonicalJSON(bound)
+		if err != nil {
+			return nil, err
+		}
+		saved.Bounds = append(saved.Bounds, data)
+	}
+	log.Printf("🧩 Partitioning strategy: hashed chunks, %d chunks over %d workers\n", len(chunks), n)
+
+	if cfg.hashed != nil {
+		if state.Hashed == nil {
+			state.Hashed = map[string]*hashedCheckpoint{}
+		}
+		state.Hashed[stateKey] = saved
+		if err := saveCheckpointState(state); err != nil {
+			return nil, fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+	}
+	return hashedItems(key, chunks, saved), nil
+}
+
+// Rebuild the work items of a saved scan, checking it was planned for the
+// same shard key
+func resumeHashedScan(key bson.D, saved *hashedCheckpoint) ([]workItem, error) {
+	if saved.Key != shardKeyString(key) {
+		return nil, fmt.Errorf("%s holds a hashed scan by %s, but the collection is now sharded on %s; export into a fresh directory", checkpointFile, saved.Key, shardKeyString(key))
+	}
+	n := len(saved.Workers)
+	if len(saved.Bounds) < 2 || len(saved.Shards) != len(saved.Bounds)-1 || len(saved.Done) != n || len(saved.Batches) != n {
+		return nil, fmt.Errorf("hashed scan in %s is inconsistent", checkpointFile)
+	}
+	chunks := make([]hashedChunk, len(saved.Shards))
+	bounds := make([]bson.Raw, len(saved.Bounds))
+	for i, bound := range saved.Bounds {
+		if err := bson.UnmarshalExtJSON(bound, true, &bounds[i]); err != nil {
+			return nil, fmt.Errorf("invalid chunk bound %s in %s: %w", bound, checkpointFile, err)
+		}
+	}
+	for i := range chunks {
+		chunks[i] = hashedChunk{index: i, min: bounds[i], max: bounds[i+1], shard: saved.Shards[i]}
+	}
+	left := 0
+	for w, indexes := range saved.Workers {
+		if saved.Done[w] > len(indexes) {
+			return nil, fmt.Errorf("hashed scan in %s is inconsistent", checkpointFile)
+		}
+		for _, i := range indexes {
+			if i < 0 || i >= len(chunks) {
+				return nil, fmt.Errorf("hashed scan in %s is inconsistent", checkpointFile)
+			}
+		}
+		left += len(indexes) - saved.Done[w]
+	}
+	if left == 0 {
+		log.Printf("📭 Every chunk of the hashed scan in %s is exported. A finished scan can't pick up documents inserted since, so export into a fresh directory to scan again.\n", checkpointFile)
+	} else {
+		log.Printf("🔁 Resuming the hashed scan saved in %s, %d of %d chunks left\n", checkpointFile, left, len(chunks))
+	}
+	return hashedItems(key, chunks, saved), nil
+}
+
+func hashedItems(key bson.D, chunks []hashedChunk, saved *hashedCheckpoint) []workItem {
+	items := make([]workItem, len(saved.Workers))
+	for w, indexes := range saved.Workers {
+		items[w] = workItem{partition: w, firstBatch: saved.Batches[w] + 1, shardKey: key, chunksDone: saved.Done[w]}
+		shards := map[string]bool{}
+		for _, i := range indexes {
+			items[w].chunks = append(items[w].chunks, chunks[i])
+			shards[chunks[i].shard] = true
+		}
+		names := make([]string, 0, len(shards))
+		for shard := range shards {
+			names = append(names, shard)
+		}
+		sort.Strings(names)
+		log.Printf("🧩 Worker %d: %d chunks on %v\n", w, len(indexes), names)
+	}
+	return items
+}
+
+// The collection's chunks in shard key order, checked to cover the whole
+// key range without gaps
+func readHashedChunks(collection *mongo.Collection) ([]hashedChunk, error) {
+	meta, err := loadShardingMeta(collection)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("%s is no longer sharded", collection.Name())
+	}
+	config := collection.Database().Client().Database("config")
+	cursor, err := config.Collection("chunks").Find(
+		context.TODO(),
+		meta.chunks(detectServer(collection.Database().Client())),
+		options.Find().SetSort(bson.D{{Key: "min", Value: 1}}).SetProjection(bson.M{"min": 1, "max": 1, "shard": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunks: %w", err)
+	}
+	defer cursor.Close(context.TODO())
+	var chunks []hashedChunk
+	for cursor.Next(context.TODO()) {
+		minKey, ok1 := cursor.Current.Lookup("min").DocumentOK()
+		maxKey, ok2 := cursor.Current.Lookup("max").DocumentOK()
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("chunk without min and max bounds in config.chunks")
+		}
+		shard, _ := cursor.Current.Lookup("shard").StringValueOK()
+		if len(chunks) > 0 && !bytes.Equal(chunks[len(chunks)-1].max, minKey) {
+			return nil, fmt.Errorf("chunks of %s changed while they were read, try again", collection.Name())
+		}
+		chunks = append(chunks, hashedChunk{index: len(chunks), min: minKey, max: maxKey, shard: shard})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chunks: %w", err)
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no chunks found for %s in config.chunks", collection.Name())
+	}
+	return chunks, nil
+}
+
+// Export the work item's chunks that aren't finished yet, each in one pass
+// over the shard key's index between its bounds. Only the shard owning a
+// chunk has documents in the range, so the read stays on that shard instead
+// of scattering as an _id range does. A chunk is checkpointed once it's
+// fully written, and an interrupted one is exported again from its start,
+// overwriting its batch files.
+func exportHashedChunks(workerID int, cfg *config, collection *mongo.Collection, writer batchWriter, summary *exportSummary, item workItem) error {
+	batchNum := max(item.firstBatch, 1)
+	for done := item.chunksDone; done < len(item.chunks); done++ {
+		chunk := item.chunks[done]
+		log.Printf("🧩 Worker %d: Scanning chunk %d on %s\n", workerID, chunk.index, chunk.shard)
+		var err error
+		if batchNum, err = exportHashedChunk(workerID, batchNum, cfg, collection, writer, summary, chunk, item.shardKey); err != nil {
+			return fmt.Errorf("chunk %d: %w", chunk.index, err)
+		}
+		cfg.hashed.save(workerID, done+1, batchNum-1)
+	}
+	return nil
+}
+
+// Export one chunk, returning the number of the next batch
+func exportHashedChunk(workerID, batchNum int, cfg *config, collection *mongo.Collection, writer batchWriter, summary *exportSummary, chunk hashedChunk, key bson.D) (int, error) {
+	// min and max bound the hinted index's keys, which for a hashed field are
+	// the hashes the chunk bounds are given in
+	findOpts := options.Find().SetHint(key).SetMin(chunk.min).SetMax(chunk.max).SetNoCursorTimeout(cfg.noCursorTimeout)
+	withProjection(cfg, findOpts)
+	ctx, cancel := opContext(cfg)
+	cursor, err := collection.Find(ctx, andFilters(cfg.filter, nil), findOpts)
+	cancel()
+	if err != nil {
+		return batchNum, describeOpError(cfg, "fetch data", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	for {
+		if !cfg.batches.take() {
+			more, err := cursorHasMore(cfg, cursor)
+			if err != nil {
+				return batchNum, describeOpError(cfg, "check for more data", err)
+			}
+			if !more {
+				return batchNum, nil
+			}
+			return batchNum, errBatchLimit
+		}
+		cfg.lag.wait()
+		ctx, cancel := opContext(cfg)
+		b, err := readBatch(ctx, workerID, batchNum, cfg.sizer.next(), cfg, cursor)
+		cancel()
+		if err != nil {
+			return batchNum, describeOpError(cfg, "decode batch", err)
+		}
+		if b.scanned == 0 {
+			cfg.batches.giveBack()
+			return batchNum, nil
+		}
+		if b.count() == 0 {
+			cfg.batches.giveBack()
+		}
+
+		// Hash order isn't _id order, so the manifest records no last_id,
+		// as for tail batches
+		lastID := b.lastID
+		b.lastID = docID{}
+		logSamples(workerID, cfg, b)
+		if b.count() > 0 {
+			filePath, err := writer.WriteBatch(workerID, batchNum, b)
+			if err != nil {
+				return batchNum, err
+			}
+			log.Printf("✅ Worker %d: Exported batch %d (%d records) -> %s\n", workerID, batchNum, b.count(), filePath)
+		}
+		summary.addBatch(b.count(), b.scanned-b.count()-b.duplicates, b.duplicates)
+		cfg.progress.addBatch(b.count(), lastID)
+		cfg.heartbeat.addBatch(b.count(), lastID)
+		if b.duplicates > 0 {
+			log.Printf("⚠️ Worker %d: Dropped %d documents with already exported _ids from batch %d\n", workerID, b.duplicates, batchNum)
+		}
+		batchNum++
+	}
+}
+
+// Record that a worker has finished done of its chunks, having written
+// batches batches
+func (t *hashedTracker) save(worker, done, batches int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	saved := t.state.Hashed[t.key]
+	saved.Done[worker] = done
+	saved.Batches[worker] = batches
+	if err := saveCheckpointState(t.state); err != nil {
+		log.Printf("⚠️ Warning: Failed to save hashed scan checkpoint: %v\n", err)
+	}
+}
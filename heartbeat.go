@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Logs that the run is still alive every --heartbeat-interval, so a batch
+// that takes minutes to fetch or write doesn't look like a hang between the
+// per-batch log lines. A nil heartbeat does nothing.
+type heartbeat struct {
+	mu      sync.Mutex
+	stop    chan struct{}
+	stopped sync.Once
+	done    chan struct{}
+
+	collection string // database.collection being exported
+	documents  int64  // Across every collection of the run
+	lastID     docID  // _id the most recent batch of the collection ended at
+	started    time.Time
+	lastBatch  time.Time // Zero until a batch is written
+}
+
+// Start logging in the background. Returns nil if interval is 0.
+func startHeartbeat(interval time.Duration) *heartbeat {
+	if interval == 0 {
+		return nil
+	}
+	h := &heartbeat{stop: make(chan struct{}), done: make(chan struct{}), started: time.Now()}
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.log()
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+	return h
+}
+
+// Name the collection being exported in later heartbeats, whose _ids start over
+func (h *heartbeat) startCollection(database, name string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.collection, h.lastID = database+"."+name, docID{}
+}
+
+// Count a written batch
+func (h *heartbeat) addBatch(documents int, lastID docID) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.documents += int64(documents)
+	h.lastBatch = time.Now()
+	if !lastID.IsZero() {
+		h.lastID = lastID
+	}
+}
+
+// Stop logging, waiting for a heartbeat being logged to finish, so none
+// follows the run's final lines. Only the first call does anything.
+func (h *heartbeat) finish() {
+	if h == nil {
+		return
+	}
+	h.stopped.Do(func() {
+		close(h.stop)
+		<-h.done
+	})
+}
+
+func (h *heartbeat) log() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	collection := h.collection
+	if collection == "" {
+		collection = "the export"
+	}
+	if h.lastBatch.IsZero() {
+		log.Printf("💓 Still working on %s: no batch written yet, %s since the run started\n", collection, time.Since(h.started).Round(time.Second))
+		return
+	}
+	log.Printf("💓 Still working on %s: %d documents so far, current _id %s, last batch %s ago\n",
+		collection, h.documents, idString(h.lastID, "none"), time.Since(h.lastBatch).Round(time.Second))
+}
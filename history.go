@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Append-only log of checkpoint advances, one JSON object per line. Once it
+// holds --checkpoint-history entries it's gzipped to historyFile.1.gz,
+// replacing the previous generation, and a new log is started.
+const historyFile = "checkpoint_history.jsonl"
+
+type historyEntry struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"` // "resume" when a run starts from the checkpoint, "save" when it advances
+	Worker int       `json:"worker"`
+	Batch  int       `json:"batch,omitempty"`
+	LastID string    `json:"last_id"`
+}
+
+type checkpointHistory struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries int
+	max     int
+}
+
+// Open the history log for appending, counting the entries already in it
+func openCheckpointHistory(path string, max int) (*checkpointHistory, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	h := &checkpointHistory{path: path, file: file, max: max}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		h.entries++
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// Append an entry, rotating the log first if it's full
+func (h *checkpointHistory) record(event string, workerID, batchNum int, lastID docID) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.entries >= h.max {
+		if err := h.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate %s: %w", h.path, err)
+		}
+	}
+	data, err := json.Marshal(historyEntry{Time: time.Now().UTC(), Event: event, Worker: workerID, Batch: batchNum, LastID: lastID.String()})
+	if err != nil {
+		return err
+	}
+	if _, err := h.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	h.entries++
+	return nil
+}
+
+// Compress the full log into its .1.gz generation and start an empty one
+func (h *checkpointHistory) rotate() error {
+	if _, err := h.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename succeeds
+	gz := gzip.NewWriter(tmp)
+	if _, err := io.Copy(gz, h.file); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), h.path+".1.gz"); err != nil {
+		return err
+	}
+	if err := h.file.Truncate(0); err != nil {
+		return err
+	}
+	h.entries = 0
+	return nil
+}
+
+func (h *checkpointHistory) close() error {
+	return h.file.Close()
+}
+
+// Log a checkpoint event if --checkpoint-history is set. Failures only warn,
+// the history is an audit trail and never blocks the export.
+func recordHistory(cfg *config, event string, workerID, batchNum int, lastID docID) {
+	if cfg.history == nil {
+		return
+	}
+	if err := cfg.history.record(event, workerID, batchNum, lastID); err != nil {
+		log.Printf("⚠️ Warning: Failed to record checkpoint history: %v\n", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// _id types an export can paginate and resume on, chosen with --id-type
+const (
+	idTypeObjectID = "objectid"
+	idTypeUUID     = "uuid"
+)
+
+// An _id that pagination, checkpoints and the manifest keep track of: an
+// ObjectID, or a UUID stored as binary subtype 4. The zero value is unset.
+type docID struct {
+	uuid bool
+	data [16]byte // An ObjectID fills the first 12 bytes
+}
+
+func objectDocID(id primitive.ObjectID) docID {
+	var d docID
+	copy(d.data[:], id[:])
+	return d
+}
+
+// The _id of a raw document as a docID, if it's an ObjectID or a UUID
+func rawDocID(value bson.RawValue) (docID, bool) {
+	if id, ok := value.ObjectIDOK(); ok {
+		return objectDocID(id), true
+	}
+	if value.Type == bsontype.Binary {
+		subtype, data := value.Binary()
+		if subtype == bson.TypeBinaryUUID && len(data) == 16 {
+			d := docID{uuid: true}
+			copy(d.data[:], data)
+			return d, true
+		}
+	}
+	return docID{}, false
+}
+
+// Parse an _id as checkpoints store it: the hex form of an ObjectID, or a
+// UUID in its canonical 8-4-4-4-12 form with --id-type=uuid
+func parseDocID(s, idType string) (docID, error) {
+	if idType != idTypeUUID {
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			return docID{}, err
+		}
+		return objectDocID(id), nil
+	}
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return docID{}, fmt.Errorf("%q is not a UUID in the form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", s)
+	}
+	d := docID{uuid: true}
+	if _, err := hex.Decode(d.data[:], []byte(strings.ReplaceAll(s, "-", ""))); err != nil {
+		return docID{}, fmt.Errorf("%q is not a UUID: %w", s, err)
+	}
+	return d, nil
+}
+
+func (d docID) IsZero() bool {
+	return d == docID{}
+}
+
+// The ObjectID, zero for a UUID
+func (d docID) objectID() primitive.ObjectID {
+	var id primitive.ObjectID
+	if !d.uuid {
+		copy(id[:], d.data[:12])
+	}
+	return id
+}
+
+// The _id as stored, for query filters
+func (d docID) value() interface{} {
+	if d.uuid {
+		return primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: append([]byte(nil), d.data[:]...)}
+	}
+	return d.objectID()
+}
+
+// Order two _ids the way MongoDB sorts them. UUIDs of the same length and
+// subtype compare byte by byte, and binary values sort before ObjectIDs.
+func (d docID) compare(other docID) int {
+	if d.uuid != other.uuid {
+		if d.uuid {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(d.data[:], other.data[:])
+}
+
+// Hex form of an ObjectID, canonical form of a UUID
+func (d docID) String() string {
+	if !d.uuid {
+		return d.objectID().Hex()
+	}
+	s := hex.EncodeToString(d.data[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
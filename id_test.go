@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseDocIDUUIDRoundTrip(t *testing.T) {
+	tests := []string{
+		"00000000-0000-0000-0000-000000000001",
+		"123e4567-e89b-12d3-a456-426614174000",
+		"ffffffff-ffff-ffff-ffff-ffffffffffff",
+	}
+	for _, s := range tests {
+		id, err := parseDocID(s, idTypeUUID)
+		if err != nil {
+			t.Fatalf("parseDocID(%q) error = %v", s, err)
+		}
+		if !id.uuid {
+			t.Errorf("parseDocID(%q) isn't a UUID", s)
+		}
+		if got := id.String(); got != s {
+			t.Errorf("parseDocID(%q).String() = %q", s, got)
+		}
+		// Upper case parses to the same _id and formats back in lower case
+		upper, err := parseDocID(strings.ToUpper(s), idTypeUUID)
+		if err != nil || upper != id {
+			t.Errorf("parseDocID(%q) = %s, %v, want %s", strings.ToUpper(s), upper, err, s)
+		}
+	}
+}
+
+func TestParseDocIDUUIDInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"123e4567e89b12d3a456426614174000",      // No dashes
+		"123e4567-e89b-12d3-a456-42661417400",   // Too short
+		"123e4567-e89b-12d3-a456-4266141740000", // Too long
+		"123e4567-e89b-12d3-a456_426614174000",  // Wrong separator
+		"123e4567-e89b-12d3-a456-42661417400g",  // Not hex
+		primitive.NewObjectID().Hex(),
+	}
+	for _, s := range tests {
+		if id, err := parseDocID(s, idTypeUUID); err == nil {
+			t.Errorf("parseDocID(%q) = %s, want an error", s, id)
+		}
+	}
+}
+
+func TestRawDocIDUUID(t *testing.T) {
+	data := []byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	tests := []struct {
+		name  string
+		value interface{}
+		ok    bool
+	}{
+		{name: "uuid", value: primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: data}, ok: true},
+		{name: "legacy uuid subtype", value: primitive.Binary{Subtype: bson.TypeBinaryUUIDOld, Data: data}},
+		{name: "generic binary", value: primitive.Binary{Subtype: bson.TypeBinaryGeneric, Data: data}},
+		{name: "short uuid", value: primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: data[:8]}},
+		{name: "string", value: "123e4567-e89b-12d3-a456-426614174000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(bson.D{{Key: "_id", Value: tt.value}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, ok := rawDocID(bson.Raw(raw).Lookup("_id"))
+			if ok != tt.ok {
+				t.Fatalf("rawDocID() ok = %t, want %t", ok, tt.ok)
+			}
+			if ok && id.String() != "123e4567-e89b-12d3-a456-426614174000" {
+				t.Errorf("rawDocID() = %s", id)
+			}
+			// The value used in filters is the binary the _id was read from
+			if ok {
+				if b, isBinary := id.value().(primitive.Binary); !isBinary || !b.Equal(tt.value.(primitive.Binary)) {
+					t.Errorf("value() = %v, want %v", id.value(), tt.value)
+				}
+			}
+		})
+	}
+}
+
+func TestDocIDCompareUUID(t *testing.T) {
+	parse := func(s string) docID {
+		t.Helper()
+		id, err := parseDocID(s, idTypeUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	low := parse("00000000-0000-0000-0000-0000000000ff")
+	high := parse("00000000-0000-0000-0000-000000000100")
+	top := parse("ff000000-0000-0000-0000-000000000000")
+	oid := objectDocID(primitive.NewObjectID())
+
+	tests := []struct {
+		name string
+		a, b docID
+		want int
+	}{
+		{name: "equal", a: low, b: low, want: 0},
+		{name: "compared byte by byte", a: low, b: high, want: -1},
+		{name: "first byte decides", a: top, b: high, want: 1},
+		{name: "uuid before ObjectID", a: top, b: oid, want: -1},
+		{name: "ObjectID after uuid", a: oid, b: low, want: 1},
+	}
+	for _, tt := range tests {
+		if got := tt.a.compare(tt.b); got != tt.want {
+			t.Errorf("%s: %s.compare(%s) = %d, want %d", tt.name, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLastIDFileUUID(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{checkpointDir: dir, idType: idTypeUUID}
+	id, err := parseDocID("123e4567-e89b-12d3-a456-426614174000", idTypeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saveLastID(cfg, id)
+	data, err := os.ReadFile(filepath.Join(dir, lastIDFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != id.String() {
+		t.Errorf("%s = %q, want %q", lastIDFile, data, id.String())
+	}
+	if got := loadLastID(cfg); got != id {
+		t.Errorf("loadLastID() = %s, want %s", idString(got, "start"), id)
+	}
+
+	// Read back with the wrong --id-type, the checkpoint isn't trusted
+	cfg.idType = idTypeObjectID
+	if got := loadLastID(cfg); !got.IsZero() {
+		t.Errorf("loadLastID() with --id-type=objectid = %s, want none", got)
+	}
+}
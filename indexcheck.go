@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Prefix of the indexes --create-temp-index creates, so one left behind by
+// an aborted run is recognized and dropped by the next
+const tempIndexPrefix = "bulk_exporter_tmp_"
+
+// Fields the export sorts on: _id for pagination, or the timeField of a
+// time-series scan, and the delta field, whose watermark is looked up by a
+// sort and which the delta filter ranges over
+func sortFields(cfg *config) []string {
+	var fields []string
+	switch {
+	case cfg.timeField != "":
+		fields = append(fields, cfg.timeField)
+	case !cfg.naturalOrder:
+		fields = append(fields, "_id")
+	}
+	if cfg.deltaField != "" {
+		fields = append(fields, cfg.deltaField)
+	}
+	return fields
+}
+
+// For --preflight-index-check, confirm an ascending or descending index
+// leads with each field the export sorts on, since a B-tree index serves a
+// single-field sort in either direction. A missing one is an error, or with
+// --create-temp-index is created for the run. Returns the temporary indexes
+// to drop once the export is done.
+func checkSortIndexes(cfg *config, collection *mongo.Collection) ([]string, error) {
+	leading, err := leadingIndexes(collection)
+	if err != nil {
+		return nil, err
+	}
+	var temp []string
+	for _, field := range sortFields(cfg) {
+		if name, ok := leading[field]; ok {
+			if strings.HasPrefix(name, tempIndexPrefix) && cfg.createTempIndex {
+				log.Printf("🗂️ Reusing temporary index %s on %s, left by an earlier run\n", name, field)
+				temp = append(temp, name)
+				continue
+			}
+			log.Printf("🗂️ Sort on %s is backed by index %s\n", field, name)
+			continue
+		}
+		if !cfg.createTempIndex {
+			dropTempIndexes(collection, temp)
+			return nil, fmt.Errorf("no index on %s leads with %s, so sorting on it would scan and sort the whole collection (create one, or pass --create-temp-index)", collection.Name(), field)
+		}
+		name := tempIndexPrefix + strings.ReplaceAll(field, ".", "_")
+		log.Printf("🏗️ Creating temporary index %s on %s, which is dropped once the export finishes...\n", name, field)
+		model := mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}}, Options: options.Index().SetName(name)}
+		if _, err := collection.Indexes().CreateOne(context.TODO(), model); err != nil {
+			dropTempIndexes(collection, temp)
+			return nil, fmt.Errorf("failed to create a temporary index on %s: %w", field, err)
+		}
+		temp = append(temp, name)
+	}
+	return temp, nil
+}
+
+// The name of an ascending or descending index leading with each field.
+// Hashed, text and geo indexes can't serve a range sort, so they don't count.
+func leadingIndexes(collection *mongo.Collection) (map[string]string, error) {
+	cursor, err := collection.Indexes().List(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+	defer cursor.Close(context.TODO())
+	leading := map[string]string{}
+	for cursor.Next(context.TODO()) {
+		keys, ok := cursor.Current.Lookup("key").DocumentOK()
+		if !ok {
+			continue
+		}
+		elems, err := keys.Elements()
+		if err != nil || len(elems) == 0 {
+			continue
+		}
+		switch elems[0].Value().Type {
+		case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble:
+			name, _ := cursor.Current.Lookup("name").StringValueOK()
+			// An index of the user's wins over a leftover temporary one
+			if existing, seen := leading[elems[0].Key()]; !seen || strings.HasPrefix(existing, tempIndexPrefix) {
+				leading[elems[0].Key()] = name
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+	return leading, nil
+}
+
+// Drop the indexes --create-temp-index created, warning about any that
+// can't be, since they'd otherwise stay on the collection
+func dropTempIndexes(collection *mongo.Collection, names []string) {
+	for _, name := range names {
+		if _, err := collection.Indexes().DropOne(context.TODO(), name); err != nil {
+			log.Printf("⚠️ Warning: Failed to drop temporary index %s on %s, drop it by hand: %v\n", name, collection.Name(), err)
+			continue
+		}
+		log.Printf("🧹 Dropped temporary index %s\n", name)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// One database of the list-databases output
+type databaseListing struct {
+	Database    string `json:"database"`
+	Collections int64  `json:"collections"`
+	Documents   int64  `json:"documents"`
+	DataSize    int64  `json:"data_size"`
+	StorageSize int64  `json:"storage_size"`
+	Error       string `json:"error,omitempty"` // Why the sizes are missing, e.g. no dbStats privilege
+}
+
+// One collection of the list-collections output
+type collectionListing struct {
+	Collection  string `json:"collection"`
+	Type        string `json:"type"` // "collection", "view" or "timeseries"
+	Documents   int64  `json:"documents"`
+	DataSize    int64  `json:"data_size"`
+	StorageSize int64  `json:"storage_size"`
+	Error       string `json:"error,omitempty"` // Why the sizes are missing
+}
+
+// Subset of the dbStats command output
+type dbStats struct {
+	Collections int64 `bson:"collections"`
+	Objects     int64 `bson:"objects"`
+	DataSize    int64 `bson:"dataSize"`
+	StorageSize int64 `bson:"storageSize"`
+}
+
+// Flags shared by the list subcommands
+func newListFlags(name, usage string, cfg *config) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n", os.Args[0], usage)
+		fs.PrintDefaults()
+	}
+	addConnectionFlags(fs, cfg)
+	output := fs.String("output", "table", "output format: table or json")
+	return fs, output
+}
+
+// The list-databases subcommand: print the databases the user may read, with
+// their collection and document counts and sizes
+func runListDatabases(args []string) error {
+	cfg := &config{}
+	fs, output := newListFlags("list-databases", "list-databases", cfg)
+	fs.Parse(args)
+	if err := validateListFlags(cfg, fs, *output); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	mongoURI := promptURI(cfg, reader)
+
+	client, err := connectMongo(cfg, mongoURI)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.TODO())
+
+	// Users without the listDatabases action still see the databases they
+	// have privileges on
+	names, err := client.ListDatabaseNames(context.TODO(), bson.D{}, options.ListDatabases().SetAuthorizedDatabases(true))
+	if err != nil {
+		return fmt.Errorf("failed to list databases: %w", err)
+	}
+	sort.Strings(names)
+
+	listings := make([]databaseListing, 0, len(names))
+	for _, name := range names {
+		listing := databaseListing{Database: name}
+		var stats dbStats
+		if err := client.Database(name).RunCommand(context.TODO(), bson.D{{Key: "dbStats", Value: 1}}).Decode(&stats); err != nil {
+			listing.Error = err.Error()
+		} else {
+			listing.Collections, listing.Documents = stats.Collections, stats.Objects
+			listing.DataSize, listing.StorageSize = stats.DataSize, stats.StorageSize
+		}
+		listings = append(listings, listing)
+	}
+
+	if *output == "json" {
+		return printJSON(listings)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATABASE\tCOLLECTIONS\tDOCUMENTS\tDATA SIZE\tSTORAGE SIZE")
+	for _, l := range listings {
+		if l.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\n", l.Database)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", l.Database, l.Collections, l.Documents, formatBytes(l.DataSize), formatBytes(l.StorageSize))
+	}
+	return w.Flush()
+}
+
+// The list-collections subcommand: print every collection and view of a
+// database with its document count and sizes
+func runListCollections(args []string) error {
+	cfg := &config{}
+	fs, output := newListFlags("list-collections", "list-collections", cfg)
+	fs.Parse(args)
+	if err := validateListFlags(cfg, fs, *output); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	mongoURI := promptURI(cfg, reader)
+	dbName := promptLine(reader, "Enter database name: ")
+
+	client, err := connectMongo(cfg, mongoURI)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.TODO())
+	db := client.Database(dbName)
+
+	// authorizedCollections lists what the user may see without needing the
+	// listCollections action on the whole database
+	specs, err := db.ListCollectionSpecifications(context.TODO(), bson.D{}, options.ListCollections().SetAuthorizedCollections(true))
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %w", err)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+
+	listings := make([]collectionListing, 0, len(specs))
+	for _, spec := range specs {
+		listing := collectionListing{Collection: spec.Name, Type: spec.Type}
+		// Views have no storage of their own
+		if spec.Type != "view" {
+			if stats, err := fetchCollStats(db, spec.Name); err != nil {
+				listing.Error = err.Error()
+			} else {
+				listing.Documents, listing.DataSize, listing.StorageSize = stats.Count, stats.Size, stats.StorageSize
+			}
+		}
+		listings = append(listings, listing)
+	}
+
+	if *output == "json" {
+		return printJSON(listings)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tTYPE\tDOCUMENTS\tDATA SIZE\tSTORAGE SIZE")
+	for _, l := range listings {
+		if l.Type == "view" || l.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\n", l.Collection, l.Type)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", l.Collection, l.Type, l.Documents, formatBytes(l.DataSize), formatBytes(l.StorageSize))
+	}
+	return w.Flush()
+}
+
+func validateListFlags(cfg *config, fs *flag.FlagSet, output string) error {
+	if err := validateConnectionFlags(cfg); err != nil {
+		return err
+	}
+	if output != "table" && output != "json" {
+		return fmt.Errorf("unknown --output %q, expected table or json", output)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	return nil
+}
+
+// Print v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
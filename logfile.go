@@ -0,0 +1,124 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// export.log, rotated once it would grow past --log-max-bytes. The full log
+// becomes export.log.1, older segments move up a number and the oldest past
+// --log-keep is removed. With --log-compress rotated segments are gzipped to
+// export.log.1.gz and so on.
+type rotatingLog struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	maxBytes int64 // 0 never rotates
+	keep     int
+	compress bool
+}
+
+// Open the log for appending. Its current size counts towards the limit, so
+// a log shared by many short runs rotates as well as one long run's.
+func openRotatingLog(path string, maxBytes int64, keep int, compress bool) (*rotatingLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &rotatingLog{path: path, file: file, size: info.Size(), maxBytes: maxBytes, keep: keep, compress: compress}, nil
+}
+
+// Write a log line, rotating first if it would take the log past the limit.
+// A failed rotation only warns on the console and keeps the current log, so
+// logging never stops.
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(console, "⚠️ Warning: Failed to rotate %s: %v\n", l.path, err)
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *rotatingLog) rotate() error {
+	// Make room for segment 1. A segment may be plain or gzipped, depending
+	// on --log-compress when it was rotated.
+	for _, ext := range []string{"", ".gz"} {
+		if err := os.Remove(l.segment(l.keep) + ext); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for i := l.keep - 1; i >= 1; i-- {
+			if err := os.Rename(l.segment(i)+ext, l.segment(i+1)+ext); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(l.path, l.segment(1))
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	if renameErr != nil {
+		return renameErr
+	}
+	l.size = 0
+	if l.compress {
+		return gzipFile(l.segment(1))
+	}
+	return nil
+}
+
+func (l *rotatingLog) segment(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// Replace a file with a gzipped copy named path.gz. The copy is written
+// under a temporary name first, so a crash leaves either file intact.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz" + tmpSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // No-op once the rename succeeds
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -2,210 +2,1904 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/expr-lang/expr/vm"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
 )
 
 const (
 	batchSize   = 100000 // 100K records per batch
-	workers     = 1     // Start with 1 worker, increase later
 	lastIDFile  = "last_id.txt"
 	exportDir   = "exports"
 	logFilePath = "export.log"
+
+	// Smallest maxStalenessSeconds allowed by the server selection spec
+	minMaxStaleness = 90 * time.Second
 )
 
+// Command-line options
+type config struct {
+	noCheckpoint      bool           // Ignore any existing checkpoint and never write one
+	checkpointDir     string         // Directory holding the checkpoint files, empty for the working directory
+	filePrefix        string         // Prepended to output file names, set per source by --sources
+	sources           []exportSource // Collections to export from a --sources file instead of the prompts
+	sourceConcurrency int            // Sources exported at the same time
+	writeManifest     bool           // Keep manifest.jsonl in the export directory and resume from it
+	checksumAlgorithm string         // Checksum each batch file in the manifest with this, empty for none
+	withMetadata      bool           // Write metadata.json with the collection's options and indexes
+	historyMax        int            // Entries kept in the checkpoint history log before rotating, 0 to disable
+	naturalOrder      bool           // Scan in $natural order (fastest, but not resumable)
+	hashedScan        bool           // Scan a collection sharded on a hashed key chunk by chunk instead of by _id
+	timeField         string         // Set for time-series collections, whose single scan is sorted by this field
+	workers           int            // Parallel workers, each exporting its own _id partition (0 = auto)
+	resumeVerify      bool           // Look up and log the checkpoint document before resuming
+	resumeWindow      time.Duration  // Rewind the resume _id by this much ObjectID time, re-exporting the overlap
+	warmup            time.Duration  // Time box for paging in the _id index before exporting, 0 to skip
+	descending        bool           // Export newest _id first, paginating backward
+	idType            string         // _id type to paginate and resume on: objectid or uuid
+	textSearch        string         // Only export documents matching this $text search, empty for all
+	textLanguage      string         // Language of the text search, empty for the index's default
+	textScoreField    string         // Add each document's text search score under this field, empty for none
+	textSortScore     bool           // Export the best text search matches first, in a single pass
+	arraySlices       []arraySlice   // --array-slice projections trimming arrays server-side
+	skipErrors        bool           // Log and skip documents that fail to decode or transform instead of aborting
+
+	collStats         bool  // Run a collStats preflight that sizes the export
+	indexCheck        bool  // Make sure every field the export sorts on leads an index
+	createTempIndex   bool  // Create a missing sort index for the run and drop it afterwards
+	maxExportBytes    int64 // Refuse to export more than this (estimated) unless yes is set
+	yes               bool  // Confirm exports that exceed maxExportBytes, and skip the confirm prompt
+	confirm           bool  // Summarize the export and ask before starting it
+	allowPrimary      bool  // Export large collections from a primary without refusing
+	primaryGuardBytes int64 // Collections at least this large aren't read from a primary without allowPrimary, 0 to never check
+	bestEffort        bool  // Keep exporting the remaining collections after one fails
+	force             bool  // Resume even if the output format changed since the checkpoint
+
+	singleFile        bool          // Write all batches into one JSON file instead of one file per batch
+	compressEachBatch bool          // Gzip every batch file independently
+	compressFinal     bool          // Gzip the single output file as one stream
+	mergeOnComplete   bool          // Flatten the per-worker directories into exportDir at the end
+	emitSidecars      bool          // Write batch_N.meta.json next to every batch file
+	emitSequence      bool          // Number every document in a _seq field in the order it's written
+	preserveOrder     bool          // Merge workers' output into the single file in _id order
+	flushInterval     time.Duration // With --single-file, fsync the output at least this often
+	flushDocs         int           // With --single-file, fsync the output every this many documents
+	fileMaxBytes      int64         // Roll over to a new part file once a file reaches this size, 0 for no limit
+	maxOpenFiles      int           // Batch, sidecar and spool files open at once, 0 for no limit
+
+	format       string      // Output format: json, csv, bson or ejson
+	flattenDepth int         // Nesting levels flattened into CSV columns, 0 for no limit
+	csvNull      string      // CSV cell for a field that is explicitly null
+	csvMissing   string      // CSV cell for a field the document doesn't have
+	unwind       []string    // CSV array fields expanded into a row per element
+	avroSchema   *avroSchema // Schema of every Avro file, nil to infer one per batch
+	avroCodec    string      // Avro block codec: null, deflate or snappy
+	jsonRoot     string      // Wrap JSON output as {"<jsonRoot>": [...]} instead of a bare array
+	jsonRootMeta bool        // Add count and first/last _id next to the wrapped array
+
+	preserveFieldOrder bool   // Decode into bson.D so JSON keeps each document's field order
+	idKey              string // Write _id under this key instead, empty to keep _id
+	normalizeEJSON     bool   // Write every ejson date as an ISO string, not only those from 1970 on
+	outputStdout       bool   // Write documents to stdout, one per line, instead of files
+	sampleValidate     int    // Re-fetch this many random written documents after the export and compare them
+	maxDepth           int    // JSON-encode sub-documents and arrays nested deeper than this as strings, 0 for no limit
+
+	cpuProfile string // Write a pprof CPU profile of the run here, empty for none
+	memProfile string // Write a pprof heap profile here at the end, empty for none
+
+	progressFile     string        // Keep a JSON progress snapshot here, empty for none
+	progressInterval time.Duration // How often the progress file is rewritten
+	heartbeatEvery   time.Duration // Log that the run is alive this often, 0 for never
+
+	logMaxBytes int64 // Rotate export.log before it grows past this size, 0 to never rotate
+	logKeep     int   // Rotated export.log segments kept
+	logCompress bool  // Gzip rotated export.log segments
+	logStdout   bool  // Copy the log to the console (stdout, or stderr with --output-stdout) as well as export.log
+	requireLog  bool  // Exit if export.log can't be opened instead of only logging to stderr
+
+	readPreference string             // Read preference mode, empty to use the URI/driver default
+	maxStaleness   time.Duration      // Max secondary lag tolerated, 0 for no limit
+	readTags       string             // --read-tags as given, e.g. region:us-east,disk:ssd;region:us-east
+	readTagSets    []tag.Set          // Parsed --read-tags, tried in order
+	readPref       *readpref.ReadPref // Effective read preference from the URI and flags, nil for the default (primary)
+	throttleLag    bool               // Pause between batches while replication lag is over maxReplLag
+	maxReplLag     time.Duration      // Replication lag that pauses the export with throttleLag
+	apiVersion     string             // Stable API version to pin, empty for none
+	apiStrict      bool               // Reject commands outside the pinned Stable API
+	maxPoolSize    uint64             // Most connections per server in the driver pool, 0 for the driver default
+	minPoolSize    uint64             // Connections per server the driver keeps open while idle
+	replicaSet     string             // Replica set name overriding the URI and SRV TXT record, empty to keep it
+	tls            string             // "true" or "false" to override TLS from the URI, empty to keep it
+	srvServiceName string             // SRV service name to look up instead of "mongodb"
+	srvMaxHosts    int                // Most hosts taken from the SRV record, 0 for all
+
+	credentialsFile string       // JSON file with the URI and/or username and password
+	credentials     *credentials // Loaded from credentialsFile, nil if unset
+
+	webhookURL  string        // POST the final summary here on completion or failure
+	maxDuration time.Duration // Abort the run after this long, 0 for no limit
+	maxBatches  int           // Stop cleanly after writing this many batches, 0 for no limit
+	opTimeout   time.Duration // Deadline for each batch's queries, 0 for none
+	snapshot    bool          // Read every batch from one point-in-time snapshot
+
+	noCursorTimeout bool // Keep idle cursors open on the server instead of letting them time out
+
+	deltaField string // Only export documents whose value of this field is past the stored watermark
+
+	distinctField  string // Export the unique values of this field instead of documents
+	distinctOutput string // Distinct values as a JSON array or one per line
+
+	minID primitive.ObjectID // Only export _id > minID (zero for no bound)
+	maxID primitive.ObjectID // Only export _id <= maxID (zero for no bound)
+
+	memLimit int64 // Shrink batches while the heap is above this many bytes, 0 to disable
+
+	sampleEvery int // Log a preview of every Nth exported document, 0 to disable
+
+	tail       time.Duration // After exporting, write changes from the change stream for this long
+	sinceOplog bool          // After a first full export, export only the changes since the previous run's cluster time
+
+	dedupeMode string // Drop documents whose _id was already written: exact, bloom or empty
+
+	// Runtime state built from the options above at startup
+	filter     bson.M             // Base query filter
+	sizer      *batchSizer        // Adaptive batch size, nil for a fixed batchSize
+	mapProgram *vm.Program        // Compiled --map-expr, nil if unset
+	allowlist  *fieldAllowlist    // --field-allowlist, nil if unset
+	plugin     *transformPlugin   // --transform-plugin command, nil if unset
+	encryptor  *fieldEncryptor    // Encrypts --encrypt-fields, nil if unset
+	emitted    *atomic.Int64      // Documents exported so far by all workers, for --log-sample-every
+	dedupe     idDeduper          // _ids written so far, nil unless --dedupe-on-id is set
+	history    *checkpointHistory // Log of checkpoint advances, nil unless --checkpoint-history is set
+	manifest   *exportManifest    // Record of written batches, nil unless --manifest is set
+	session    mongo.Session      // Snapshot session batch queries run in, nil unless --snapshot is set
+	lag        *lagThrottle       // Replication lag check, nil unless --throttle-on-replication-lag is set
+	partitions *partitionTracker  // Per-partition checkpoints of a parallel export, nil otherwise
+	hashed     *hashedTracker     // Per-worker chunk checkpoints of a --hashed-shard-scan, nil otherwise
+	bench      *benchmark         // Time per export stage, nil unless --benchmark is set
+	profiler   *profiler          // --cpuprofile and --memprofile, nil unless one is set
+	progress   *progressReporter  // --progress-file, nil unless it's set
+	heartbeat  *heartbeat         // --heartbeat-interval, nil unless it's set
+	openFiles  *fileLimiter       // Shared by every writer, nil unless --max-open-files is set
+	batches    *batchLimit        // Shared by every worker, nil unless --max-batches is set
+	errorLog   *errorLog          // Where --skip-errors records skipped documents, nil otherwise
+}
+
 func main() {
+	// Subcommands other than the default export
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string) error{
+			"count":            runCount,
+			"dump-sample":      runDumpSample,
+			"list-databases":   runListDatabases,
+			"list-collections": runListCollections,
+			"rechunk":          runRechunk,
+			"verify":           runVerify,
+		}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := setupLogging(&config{}); err != nil {
+				fmt.Fprintf(console, "⚠️ Warning: %v, logging to stderr only\n", err)
+			}
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalf("❌ %s failed: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	// Parse command-line flags
+	cfg := parseFlags()
+	if cfg.outputStdout {
+		console = os.Stderr
+	}
+
 	// Setup logging to both console and file
-	setupLogging()
-	log.Println("📜 Logging started...")
+	if err := setupLogging(cfg); err != nil {
+		if cfg.requireLog {
+			log.Fatalf("❌ %v, and --require-log-file is set", err)
+		}
+		fmt.Fprintf(console, "⚠️ Warning: %v, logging to stderr only\n", err)
+	}
+
+	profiler, err := startProfiling(cfg.cpuProfile, cfg.memProfile)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	cfg.profiler = profiler
+	cfg.progress = startProgress(cfg.progressFile, cfg.progressInterval)
+	cfg.heartbeat = startHeartbeat(cfg.heartbeatEvery)
+
+	summary := newExportSummary()
+	watchAbort(cfg, summary)
+	err = runExport(cfg, summary)
+	if rootCtx.Err() != nil || errors.Is(err, context.Canceled) {
+		err = abortError(err)
+	}
+	os.Exit(finishRun(cfg, summary, err))
+}
+
+// Report how the run ended to the summary, progress file and webhook, and
+// return the process exit code. Only the first call for a summary reports, so
+// a run that the abort watcher ends as well is still reported once, as it was
+// first seen.
+func finishRun(cfg *config, summary *exportSummary, err error) int {
+	summary.reported.Do(func() {
+		cfg.heartbeat.finish()
+		summary.finish(err)
+		cfg.progress.finish(summary.Status)
+		cfg.bench.report(summary)
+		cfg.profiler.stop()
+
+		// Report the outcome to the webhook, if any, before exiting
+		if cfg.webhookURL != "" {
+			notifyWebhook(cfg.webhookURL, summary)
+		}
+		switch {
+		case errors.Is(err, errAborted):
+			log.Printf("🛑 Export aborted: %v\n", err)
+			summary.exitCode = exitAborted
+		case errors.Is(err, errPartialExport):
+			log.Printf("⚠️ Export finished with failures: %v\n", err)
+			summary.exitCode = exitPartial
+		case err != nil:
+			log.Printf("❌ Export failed: %v\n", err)
+			summary.exitCode = exitFatal
+		default:
+			log.Println("✅ Export completed successfully!")
+			log.Printf("🚀 Total time taken: %s\n", summary.Duration)
+			if summary.Warmup != "" {
+				log.Printf("🔥 Of which warmup: %s\n", summary.Warmup)
+			}
+			summary.exitCode = exitOK
+		}
+	})
+	return summary.exitCode
+}
+
+// Run the export end to end, recording progress in the summary
+func runExport(cfg *config, summary *exportSummary) error {
+	if len(cfg.sources) > 0 {
+		return exportSources(cfg, summary)
+	}
 
 	// Get user inputs
-	mongoURI, dbName, collectionName := getUserInputs()
+	mongoURI, dbName, collectionInput := getUserInputs(cfg)
+	summary.Database = dbName
 
 	// Connect to MongoDB
-	log.Println("✅ Connecting to MongoDB...")
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(mongoURI))
+	client, err := connectMongo(cfg, mongoURI)
 	if err != nil {
-		log.Fatalf("❌ Failed to connect to MongoDB: %v", err)
+		return err
 	}
 	defer client.Disconnect(context.TODO())
-	log.Println("✅ Successfully connected to MongoDB!")
+	endSnapshot, err := startSnapshot(cfg, client)
+	if err != nil {
+		return err
+	}
+	defer endSnapshot()
 
 	db := client.Database(dbName)
+	names, err := collectionNames(db, collectionInput)
+	if err != nil {
+		return err
+	}
+	if len(names) == 1 {
+		summary.Collection = names[0]
+		return exportCollection(cfg, client, db, names[0], exportDir, summary)
+	}
+	return exportCollections(cfg, client, db, names, summary)
+}
+
+// Export one collection into dir, recording progress in the summary
+func exportCollection(cfg *config, client *mongo.Client, db *mongo.Database, collectionName, dir string, summary *exportSummary) error {
+	dbName := db.Name()
 	collection := db.Collection(collectionName)
+	if cfg.batches.stopped() {
+		log.Printf("⏹️ Skipping %s, --max-batches was reached\n", collectionName)
+		return nil
+	}
+	cfg.batches = cfg.batches.collection()
+
+	// Bound the scan to the requested _id window
+	if !cfg.minID.IsZero() || !cfg.maxID.IsZero() {
+		idRange := bson.M{}
+		if !cfg.minID.IsZero() {
+			idRange["$gt"] = cfg.minID
+		}
+		if !cfg.maxID.IsZero() {
+			idRange["$lte"] = cfg.maxID
+		}
+		cfg.filter = andFilters(cfg.filter, bson.M{"_id": idRange})
+		log.Printf("🔍 Limiting export to _id range (%s, %s]\n", idString(objectDocID(cfg.minID), "start"), idString(objectDocID(cfg.maxID), "end"))
+	}
+
+	// Only export the documents matching the text search
+	if cfg.textSearch != "" {
+		if err := checkTextIndex(collection); err != nil {
+			return err
+		}
+		cfg.filter = andFilters(cfg.filter, textSearchFilter(cfg))
+		log.Printf("🔎 Limiting export to documents matching the text search %q\n", cfg.textSearch)
+	}
+
+	// Distinct mode exports a field's unique values instead of documents
+	if cfg.distinctField != "" {
+		return runDistinct(cfg, collection, dir, summary)
+	}
+
+	// Size the export and detect capped collections before starting
+	wantPreflight := cfg.collStats || cfg.maxExportBytes > 0
+	stats, err := fetchCollStats(db, collectionName)
+	if err != nil {
+		if wantPreflight {
+			return fmt.Errorf("failed to run collStats: %w", err)
+		}
+		log.Printf("⚠️ Warning: collStats failed, can't detect capped collections: %v\n", err)
+	} else {
+		if wantPreflight {
+			if err := runPreflight(cfg, stats); err != nil {
+				return err
+			}
+		}
+		checkCapped(cfg, stats)
+		if err := guardPrimary(cfg, collection, stats); err != nil {
+			return err
+		}
+	}
+	checkTimeSeries(cfg, db, collectionName)
+	if cfg.indexCheck {
+		temp, err := checkSortIndexes(cfg, collection)
+		if err != nil {
+			return err
+		}
+		defer dropTempIndexes(collection, temp)
+	}
+	if cfg.confirm {
+		if err := confirmExport(cfg, dbName, collectionName, dir, stats); err != nil {
+			return err
+		}
+	}
 
 	// Create export directory
-	if err := os.MkdirAll(exportDir, os.ModePerm); err != nil {
-		log.Fatalf("❌ Failed to create directory: %v", err)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if cfg.withMetadata {
+		if err := writeMetadata(cfg, db, collectionName, dir); err != nil {
+			return err
+		}
+	}
+
+	// Open the output writer
+	writer, err := newBatchWriter(cfg, dir)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	if cfg.bench != nil {
+		writer = &benchWriter{batchWriter: writer, bench: cfg.bench}
+	}
+
+	cfg.sizer = newBatchSizer(cfg.memLimit)
+	if cfg.skipErrors {
+		cfg.errorLog = newErrorLog(filepath.Join(dir, cfg.filePrefix+errorsFile))
+		defer cfg.errorLog.close()
+	}
+
+	// Work out this run's slice of a delta export
+	var state *checkpointState
+	deltaKey := dbName + "." + collectionName
+	if cfg.deltaField != "" {
+		if state, err = loadCheckpointState(cfg); err != nil {
+			return fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+		deltaFilter, err := prepareDelta(cfg, collection, state, deltaKey)
+		if err != nil {
+			return err
+		}
+		if deltaFilter == nil {
+			return writer.Close()
+		}
+		cfg.filter = andFilters(cfg.filter, deltaFilter)
+	}
+
+	// Follow on from the previous run's position in the oplog, if it has one
+	var since *clusterTime
+	if cfg.sinceOplog {
+		if state == nil {
+			if state, err = loadCheckpointState(cfg); err != nil {
+				return fmt.Errorf("failed to load checkpoint: %w", err)
+			}
+		}
+		if since, err = prepareOplog(cfg, client, state, deltaKey); err != nil {
+			writer.Close()
+			return err
+		}
+	}
+
+	// Size the worker pool. Parallel exports checkpoint each partition in
+	// checkpoint.json rather than a single position in last_id.txt.
+	cfg.workers = resolveWorkers(cfg, client)
+
+	// Record every batch in the manifest, and replay it to find where a
+	// checkpointed export got to
+	resume := manifestState{nextBatch: 1}
+	if cfg.writeManifest {
+		manifest, replayed, err := openManifest(dir, cfg.filePrefix, cfg.noCheckpoint, cfg.checksumAlgorithm, cfg.idType)
+		if err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to open manifest: %w", err)
+		}
+		defer manifest.close()
+		cfg.manifest, resume = manifest, replayed
+		writer = &manifestWriter{batchWriter: writer, manifest: manifest}
+	} else if !cfg.noCheckpoint && !cfg.naturalOrder && !cfg.singleFile && !cfg.outputStdout && (cfg.workers <= 1 || since != nil) {
+		// Without a manifest the files on disk are all that says how far
+		// earlier runs numbered, so a resumed, delta or oplog run continues
+		// after them instead of overwriting batch_1. Oplog replays write as
+		// worker 0 even after a parallel first run.
+		if resume.nextBatch, err = nextBatchNum(dir, cfg.filePrefix, 0); err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to list existing batch files: %w", err)
+		}
+		if resume.nextBatch > 1 {
+			log.Printf("📁 %s already holds batches up to %d, numbering this run's batches from %d\n", dir, resume.nextBatch-1, resume.nextBatch)
+		}
+	}
+
+	// Keep a random sample of written documents to check against the source
+	var sampler *documentSampler
+	if cfg.sampleValidate > 0 {
+		sampler = newDocumentSampler(cfg)
+		writer = &sampleWriter{batchWriter: writer, sampler: sampler}
+	}
+
+	// Later --since-oplog runs export the changes instead of scanning by _id
+	if since != nil {
+		if err := guardOutputFormat(cfg, state, true); err != nil {
+			writer.Close()
+			return err
+		}
+		cfg.progress.startCollection(dbName, collectionName, collection, false)
+		cfg.heartbeat.startCollection(dbName, collectionName)
+		if err := replayOplog(cfg, client, collection, writer, summary, state, deltaKey, dir, resume.nextBatch); err != nil {
+			writer.Close()
+			return fmt.Errorf("oplog replay failed: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("failed to finish writing output: %w", err)
+		}
+		if sampler != nil {
+			validateSample(cfg, collection, sampler, summary)
+		}
+		return nil
+	}
+
+	// A hashed-sharded collection is scanned chunk by chunk instead of by _id
+	var hashedKey bson.D
+	if cfg.hashedScan {
+		if hashedKey, err = detectHashedShardKey(collection); err != nil {
+			return err
+		}
 	}
 
 	// Load last exported ID
-	lastID := loadLastID()
-	log.Printf("🔄 Resuming export from last ID: %v\n", lastID)
+	var lastID docID
+	if cfg.naturalOrder {
+		if cfg.textSortScore {
+			log.Println("🔎 Sorting by text search score, exporting in a single pass that will not be resumable...")
+		} else if cfg.timeField == "" {
+			log.Println("⚡ Natural-order scan enabled, export will not be resumable...")
+		}
+	} else if cfg.noCheckpoint {
+		log.Println("🚫 Checkpointing disabled, exporting from the beginning...")
+	} else if hashedKey != nil {
+		if state == nil {
+			if state, err = loadCheckpointState(cfg); err != nil {
+				return fmt.Errorf("failed to load checkpoint: %w", err)
+			}
+		}
+		if err := guardOutputFormat(cfg, state, state.Hashed[deltaKey] != nil); err != nil {
+			return err
+		}
+	} else if cfg.workers > 1 {
+		if cfg.historyMax > 0 {
+			if cfg.history, err = openCheckpointHistory(checkpointPath(cfg, historyFile), cfg.historyMax); err != nil {
+				return fmt.Errorf("failed to open checkpoint history: %w", err)
+			}
+			defer cfg.history.close()
+		}
+		if state == nil {
+			if state, err = loadCheckpointState(cfg); err != nil {
+				return fmt.Errorf("failed to load checkpoint: %w", err)
+			}
+		}
+		if err := guardOutputFormat(cfg, state, state.Partitions[deltaKey] != nil); err != nil {
+			return err
+		}
+	} else {
+		lastID = resumeLastID(cfg, resume)
+		if cfg.historyMax > 0 {
+			if cfg.history, err = openCheckpointHistory(checkpointPath(cfg, historyFile), cfg.historyMax); err != nil {
+				return fmt.Errorf("failed to open checkpoint history: %w", err)
+			}
+			defer cfg.history.close()
+			if !lastID.IsZero() {
+				recordHistory(cfg, "resume", 0, 0, lastID)
+			}
+		}
+		if cfg.resumeVerify && !lastID.IsZero() {
+			if err := verifyResumePoint(collection, lastID); err != nil {
+				return err
+			}
+		}
+		if state == nil {
+			if state, err = loadCheckpointState(cfg); err != nil {
+				return fmt.Errorf("failed to load checkpoint: %w", err)
+			}
+		}
+		if err := guardOutputFormat(cfg, state, !lastID.IsZero()); err != nil {
+			return err
+		}
+		lastID = rewindResumeID(cfg, 0, lastID)
+	}
+	if cfg.workers <= 1 && hashedKey == nil {
+		log.Printf("🔄 Resuming export from last ID: %v\n", lastID)
+	}
+	if cfg.descending {
+		log.Println("🔽 Exporting in descending _id order, newest first...")
+	}
+
+	// Remember every _id written so documents seen twice are only written once
+	switch cfg.dedupeMode {
+	case "exact":
+		cfg.dedupe = newExactDeduper()
+		log.Println("🧹 Dropping duplicate _ids, keeping every exported _id in memory")
+	case "bloom":
+		expected, err := collection.EstimatedDocumentCount(context.TODO())
+		if err != nil {
+			log.Printf("⚠️ Warning: Can't estimate the document count to size the bloom filter: %v\n", err)
+		}
+		// Headroom for documents inserted while the export runs
+		d := newBloomDeduper(expected + expected/4)
+		cfg.dedupe = d
+		log.Printf("🧹 Dropping duplicate _ids with a bloom filter sized for %d _ids (%s)\n", d.maxIDs, formatBytes(d.size()))
+	}
+
+	// Optionally page in the _id index before the timed export starts
+	if cfg.warmup > 0 {
+		if cfg.naturalOrder {
+			log.Println("⚠️ Warning: --warmup primes the _id index, which a natural-order scan doesn't use, skipping it.")
+		} else if hashedKey != nil {
+			log.Println("⚠️ Warning: --warmup primes the _id index, which a hashed shard key scan doesn't use, skipping it.")
+		} else {
+			summary.addWarmup(warmupIDIndex(collection, cfg.warmup))
+		}
+	}
+
+	// Start watching for changes before the export, so the tail after it
+	// misses nothing that was written meanwhile
+	var stream *mongo.ChangeStream
+	if cfg.tail > 0 {
+		if stream, err = openTail(collection, state, deltaKey); err != nil {
+			return err
+		}
+		defer stream.Close(context.TODO())
+	}
+
+	// Watch replication lag, so the export backs off before it hurts the cluster
+	if cfg.throttleLag {
+		if cfg.lag, err = newLagThrottle(client, cfg.maxReplLag); err != nil {
+			return err
+		}
+	}
 
-	startTime := time.Now()
+	// Split the _id range between the workers
+	items := []workItem{{lastID: lastID, firstBatch: resume.nextBatch}}
+	if hashedKey != nil {
+		if items, err = planHashedScan(cfg, collection, hashedKey, state, deltaKey); err != nil {
+			return err
+		}
+	} else if cfg.workers > 1 {
+		if items, err = planPartitions(cfg, collection, state, deltaKey); err != nil {
+			return err
+		}
+	}
+
+	// The ETA needs a total, which only the whole collection has
+	whole := len(cfg.filter) == 0
+	for _, item := range items {
+		whole = whole && item.lastID.IsZero() && item.chunksDone == 0
+	}
+	cfg.progress.startCollection(dbName, collectionName, collection, whole)
+	cfg.heartbeat.startCollection(dbName, collectionName)
 
 	// Worker group
 	var wg sync.WaitGroup
-	workChan := make(chan primitive.ObjectID, workers) // Buffered channel
+	workChan := make(chan workItem, len(items)) // Buffered channel
 
 	// Start workers
-	for i := 0; i < workers; i++ {
+	for i := 0; i < len(items); i++ {
 		wg.Add(1)
-		go exportWorker(i, collection, exportDir, workChan, &wg)
+		go exportWorker(cfg, collection, writer, summary, workChan, &wg)
 	}
 
-	// Feed initial work
-	workChan <- lastID
-
-	// Close work channel once all workers are done
-	go func() {
-		wg.Wait()
-		close(workChan)
-	}()
+	// Feed the work, then close the channel so workers exit once it's drained
+	for _, item := range items {
+		workChan <- item
+	}
+	close(workChan)
 
 	// Wait for completion
 	wg.Wait()
+	if err := summary.err(); err != nil {
+		writer.Close()
+		return err
+	}
+	if stream != nil {
+		if err := runTail(len(items), cfg, stream, writer, summary, state, deltaKey); err != nil {
+			writer.Close()
+			return fmt.Errorf("tail failed: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish writing output: %w", err)
+	}
+	if summary.Documents == 0 {
+		logNothingExported(cfg, collectionName, whole)
+	}
+	if sampler != nil {
+		validateSample(cfg, collection, sampler, summary)
+	}
 
-	log.Println("✅ Export completed successfully!")
-	elapsedTime := time.Since(startTime)
-	log.Printf("🚀 Total time taken: %s\n", elapsedTime)
+	// The watermark only advances once the whole delta has been written
+	if cfg.batches.stopped() {
+		log.Printf("⏹️ Stopped after --max-batches=%d, run again to continue from the checkpoint\n", cfg.maxBatches)
+		return nil
+	}
+	if cfg.deltaField != "" {
+		if err := completeDelta(cfg, state, deltaKey); err != nil {
+			return fmt.Errorf("failed to advance delta watermark: %w", err)
+		}
+	}
+	if cfg.sinceOplog {
+		if err := completeOplogBaseline(cfg, state, deltaKey); err != nil {
+			return fmt.Errorf("failed to save oplog position: %w", err)
+		}
+	}
+	return nil
+}
+
+// Say why a run wrote no documents and what it left on disk, so an empty
+// output isn't mistaken for a failed one
+func logNothingExported(cfg *config, collectionName string, whole bool) {
+	reason := "no documents matched since the checkpoint or in the requested range"
+	if whole {
+		reason = "the collection is empty"
+	}
+	switch {
+	case cfg.outputStdout:
+		log.Printf("📭 Nothing exported from %s: %s. Nothing was written to stdout.\n", collectionName, reason)
+	case cfg.singleFile:
+		log.Printf("📭 Nothing exported from %s: %s. %sexport.json holds an empty JSON array.\n", collectionName, reason, cfg.filePrefix)
+	default:
+		log.Printf("📭 Nothing exported from %s: %s. No batch files were written.\n", collectionName, reason)
+	}
 }
 
-// Worker function to export records in parallel
-func exportWorker(workerID int, collection *mongo.Collection, exportDir string, workChan <-chan primitive.ObjectID, wg *sync.WaitGroup) {
+// Connect to MongoDB with the connection flags applied
+func connectMongo(cfg *config, mongoURI string) (*mongo.Client, error) {
+	log.Println("✅ Connecting to MongoDB...")
+	clientOpts, err := buildClientOptions(cfg, mongoURI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection settings: %w", err)
+	}
+	client, err := mongo.Connect(context.TODO(), clientOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	log.Println("✅ Successfully connected to MongoDB!")
+	return client, nil
+}
+
+// Build the client options from the URI and connection flags
+func buildClientOptions(cfg *config, mongoURI string) (*options.ClientOptions, error) {
+	mongoURI, err := applySRVFlags(cfg, mongoURI)
+	if err != nil {
+		return nil, err
+	}
+	// Parsing resolves the SRV and TXT records, so lookup failures show up here
+	clientOpts := options.Client().ApplyURI(mongoURI)
+	if err := clientOpts.Validate(); err != nil {
+		return nil, err
+	}
+	applyTopologyFlags(cfg, clientOpts)
+
+	if cfg.readPreference != "" {
+		mode, err := readpref.ModeFromString(cfg.readPreference)
+		if err != nil {
+			return nil, err
+		}
+		var rpOpts []readpref.Option
+		if cfg.maxStaleness > 0 {
+			rpOpts = append(rpOpts, readpref.WithMaxStaleness(cfg.maxStaleness))
+		}
+		if len(cfg.readTagSets) > 0 {
+			rpOpts = append(rpOpts, readpref.WithTagSets(cfg.readTagSets...))
+		}
+		rp, err := readpref.New(mode, rpOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("read preference %q: %w", cfg.readPreference, err)
+		}
+		clientOpts.SetReadPreference(rp)
+		log.Printf("📖 Using read preference %s\n", rp)
+	}
+	cfg.readPref = clientOpts.ReadPreference
+
+	if cfg.apiVersion != "" {
+		serverAPI := options.ServerAPI(options.ServerAPIVersion(cfg.apiVersion))
+		if cfg.apiStrict {
+			serverAPI.SetStrict(true)
+		}
+		clientOpts.SetServerAPIOptions(serverAPI)
+		log.Printf("📌 Pinning Stable API version %s (strict: %t)\n", cfg.apiVersion, cfg.apiStrict)
+	}
+
+	if cfg.credentials != nil && cfg.credentials.Username != "" {
+		clientOpts.SetAuth(options.Credential{
+			Username:   cfg.credentials.Username,
+			Password:   cfg.credentials.Password,
+			AuthSource: cfg.credentials.AuthSource,
+		})
+	}
+
+	if cfg.maxPoolSize > 0 {
+		clientOpts.SetMaxPoolSize(cfg.maxPoolSize)
+	}
+	if cfg.minPoolSize > 0 {
+		clientOpts.SetMinPoolSize(cfg.minPoolSize)
+	}
+	if cfg.maxPoolSize > 0 || cfg.minPoolSize > 0 {
+		log.Printf("🏊 Connection pool size: min %d, max %s\n", cfg.minPoolSize, poolSizeString(cfg.maxPoolSize))
+	}
+	logSeedList(clientOpts, mongoURI)
+	return clientOpts, nil
+}
+
+// Worker function to export records in parallel. Each work item is exported
+// under the ID of its partition, so a resumed partition keeps writing into the
+// same worker directory whichever goroutine picks it up.
+func exportWorker(cfg *config, collection *mongo.Collection, writer batchWriter, summary *exportSummary, workChan <-chan workItem, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	for lastID := range workChan {
-		batchNum := 1
-		for {
-			var filter bson.M
-			if !lastID.IsZero() {
-				filter = bson.M{"_id": bson.M{"$gt": lastID}}
-			} else {
-				filter = bson.M{}
-			}
+	for item := range workChan {
+		workerID := item.partition
+		var err error
+		if cfg.naturalOrder {
+			// $natural scans can't be paginated by _id, so stream them in one pass
+			err = exportNaturalOrder(workerID, cfg, collection, writer, summary)
+		} else if item.chunks != nil {
+			err = exportHashedChunks(workerID, cfg, collection, writer, summary, item)
+		} else {
+			err = exportFromID(workerID, cfg, collection, writer, summary, item)
+		}
+		if errors.Is(err, errBatchLimit) {
+			cfg.batches.stop()
+			log.Printf("⏹️ Worker %d: Stopping, --max-batches=%d batches have been written\n", workerID, cfg.maxBatches)
+			continue
+		}
+		if err != nil {
+			log.Printf("❌ Worker %d: %v\n", workerID, err)
+			summary.addError(fmt.Errorf("worker %d: %w", workerID, err))
+			return
+		}
+		log.Printf("✅ Worker %d: No more records to export.\n", workerID)
+	}
+}
 
-			cursor, err := collection.Find(
-				context.TODO(),
-				filter,
-				options.Find().SetLimit(batchSize).SetSort(bson.D{{"_id", 1}}),
-			)
+// Export every document of the work item after its lastID in _id order,
+// checkpointing each batch
+func exportFromID(workerID int, cfg *config, collection *mongo.Collection, writer batchWriter, summary *exportSummary, item workItem) error {
+	filter := andFilters(cfg.filter, item.bounds)
+	lastID := item.lastID
+	sortDir := 1
+	if cfg.descending {
+		sortDir = -1
+	}
+	batchNum := 1
+	if item.firstBatch > 1 {
+		batchNum = item.firstBatch
+	}
+	retries := 0
+	claimed := false // A retry reuses the batch it already claimed
+	for {
+		if !claimed && !cfg.batches.take() {
+			more, err := moreAfter(cfg, collection, filter, lastID)
 			if err != nil {
-				log.Printf("❌ Worker %d: Failed to fetch data: %v\n", workerID, err)
-				return
+				return describeOpError(cfg, fmt.Sprintf("check for documents after _id %s", idString(lastID, "start")), err)
 			}
+			if !more {
+				return nil
+			}
+			return errBatchLimit
+		}
+		claimed = true
+		cfg.lag.wait()
+		size := cfg.sizer.next()
+		ctx, cancel := opContext(cfg)
+		start := cfg.bench.start()
+		cursor, err := collection.Find(
+			ctx,
+			paginationFilter(filter, lastID, cfg.descending),
+			withProjection(cfg, options.Find().SetLimit(int64(size)).SetSort(bson.D{{Key: "_id", Value: sortDir}}).SetNoCursorTimeout(cfg.noCursorTimeout)),
+		)
+		cfg.bench.stop(benchRead, start)
+		if err != nil {
+			cancel()
+			if isOpTimeout(err) && retries < readRetries {
+				retries++
+				log.Printf("⏱️ Worker %d: Fetching batch %d timed out after %s, retrying (%d/%d)\n", workerID, batchNum, cfg.opTimeout, retries, readRetries)
+				continue
+			}
+			return describeOpError(cfg, fmt.Sprintf("fetch batch %d after _id %s", batchNum, idString(lastID, "start")), err)
+		}
 
-			var results []bson.M
-			if err := cursor.All(context.TODO(), &results); err != nil {
-				log.Printf("❌ Worker %d: Failed to decode batch: %v\n", workerID, err)
-				return
+		b, err := readBatch(ctx, workerID, batchNum, size, cfg, cursor)
+		cursor.Close(context.TODO())
+		cancel()
+		if reason := interruptedRead(cfg, err); reason != "" && b != nil {
+			// Documents already read have been through --dedupe-on-id, so
+			// write them as a short batch and re-issue the query after them
+			if b.scanned > 0 {
+				log.Printf("⏱️ Worker %d: Reading batch %d %s, writing the %d documents read so far\n", workerID, batchNum, reason, b.scanned)
+				err = nil
+			} else if retries < readRetries {
+				retries++
+				log.Printf("⏱️ Worker %d: Reading batch %d %s, retrying (%d/%d)\n", workerID, batchNum, reason, retries, readRetries)
+				continue
 			}
+		}
+		if err != nil {
+			return describeOpError(cfg, fmt.Sprintf("read batch %d after _id %s", batchNum, idString(lastID, "start")), err)
+		}
+		retries = 0
 
-			// Stop if no more data
-			if len(results) == 0 {
-				log.Printf("✅ Worker %d: No more records to export.\n", workerID)
-				break
+		// Stop if no more data
+		if b.scanned == 0 {
+			cfg.batches.giveBack()
+			return nil
+		}
+		if b.lastID.IsZero() {
+			kind := "ObjectID"
+			if cfg.idType == idTypeUUID {
+				kind = "UUID (binary subtype 4)"
 			}
+			return fmt.Errorf("last document in batch %d has no %s _id, can't paginate further", batchNum, kind)
+		}
 
-			// Write batch to JSON file
-			filePath := filepath.Join(exportDir, fmt.Sprintf("batch_%d_worker_%d.json", batchNum, workerID))
-			file, err := os.Create(filePath)
+		// Write batch to file (a batch may be empty if every document was skipped)
+		filePath := "(nothing written)"
+		logSamples(workerID, cfg, b)
+		if b.count() > 0 {
+			filePath, err = writer.WriteBatch(workerID, batchNum, b)
 			if err != nil {
-				log.Printf("❌ Worker %d: Failed to create file: %v\n", workerID, err)
-				return
+				return fmt.Errorf("batch %d after _id %s: %w", batchNum, idString(lastID, "start"), err)
 			}
-			encoder := json.NewEncoder(file)
-			encoder.SetIndent("", "  ")
-			if err := encoder.Encode(results); err != nil {
-				log.Printf("❌ Worker %d: Failed to write JSON: %v\n", workerID, err)
-				return
+		}
+		if b.count() == 0 {
+			cfg.batches.giveBack()
+		}
+		claimed = false
+		summary.addBatch(b.count(), b.scanned-b.count()-b.duplicates, b.duplicates)
+		cfg.progress.addBatch(b.count(), b.lastID)
+		cfg.heartbeat.addBatch(b.count(), b.lastID)
+		if b.duplicates > 0 {
+			log.Printf("⚠️ Worker %d: Dropped %d documents with already exported _ids from batch %d\n", workerID, b.duplicates, batchNum)
+		}
+
+		// Save last processed _id for resumption. WriteBatch has closed every
+		// file of the batch by now, so a crash can't skip unflushed documents.
+		lastID = b.lastID
+		if !cfg.noCheckpoint {
+			if cfg.partitions != nil {
+				cfg.partitions.save(item.partition, batchNum, lastID)
+			} else {
+				saveLastID(cfg, lastID)
 			}
-			file.Close()
+			recordHistory(cfg, "save", workerID, batchNum, lastID)
+		}
 
-			// Save last processed _id for resumption
-			lastID = results[len(results)-1]["_id"].(primitive.ObjectID)
-			saveLastID(lastID)
+		// Print progress logs
+		log.Printf("✅ Worker %d: Exported batch %d (%d records, last _id %s) -> %s\n", workerID, batchNum, b.count(), lastID, filePath)
 
-			// Print progress logs
-			log.Printf("✅ Worker %d: Exported batch %d (%d records) -> %s\n", workerID, batchNum, len(results), filePath)
+		batchNum++
+	}
+}
 
-			batchNum++
+// Export the whole collection in a single $natural-order scan, or sorted by
+// the timeField for time-series collections or by --text-sort-score's
+// relevance (not resumable)
+func exportNaturalOrder(workerID int, cfg *config, collection *mongo.Collection, writer batchWriter, summary *exportSummary) error {
+	findOpts := options.Find().SetSort(bson.D{{Key: "$natural", Value: 1}}).SetNoCursorTimeout(cfg.noCursorTimeout)
+	if cfg.timeField != "" {
+		dir := 1
+		if cfg.descending {
+			dir = -1
 		}
+		// Servers before 6.0 sort time-series measurements in memory, so let them spill to disk
+		findOpts.SetSort(bson.D{{Key: cfg.timeField, Value: dir}}).SetAllowDiskUse(true)
+	} else if cfg.textSortScore {
+		// _id breaks ties, so documents with the same score keep a stable order
+		key := cfg.textScoreField
+		if key == "" {
+			key = "score"
+		}
+		findOpts.SetSort(bson.D{{Key: key, Value: textScore}, {Key: "_id", Value: 1}}).SetAllowDiskUse(true)
+	}
+	withProjection(cfg, findOpts)
+	// The scan can't be resumed, so a timed-out batch fails it rather than
+	// being retried
+	ctx, cancel := opContext(cfg)
+	cursor, err := collection.Find(
+		ctx,
+		paginationFilter(cfg.filter, docID{}, false),
+		findOpts,
+	)
+	cancel()
+	if err != nil {
+		return describeOpError(cfg, "fetch data", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	batchNum := 1
+	for {
+		if !cfg.batches.take() {
+			more, err := cursorHasMore(cfg, cursor)
+			if err != nil {
+				return describeOpError(cfg, "check for more data", err)
+			}
+			if !more {
+				return nil
+			}
+			return errBatchLimit
+		}
+		cfg.lag.wait()
+		ctx, cancel := opContext(cfg)
+		b, err := readBatch(ctx, workerID, batchNum, cfg.sizer.next(), cfg, cursor)
+		cancel()
+		if err != nil {
+			return describeOpError(cfg, "decode batch", err)
+		}
+		if b.scanned == 0 {
+			cfg.batches.giveBack()
+			return nil
+		}
+		if b.count() == 0 {
+			cfg.batches.giveBack()
+		}
+
+		logSamples(workerID, cfg, b)
+		if b.count() > 0 {
+			filePath, err := writer.WriteBatch(workerID, batchNum, b)
+			if err != nil {
+				return err
+			}
+			log.Printf("✅ Worker %d: Exported batch %d (%d records) -> %s\n", workerID, batchNum, b.count(), filePath)
+		}
+		summary.addBatch(b.count(), b.scanned-b.count()-b.duplicates, b.duplicates)
+		cfg.progress.addBatch(b.count(), b.lastID)
+		cfg.heartbeat.addBatch(b.count(), b.lastID)
+		if b.duplicates > 0 {
+			log.Printf("⚠️ Worker %d: Dropped %d documents with already exported _ids from batch %d\n", workerID, b.duplicates, batchNum)
+		}
+		batchNum++
+	}
+}
+
+// Combine the base filter with the _id pagination bound, which points
+// backward when paginating in descending order
+func paginationFilter(base bson.M, lastID docID, descending bool) bson.M {
+	if lastID.IsZero() {
+		return andFilters(base, nil)
+	}
+	op := "$gt"
+	if descending {
+		op = "$lt"
+	}
+	return andFilters(base, bson.M{"_id": bson.M{op: lastID.value()}})
+}
+
+// Combine two filters so documents must match both, either may be empty
+func andFilters(a, b bson.M) bson.M {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return bson.M{}
+	case len(a) == 0:
+		return b
+	case len(b) == 0:
+		return a
+	default:
+		return bson.M{"$and": bson.A{a, b}}
+	}
+}
+
+// An _id for logs, or the placeholder if it's unset
+func idString(id docID, unset string) string {
+	if id.IsZero() {
+		return unset
+	}
+	return id.String()
+}
+
+// Documents read from the cursor for one batch
+type batch struct {
+	docs       []bson.M   // Decoded documents, for the json and csv formats
+	ordered    []bson.D   // Decoded documents in field order, for json with --preserve-field-order
+	raw        []bson.Raw // Undecoded documents, for the bson and ejson pass-through formats
+	lastID     docID      // _id of the last document read, even if it was skipped
+	scanned    int        // Documents read from the cursor, including skipped ones
+	duplicates int        // Documents dropped by --dedupe-on-id
+	dropped    int        // Documents dropped by --transform-plugin, counted as skipped
+}
+
+// Number of documents to write
+func (b *batch) count() int {
+	return len(b.docs) + len(b.ordered) + len(b.raw)
+}
+
+// Document i of the batch as raw BSON, whichever form it's held in
+func (b *batch) rawDoc(i int) (bson.Raw, error) {
+	switch {
+	case b.docs != nil:
+		return bson.Marshal(b.docs[i])
+	case b.ordered != nil:
+		return bson.Marshal(b.ordered[i])
+	}
+	return b.raw[i], nil
+}
+
+// Log a preview of every cfg.sampleEvery-th exported document, counted across
+// all workers. Documents are sampled as they're written, after --map-expr and
+// --id-as, so fields an expression removes never reach the log.
+func logSamples(workerID int, cfg *config, b *batch) {
+	if cfg.sampleEvery <= 0 {
+		return
+	}
+	for i := 0; i < b.count(); i++ {
+		n := cfg.emitted.Add(1)
+		if n%int64(cfg.sampleEvery) != 0 {
+			continue
+		}
+		raw, err := b.rawDoc(i)
+		if err != nil {
+			log.Printf("⚠️ Worker %d: Can't preview sample document #%d: %v\n", workerID, n, err)
+			continue
+		}
+		log.Printf("🔬 Worker %d: Sample document #%d: %s\n", workerID, n, documentPreview(raw))
 	}
 }
 
-// Save last processed _id to file
-func saveLastID(lastID primitive.ObjectID) {
-	file, err := os.Create(lastIDFile)
+// Read up to size documents from the cursor, decoding them one at a time so
+// a single corrupt document can be isolated instead of failing the whole
+// batch. Pass-through formats skip decoding and keep the raw BSON instead,
+// only validating it. If the cursor fails, the documents read so far are
+// returned along with the error.
+func readBatch(ctx context.Context, workerID, batchNum, size int, cfg *config, cursor *mongo.Cursor) (*batch, error) {
+	b := &batch{}
+	for b.scanned < size {
+		start := cfg.bench.start()
+		ok := cursor.Next(ctx)
+		cfg.bench.stop(benchRead, start)
+		if !ok {
+			break
+		}
+		start = cfg.bench.start()
+		err := b.add(workerID, batchNum, cfg, cursor.Current)
+		cfg.bench.stop(benchDecode, start)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// An interrupted read still returns its documents, transformed, since the
+	// caller may write them
+	cursorErr := cursor.Err()
+	if cfg.plugin != nil {
+		start := cfg.bench.start()
+		err := cfg.plugin.apply(workerID, batchNum, b)
+		cfg.bench.stop(benchDecode, start)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, cursorErr
+}
+
+// Add one document read from the server to the batch, in the form the
+// output format needs. The document's buffer may be reused by the caller.
+func (b *batch) add(workerID, batchNum int, cfg *config, current bson.Raw) error {
+	b.scanned++
+
+	// Look up _id from the raw document so pagination survives a failed decode
+	rawID, idErr := current.LookupErr("_id")
+	if id, ok := rawDocID(rawID); idErr == nil && ok && id.uuid == (cfg.idType == idTypeUUID) {
+		b.lastID = id
+	}
+	if cfg.dedupe != nil && idErr == nil && cfg.dedupe.seen(rawID) {
+		b.duplicates++
+		return nil
+	}
+
+	var doc bson.M
+	var ordered bson.D
+	var renamed bson.Raw
+	var err error
+	raw := isRawFormat(cfg.format)
+	stage := "decode"
+	if raw {
+		err = current.Validate()
+	} else if cfg.preserveFieldOrder {
+		err = bson.Unmarshal(current, &ordered)
+	} else {
+		err = bson.Unmarshal(current, &doc)
+	}
+
+	// Anything failing from here on is a transform that can't be applied to
+	// this document, as opposed to one that can't be read at all
+	if err == nil {
+		stage = "transform"
+		// Stripped first, so no transform can write a value derived from a
+		// field that isn't allowed
+		if cfg.allowlist != nil {
+			if cfg.preserveFieldOrder {
+				ordered = cfg.allowlist.filterD(ordered)
+			} else if !raw {
+				doc = cfg.allowlist.filterM(doc)
+			}
+		}
+		if raw {
+			if cfg.idKey != "" {
+				renamed, err = renameRawID(current, cfg.idKey)
+			}
+		} else if cfg.preserveFieldOrder {
+			if cfg.idKey != "" {
+				err = renameOrderedID(ordered, cfg.idKey)
+			}
+		} else {
+			id, hasID := doc["_id"]
+			if cfg.mapProgram != nil {
+				doc, err = applyMapExpr(cfg.mapProgram, doc)
+			}
+			// Taken before --map-expr, so an expression can drop _id and still get it here
+			if err == nil && cfg.idKey != "" {
+				err = renameID(doc, id, hasID, cfg.idKey)
+			}
+		}
+	}
+	// Encrypted after --map-expr, so the paths name fields of the written document
+	if err == nil && cfg.encryptor != nil {
+		if cfg.preserveFieldOrder {
+			err = cfg.encryptor.apply(ordered)
+		} else if !raw {
+			err = cfg.encryptor.apply(doc)
+		}
+	}
+	if err == nil && cfg.maxDepth > 0 {
+		cut := false
+		if cfg.preserveFieldOrder {
+			_, cut = limitDepth(ordered, 1, cfg.maxDepth)
+		} else if !raw {
+			_, cut = limitDepth(doc, 1, cfg.maxDepth)
+		}
+		if cut {
+			log.Printf("✂️ Worker %d: Document _id=%v nests deeper than --max-depth %d, wrote the deeper levels as JSON strings\n", workerID, rawID, cfg.maxDepth)
+		}
+	}
 	if err != nil {
+		if !cfg.skipErrors {
+			return fmt.Errorf("document #%d in batch %d (_id=%v) failed to %s: %w", b.scanned, batchNum, rawID, stage, err)
+		}
+		log.Printf("⚠️ Worker %d: Skipping document #%d in batch %d (_id=%v) that failed to %s: %v\n", workerID, b.scanned, batchNum, rawID, stage, err)
+		cfg.errorLog.record(workerID, batchNum, b.scanned, stage, current, err)
+		return nil
+	}
+
+	if renamed != nil {
+		b.raw = append(b.raw, renamed)
+	} else if raw {
+		// The cursor reuses its buffer, so keep a copy
+		b.raw = append(b.raw, append(bson.Raw(nil), current...))
+	} else if cfg.preserveFieldOrder {
+		b.ordered = append(b.ordered, ordered)
+	} else {
+		b.docs = append(b.docs, doc)
+	}
+	return nil
+}
+
+// Save last processed _id to file. The new value is written to a temp file
+// and renamed over the checkpoint, so a crash mid-write leaves either the old
+// or the new _id in place, never a truncated file.
+func saveLastID(cfg *config, lastID docID) {
+	if err := writeFileAtomic(checkpointPath(cfg, lastIDFile), []byte(lastID.String())); err != nil {
 		log.Printf("⚠️ Warning: Failed to save last _id: %v\n", err)
-		return
 	}
-	defer file.Close()
+}
 
-	_, err = file.WriteString(lastID.Hex())
+// Write data to a temp file next to path, sync it and rename it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
-		log.Printf("⚠️ Warning: Failed to write last _id to file: %v\n", err)
+		return err
 	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once the rename succeeds
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Fetch the checkpoint document and log it so the resume point can be sanity checked
+func verifyResumePoint(collection *mongo.Collection, lastID docID) error {
+	var doc bson.Raw
+	err := collection.FindOne(context.TODO(), bson.M{"_id": lastID.value()}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("⚠️ Warning: Checkpoint document %s no longer exists. The export still resumes after its _id, but documents re-inserted with lower _ids will be skipped.\n", lastID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch checkpoint document: %w", err)
+	}
+	if lastID.uuid {
+		log.Printf("🔎 Checkpoint document %s: %s\n", lastID, documentPreview(doc))
+		return nil
+	}
+	log.Printf("🔎 Checkpoint document %s (created %s): %s\n", lastID, lastID.objectID().Timestamp().UTC().Format(time.RFC3339), documentPreview(doc))
+	return nil
+}
+
+// Short "key: value, ..." preview of a document's first top-level fields
+func documentPreview(doc bson.Raw) string {
+	const maxFields, maxValue = 8, 60
+	elements, err := doc.Elements()
+	if err != nil {
+		return fmt.Sprintf("(invalid BSON: %v)", err)
+	}
+	var parts []string
+	for i, element := range elements {
+		if i == maxFields {
+			parts = append(parts, fmt.Sprintf("... %d more fields", len(elements)-maxFields))
+			break
+		}
+		value := element.Value().String()
+		if len(value) > maxValue {
+			value = value[:maxValue] + "..."
+		}
+		parts = append(parts, element.Key()+": "+value)
+	}
+	return strings.Join(parts, ", ")
 }
 
 // Load last processed _id from file
-func loadLastID() primitive.ObjectID {
-	data, err := os.ReadFile(lastIDFile)
+func loadLastID(cfg *config) docID {
+	data, err := os.ReadFile(checkpointPath(cfg, lastIDFile))
 	if err != nil {
 		log.Println("🔄 No previous last_id found. Starting fresh...")
-		return primitive.NilObjectID
+		return docID{}
 	}
 
-	lastID, err := primitive.ObjectIDFromHex(strings.TrimSpace(string(data)))
+	lastID, err := parseDocID(strings.TrimSpace(string(data)), cfg.idType)
 	if err != nil {
 		log.Printf("⚠️ Warning: Invalid _id format in last_id.txt, starting from scratch.")
-		return primitive.NilObjectID
+		return docID{}
 	}
 
-	log.Printf("🔄 Resuming export from last _id: %s\n", lastID.Hex())
+	log.Printf("🔄 Resuming export from last _id: %s\n", lastID)
 	return lastID
 }
 
-// Get user inputs safely
-func getUserInputs() (string, string, string) {
-	reader := bufio.NewReader(os.Stdin)
+// Resume point of a single-worker export: the end of the manifest if one is
+// kept, since it's written before the checkpoint and so never behind,
+// otherwise last_id.txt
+func resumeLastID(cfg *config, resume manifestState) docID {
+	lastID := loadLastID(cfg)
+	if resume.entries == 0 {
+		return lastID
+	}
+	if resume.lastID != lastID {
+		log.Printf("📒 %s ends at _id %s but %s has %s, resuming from the manifest\n", manifestFile, idString(resume.lastID, "start"), lastIDFile, idString(lastID, "start"))
+	}
+	return resume.lastID
+}
+
+// Move a resume point back by --resume-safety-window, so documents whose
+// ObjectIDs were generated up to that long before it, but that only became
+// visible after the checkpoint passed them, are exported too. Descending
+// exports move it forward instead.
+func rewindResumeID(cfg *config, workerID int, lastID docID) docID {
+	if cfg.resumeWindow <= 0 || lastID.IsZero() || lastID.uuid {
+		return lastID
+	}
+	created := lastID.objectID().Timestamp()
+	at := created.Add(-cfg.resumeWindow)
+	if cfg.descending {
+		at = created.Add(cfg.resumeWindow)
+	}
+	var rewound docID
+	if at.Unix() > 0 {
+		rewound = objectDocID(primitive.NewObjectIDFromTimestamp(at))
+	}
+	log.Printf("⏪ Worker %d: Rewinding the resume point by %s for --resume-safety-window, from %s to %s\n", workerID, cfg.resumeWindow, lastID, idString(rewound, "start"))
+	return rewound
+}
+
+// Parse command-line flags
+func parseFlags() *config {
+	cfg := &config{}
+	flag.BoolVar(&cfg.noCheckpoint, "no-checkpoint", false, "ignore any existing "+lastIDFile+" and don't write one (one-shot full export)")
+	flag.BoolVar(&cfg.writeManifest, "manifest", false, "record every written batch in "+manifestFile+" in the export directory, and resume from it even if "+lastIDFile+" is missing")
+	flag.BoolVar(&cfg.withMetadata, "with-metadata", false, "write "+metadataFile+" in the export directory with the collection's options (collation, validator...) and index definitions, to recreate it on restore")
+	flag.StringVar(&cfg.checksumAlgorithm, "checksum-algorithm", "", "with --manifest, record a checksum of every batch file: sha256, sha1, crc32 or xxhash (fastest)")
+	flag.IntVar(&cfg.historyMax, "checkpoint-history", 0, "append every checkpoint advance to "+historyFile+", gzipping it to "+historyFile+".1.gz once it holds this many entries (0 = off)")
+	flag.BoolVar(&cfg.naturalOrder, "natural-order", false, "scan in $natural order for the fastest full dump; disables _id-based resumption")
+	flag.BoolVar(&cfg.hashedScan, "hashed-shard-scan", false, "if the collection is sharded on a hashed key, scan it chunk by chunk in shard key order so each read stays on one shard, checkpointing finished chunks (otherwise paginate by _id)")
+	flag.StringVar(&cfg.idType, "id-type", idTypeObjectID, "type of the _id to paginate and resume on: objectid, or uuid for UUIDs stored as binary subtype 4")
+	sortOrder := flag.String("sort-order", "asc", "export in asc (oldest _id first) or desc (newest _id first) order; the checkpoint resumes in the same direction")
+	flag.DurationVar(&cfg.resumeWindow, "resume-safety-window", 0, "when resuming, start this much earlier in ObjectID time than the checkpoint (e.g. 30s), re-exporting the overlap to catch documents that arrived late with lower _ids (0 = off)")
+	flag.BoolVar(&cfg.resumeVerify, "resume-verify", false, "before resuming, fetch the checkpoint _id's document and log its key fields so you can check the resume point")
+	workers := flag.String("workers", "1", "number of parallel workers, each exporting an equal slice of the _id range, or auto to size it to the CPUs and shards; each partition resumes from its own checkpoint in "+checkpointFile)
+	flag.DurationVar(&cfg.warmup, "warmup", 0, "before exporting, spend up to this long paging the _id index into the server's cache with a covered scan, e.g. 2m (0 = off)")
+	flag.BoolVar(&cfg.skipErrors, "skip-errors", false, "log and skip documents that fail to decode or transform instead of aborting, recording them in "+errorsFile)
+	flag.BoolVar(&cfg.collStats, "collstats", false, "run collStats before exporting and log the collection size and an export size estimate")
+	flag.Int64Var(&cfg.maxExportBytes, "max-export-bytes", 0, "refuse to start if the estimated export size exceeds this many bytes (implies --collstats, 0 = no limit)")
+	flag.BoolVar(&cfg.force, "force", false, "resume even though --format or compression differs from the run that wrote the checkpoint")
+	failFast := flag.Bool("fail-fast", false, "when exporting several collections, stop at the first collection that fails (the default)")
+	flag.BoolVar(&cfg.bestEffort, "best-effort", false, "when exporting several collections, log failed collections and carry on with the rest, exiting with status 2 if any failed")
+	flag.BoolVar(&cfg.yes, "yes", false, "proceed even if the estimated export size exceeds --max-export-bytes, and without asking for --interactive-confirm")
+	flag.BoolVar(&cfg.confirm, "interactive-confirm", false, "after the preflight checks, show what will be exported where and ask before starting (--yes answers for you)")
+	flag.BoolVar(&cfg.allowPrimary, "allow-primary", false, "export collections over --primary-guard-bytes even when reading from a replica set primary")
+	flag.Int64Var(&cfg.primaryGuardBytes, "primary-guard-bytes", 10<<30, "refuse to read collections with at least this many bytes of data from a primary unless --allow-primary is given (0 = never check)")
+	flag.StringVar(&cfg.format, "format", "json", "output format: json, csv (nested fields flattened into dotted columns), bson (raw, mongorestore-compatible) or ejson/jsonl (one extended JSON document per line); add -gz to compress, e.g. jsonl-gz")
+	flag.IntVar(&cfg.flattenDepth, "flatten-depth", 0, "with --format=csv, flatten at most this many nesting levels into columns and keep deeper subtrees as JSON (0 = no limit)")
+	flag.IntVar(&cfg.maxDepth, "max-depth", 0, "with --format=json or csv, write sub-documents and arrays nested deeper than this many levels as JSON strings, logging the document's _id (0 = no limit)")
+	flag.BoolVar(&cfg.outputStdout, "output-stdout", false, "write documents to stdout as NDJSON (one JSON or, with --format=ejson, extended JSON document per line) instead of files, moving prompts and logs to stderr")
+	flag.BoolVar(&cfg.normalizeEJSON, "normalize-ejson", false, "with --format=ejson, write every date as an ISO-8601 $date string, including those before 1970 that relaxed extended JSON writes as $numberLong")
+	flag.BoolVar(&cfg.preserveFieldOrder, "preserve-field-order", false, "with --format=json, keep each document's field order (decodes into bson.D, which is slower) instead of Go's map order")
+	flag.StringVar(&cfg.cpuProfile, "cpuprofile", "", "write a pprof CPU profile of the run to this file, for go tool pprof")
+	flag.StringVar(&cfg.memProfile, "memprofile", "", "write a pprof heap profile to this file at the end of the run, for go tool pprof")
+	flag.Int64Var(&cfg.logMaxBytes, "log-max-bytes", 0, "rotate "+logFilePath+" to "+logFilePath+".1 before it grows past this many bytes (0 = never rotate)")
+	flag.IntVar(&cfg.logKeep, "log-keep", 5, "with --log-max-bytes, keep this many rotated log segments, deleting older ones")
+	flag.BoolVar(&cfg.logCompress, "log-compress", false, "with --log-max-bytes, gzip rotated log segments to "+logFilePath+".1.gz and so on")
+	flag.BoolVar(&cfg.logStdout, "log-to-stdout", true, "write the log to stdout as well as "+logFilePath+" (stderr with --output-stdout); set to false to only write the file")
+	flag.BoolVar(&cfg.requireLog, "require-log-file", false, "exit if "+logFilePath+" can't be opened, instead of only logging to stderr")
+	flag.StringVar(&cfg.progressFile, "progress-file", "", "keep a JSON snapshot of the run's progress (documents, last _id, rate, ETA) in this file for monitoring, rewritten atomically")
+	flag.DurationVar(&cfg.progressInterval, "progress-interval", 10*time.Second, "with --progress-file, how often to rewrite it")
+	flag.DurationVar(&cfg.heartbeatEvery, "heartbeat-interval", 0, "log that the export is still working, with its document count and current _id, this often, e.g. 1m (0 for never)")
+	benchmarkRun := flag.Bool("benchmark", false, "time MongoDB reads, BSON decoding, encoding and writes separately and log the breakdown at the end")
+	flag.IntVar(&cfg.sampleValidate, "sample-validate", 0, "after the export, re-fetch this many randomly sampled written documents by _id and report any that differ from the collection")
+	encryptFields := flag.String("encrypt-fields", "", "comma-separated fields (dotted for nested ones, e.g. ssn,card.number) to encrypt with AES-256-GCM using --encrypt-key-file")
+	encryptKeyFile := flag.String("encrypt-key-file", "", "file holding the 256-bit --encrypt-fields key as hex or base64")
+	allowlistFile := flag.String("field-allowlist", "", "file listing the only fields (one dotted path per line, _id required) allowed in the output; every other field is stripped (json and csv only)")
+	transformCommand := flag.String("transform-plugin", "", "pipe every batch through this command, which reads documents as NDJSON on stdin and writes the reshaped ones, or null to drop one, to stdout (json and csv only)")
+	mapExpr := flag.String("map-expr", "", "reshape each document with an expression, e.g. 'set(unset(doc, \"password\"), \"total\", doc.price * doc.qty)' (json and csv only)")
+	flag.StringVar(&cfg.idKey, "id-as", "", "write each document's _id under this key instead, e.g. __export_id, so the output schema has no _id (resumption still uses the real _id)")
+	flag.StringVar(&cfg.csvNull, "csv-null", "", "with --format=csv, text written for fields that are explicitly null, e.g. NULL or \\N (default empty)")
+	avroSchemaFile := flag.String("avro-schema", "", "with --format=avro, write every file with the record schema in this JSON file instead of inferring one from each batch")
+	flag.StringVar(&cfg.avroCodec, "avro-codec", "null", "with --format=avro, compress the blocks of each file with null (none), deflate or snappy")
+	unwindFields := flag.String("unwind", "", "with --format=csv, comma-separated array fields (dotted for nested ones) to expand into a row per element, e.g. items or items,items.tags")
+	flag.StringVar(&cfg.csvMissing, "csv-missing", "", "with --format=csv, text written for fields a document doesn't have (default empty)")
+	flag.StringVar(&cfg.jsonRoot, "json-root", "", "wrap JSON output in an object under this key, e.g. {\"documents\": [...]}, instead of a bare array")
+	flag.BoolVar(&cfg.jsonRootMeta, "json-root-meta", false, "with --json-root, add count, first_id and last_id fields next to the array")
+	flag.BoolVar(&cfg.singleFile, "single-file", false, "write every batch into one JSON array file instead of one file per batch (not resumable)")
+	flag.BoolVar(&cfg.compressEachBatch, "compress-each-batch", false, "gzip every batch file independently, so files can be uploaded and decoded in parallel")
+	flag.BoolVar(&cfg.compressFinal, "compress-final", false, "gzip the whole --single-file output as one stream for the best ratio")
+	flag.BoolVar(&cfg.emitSequence, "emit-sequence", false, "add a "+sequenceField+" field numbering every document from 1 in the order it's written across all workers, so interleaved files can be put back in one order")
+	flag.BoolVar(&cfg.emitSidecars, "emit-sidecars", false, "write batch_N.meta.json next to every batch file with its document count, first/last _id, size and time written")
+	flag.BoolVar(&cfg.mergeOnComplete, "merge-on-complete", false, "once the export finishes, move worker_M/batch_N files into a flat "+exportDir+"/batch_N_worker_M layout")
+	flag.DurationVar(&cfg.flushInterval, "flush-interval", 0, "with --single-file, flush and fsync the output at least this often, e.g. 30s, so a hard crash loses less (0 = only on close)")
+	flag.IntVar(&cfg.flushDocs, "flush-docs", 0, "with --single-file, flush and fsync the output every this many documents (0 = only on close)")
+	flag.Int64Var(&cfg.fileMaxBytes, "file-max-bytes", 0, "split a batch into part files of roughly this many (uncompressed) bytes, rolling over between documents (0 = no limit)")
+	flag.BoolVar(&cfg.preserveOrder, "preserve-order", false, "with --single-file, merge all workers' output in _id order so the file is globally sorted")
+	addConnectionFlags(flag.CommandLine, cfg)
+	flag.BoolVar(&cfg.throttleLag, "throttle-on-replication-lag", false, "check replSetGetStatus every 10s and pause between batches while any secondary lags the primary by more than --max-repl-lag")
+	flag.DurationVar(&cfg.maxReplLag, "max-repl-lag", 30*time.Second, "with --throttle-on-replication-lag, replication lag that pauses the export")
+	flag.IntVar(&cfg.maxOpenFiles, "max-open-files", 0, "keep at most this many batch, sidecar and spool files open at once across all workers and sources, waiting for a free slot or closing idle spool files (0 = no limit)")
+	flag.Int64Var(&cfg.memLimit, "mem-limit", 0, "adapt the batch size to keep heap usage under this many bytes (0 = fixed batches of 100K)")
+	flag.IntVar(&cfg.sampleEvery, "log-sample-every", 0, "log a short preview of every Nth exported document, as written after --map-expr and --id-as (0 = off)")
+	flag.StringVar(&cfg.dedupeMode, "dedupe-on-id", "", "drop documents whose _id was already written in this run: exact (in-memory set) or bloom (bloom filter, far less memory but may rarely drop a unique document)")
+	flag.BoolVar(&cfg.sinceOplog, "since-oplog", false, "incremental exports from the oplog: the first run exports everything, later runs only the documents inserted, updated or deleted since the cluster time the previous run reached")
+	tailSeconds := flag.Int("tail-seconds", 0, "after the export, write documents inserted or updated meanwhile, read from a change stream for this many seconds (0 = off)")
+	sourcesFile := flag.String("sources", "", "export the collections listed in this JSON file, a list of {name, uri, database, collection}, instead of prompting")
+	flag.IntVar(&cfg.sourceConcurrency, "source-concurrency", 1, "with --sources, export this many sources at the same time")
+	flag.DurationVar(&cfg.maxDuration, "max-duration", 0, "abort the export with exit status 3 once it has run this long, e.g. 6h (0 = no limit)")
+	flag.BoolVar(&cfg.indexCheck, "preflight-index-check", false, "before exporting, check that an index leads with every field the export sorts on (_id, the time-series timeField, the --delta-field) and fail if one doesn't")
+	flag.BoolVar(&cfg.createTempIndex, "create-temp-index", false, "with --preflight-index-check, create a missing sort index instead of failing, and drop it once the export finishes")
+	flag.IntVar(&cfg.maxBatches, "max-batches", 0, "stop cleanly once this many batches have been written across all workers and collections, checkpointed so a later run continues from there (0 = no limit)")
+	flag.BoolVar(&cfg.snapshot, "snapshot", false, "read the whole export from one point-in-time snapshot (replica sets and sharded clusters, MongoDB 5.0+); single worker only")
+	flag.BoolVar(&cfg.noCursorTimeout, "no-cursor-timeout", false, "stop the server from closing cursors left idle for 10 minutes, e.g. while slow writes hold up a natural-order scan")
+	flag.DurationVar(&cfg.opTimeout, "op-timeout", 0, "deadline for fetching each batch, e.g. 2m; a batch that times out is retried (0 = no deadline)")
+	flag.StringVar(&cfg.webhookURL, "webhook-url", "", "POST the final export summary as JSON to this URL on completion or failure")
+	minIDHex := flag.String("min-id", "", "only export documents with _id greater than this ObjectID (hex), regardless of the checkpoint")
+	maxIDHex := flag.String("max-id", "", "only export documents with _id up to and including this ObjectID (hex), regardless of the checkpoint")
+	flag.StringVar(&cfg.deltaField, "delta-field", "", "only export documents whose value of this indexed, monotonic field (e.g. updatedAt) is newer than the previous run's watermark")
+	flag.StringVar(&cfg.distinctField, "distinct", "", "export the unique values of this field (e.g. country) instead of full documents")
+	flag.StringVar(&cfg.distinctOutput, "distinct-output", "array", "with --distinct, write the values as a JSON array or as lines, one JSON value per line")
+	flag.StringVar(&cfg.textSearch, "text-search", "", "only export documents matching this $text search, e.g. 'coffee -decaf' (needs a text index)")
+	flag.StringVar(&cfg.textLanguage, "text-language", "", "with --text-search, language for stemming and stop words, e.g. spanish (default: the index's)")
+	flag.StringVar(&cfg.textScoreField, "text-score-field", "", "with --text-search, add each document's relevance score under this field, e.g. score")
+	flag.BoolVar(&cfg.textSortScore, "text-sort-score", false, "with --text-search, export the most relevant documents first, then by _id, in a single pass (not resumable)")
+	arraySlices := flag.String("array-slice", "", "comma-separated field:n slices that keep only the first n elements of an array field (the last n if negative), or field:skip:n, e.g. comments:5,history:-10")
+	flag.Parse()
+
+	if err := validateOutput(cfg); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	if err := validateConnectionFlags(cfg); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	if cfg.checksumAlgorithm != "" {
+		if !cfg.writeManifest {
+			log.Fatalf("❌ --checksum-algorithm records checksums in the manifest and requires --manifest")
+		}
+		if cfg.singleFile {
+			log.Fatalf("❌ --checksum-algorithm checksums each batch file and can't be used with --single-file, whose file is still being written")
+		}
+		if err := validateChecksumAlgorithm(cfg.checksumAlgorithm); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+
+	if *mapExpr != "" {
+		if isRawFormat(cfg.format) || cfg.preserveFieldOrder {
+			log.Fatalf("❌ --map-expr works on decoded documents and can't be used with --format=%s or --preserve-field-order", cfg.format)
+		}
+		program, err := compileMapExpr(*mapExpr)
+		if err != nil {
+			log.Fatalf("❌ Invalid --map-expr: %v", err)
+		}
+		cfg.mapProgram = program
+	}
+
+	if *allowlistFile != "" {
+		if isRawFormat(cfg.format) {
+			log.Fatalf("❌ --field-allowlist works on decoded documents and can't be used with --format=%s", cfg.format)
+		}
+		allowlist, err := loadFieldAllowlist(*allowlistFile)
+		if err != nil {
+			log.Fatalf("❌ Invalid --field-allowlist: %v", err)
+		}
+		cfg.allowlist = allowlist
+	}
+
+	if *transformCommand != "" {
+		if isRawFormat(cfg.format) {
+			log.Fatalf("❌ --transform-plugin works on decoded documents and can't be used with --format=%s", cfg.format)
+		}
+		plugin, err := newTransformPlugin(*transformCommand)
+		if err != nil {
+			log.Fatalf("❌ Invalid --transform-plugin: %v", err)
+		}
+		cfg.plugin = plugin
+	}
+
+	if (*encryptFields == "") != (*encryptKeyFile == "") {
+		log.Fatalf("❌ --encrypt-fields and --encrypt-key-file must be used together")
+	}
+	if *encryptFields != "" {
+		if isRawFormat(cfg.format) || cfg.distinctField != "" {
+			log.Fatalf("❌ --encrypt-fields works on decoded documents and can't be used with --format=%s or --distinct", cfg.format)
+		}
+		encryptor, err := newFieldEncryptor(*encryptFields, *encryptKeyFile)
+		if err != nil {
+			log.Fatalf("❌ Invalid --encrypt-fields: %v", err)
+		}
+		cfg.encryptor = encryptor
+	}
+	if *avroSchemaFile != "" {
+		if cfg.format != "avro" {
+			log.Fatalf("❌ --avro-schema only applies to --format=avro")
+		}
+		schema, err := loadAvroSchema(*avroSchemaFile)
+		if err != nil {
+			log.Fatalf("❌ Invalid --avro-schema: %v", err)
+		}
+		cfg.avroSchema = schema
+	}
+	if *unwindFields != "" {
+		if cfg.format != "csv" {
+			log.Fatalf("❌ --unwind only applies to --format=csv")
+		}
+		fields, err := parseUnwindFields(*unwindFields)
+		if err != nil {
+			log.Fatalf("❌ Invalid --unwind: %v", err)
+		}
+		cfg.unwind = fields
+	}
+	if *benchmarkRun {
+		cfg.bench = &benchmark{}
+	}
+	if cfg.primaryGuardBytes < 0 {
+		log.Fatalf("❌ --primary-guard-bytes must be 0 (never check) or a positive size")
+	}
+	if cfg.heartbeatEvery < 0 {
+		log.Fatalf("❌ --heartbeat-interval can't be negative")
+	}
+	if cfg.progressInterval <= 0 {
+		log.Fatalf("❌ --progress-interval must be positive")
+	}
+	if cfg.maxOpenFiles < 0 {
+		log.Fatalf("❌ --max-open-files must be 0 (no limit) or more")
+	}
+	cfg.openFiles = newFileLimiter(cfg.maxOpenFiles)
+	if cfg.sampleValidate < 0 {
+		log.Fatalf("❌ Invalid --sample-validate %d, expected a positive number of documents", cfg.sampleValidate)
+	}
+	if cfg.sampleValidate > 0 && cfg.plugin != nil {
+		log.Fatalf("❌ --sample-validate can't rerun --transform-plugin, which works on whole batches")
+	}
+	if cfg.sampleValidate > 0 && cfg.encryptor != nil {
+		log.Fatalf("❌ --sample-validate can't compare --encrypt-fields values, which are encrypted with a random nonce each time")
+	}
+
+	if *failFast && cfg.bestEffort {
+		log.Fatalf("❌ --fail-fast and --best-effort are mutually exclusive")
+	}
+
+	if *workers != "auto" {
+		n, err := strconv.Atoi(*workers)
+		if err != nil || n < 1 {
+			log.Fatalf("❌ Invalid --workers %q, expected a positive number or auto", *workers)
+		}
+		cfg.workers = n
+	}
+	if cfg.maxPoolSize > 0 && uint64(cfg.workers) > cfg.maxPoolSize {
+		log.Fatalf("❌ --max-pool-size (%d) must be at least --workers (%d), or workers would wait on each other for connections", cfg.maxPoolSize, cfg.workers)
+	}
+	if cfg.workers > 1 && (cfg.naturalOrder || cfg.deltaField != "") {
+		log.Fatalf("❌ --workers splits the export by _id and can't be used with --natural-order or --delta-field")
+	}
+	if cfg.snapshot && (cfg.workers > 1 || cfg.distinctField != "") {
+		log.Fatalf("❌ --snapshot reads through a single session and can't be used with --workers above 1 or --distinct")
+	}
 
-	fmt.Print("Enter MongoDB connection string: ")
-	mongoURI, _ := reader.ReadString('\n')
-	mongoURI = strings.TrimSpace(mongoURI)
+	switch *sortOrder {
+	case "asc":
+	case "desc":
+		cfg.descending = true
+	default:
+		log.Fatalf("❌ Invalid --sort-order %q, expected asc or desc", *sortOrder)
+	}
+	if cfg.descending && (cfg.naturalOrder || cfg.preserveOrder) {
+		log.Fatalf("❌ --sort-order=desc paginates by _id and can't be used with --natural-order or --preserve-order")
+	}
 
-	fmt.Print("Enter database name: ")
-	dbName, _ := reader.ReadString('\n')
-	dbName = strings.TrimSpace(dbName)
+	var err error
+	if *minIDHex != "" {
+		if cfg.minID, err = primitive.ObjectIDFromHex(*minIDHex); err != nil {
+			log.Fatalf("❌ Invalid --min-id %q: %v", *minIDHex, err)
+		}
+	}
+	if *maxIDHex != "" {
+		if cfg.maxID, err = primitive.ObjectIDFromHex(*maxIDHex); err != nil {
+			log.Fatalf("❌ Invalid --max-id %q: %v", *maxIDHex, err)
+		}
+	}
+	if !cfg.minID.IsZero() && !cfg.maxID.IsZero() && bytes.Compare(cfg.minID[:], cfg.maxID[:]) >= 0 {
+		log.Fatalf("❌ --min-id must be less than --max-id")
+	}
+	if cfg.dedupeMode != "" && cfg.dedupeMode != "exact" && cfg.dedupeMode != "bloom" {
+		log.Fatalf("❌ Invalid --dedupe-on-id %q, expected exact or bloom", cfg.dedupeMode)
+	}
+	if *tailSeconds < 0 {
+		log.Fatalf("❌ --tail-seconds must be 0 (off) or more")
+	}
+	cfg.tail = time.Duration(*tailSeconds) * time.Second
+	if cfg.tail > 0 && (cfg.dedupeMode != "" || cfg.deltaField != "" || *minIDHex != "" || *maxIDHex != "") {
+		log.Fatalf("❌ --tail-seconds writes every change to the collection, including new versions of exported documents, and can't be used with --dedupe-on-id, --delta-field, --min-id or --max-id")
+	}
+	if cfg.sinceOplog && (cfg.noCheckpoint || cfg.naturalOrder || cfg.singleFile || cfg.outputStdout || cfg.tail > 0 || cfg.deltaField != "" || cfg.dedupeMode != "" || cfg.distinctField != "" || *minIDHex != "" || *maxIDHex != "") {
+		log.Fatalf("❌ --since-oplog keeps its position in the checkpoint and exports every change to the collection, so it can't be used with --no-checkpoint, --natural-order, --single-file, --output-stdout, --tail-seconds, --delta-field, --dedupe-on-id, --distinct, --min-id or --max-id")
+	}
+	if cfg.sampleEvery < 0 {
+		log.Fatalf("❌ --log-sample-every must be 0 (off) or a positive number of documents")
+	}
+	cfg.emitted = new(atomic.Int64)
+	if cfg.memLimit < 0 {
+		log.Fatalf("❌ --mem-limit must be 0 (disabled) or a positive number of bytes")
+	}
+	if *sourcesFile != "" {
+		sources, err := loadSources(*sourcesFile)
+		if err != nil {
+			log.Fatalf("❌ Invalid --sources: %v", err)
+		}
+		cfg.sources = sources
+	}
+	if cfg.throttleLag && cfg.maxReplLag <= 0 {
+		log.Fatalf("❌ --max-repl-lag must be positive")
+	}
+	if cfg.maxDuration < 0 {
+		log.Fatalf("❌ --max-duration must be 0 (no limit) or more")
+	}
+	if cfg.createTempIndex && !cfg.indexCheck {
+		log.Fatalf("❌ --create-temp-index requires --preflight-index-check")
+	}
+	if cfg.maxBatches < 0 {
+		log.Fatalf("❌ --max-batches must be 0 (no limit) or more")
+	}
+	if cfg.maxBatches > 0 && (cfg.tail > 0 || cfg.sinceOplog || cfg.distinctField != "") {
+		log.Fatalf("❌ --max-batches stops the collection scan and can't be used with --tail-seconds, --since-oplog or --distinct")
+	}
+	cfg.batches = newBatchLimit(cfg.maxBatches)
+	if cfg.opTimeout < 0 {
+		log.Fatalf("❌ --op-timeout must be 0 (no deadline) or more")
+	}
+	if cfg.sourceConcurrency < 1 {
+		log.Fatalf("❌ --source-concurrency must be at least 1")
+	}
+	if cfg.logMaxBytes < 0 {
+		log.Fatalf("❌ --log-max-bytes must be 0 (never rotate) or a positive size")
+	}
+	if cfg.logMaxBytes > 0 && cfg.logKeep < 1 {
+		log.Fatalf("❌ --log-keep must be at least 1")
+	}
+	if cfg.logCompress && cfg.logMaxBytes == 0 {
+		log.Fatalf("❌ --log-compress compresses rotated segments and requires --log-max-bytes")
+	}
+	if cfg.historyMax < 0 {
+		log.Fatalf("❌ --checkpoint-history must be 0 (disabled) or a positive number of entries")
+	}
+	rangeExport := !cfg.minID.IsZero() || !cfg.maxID.IsZero()
+	if cfg.deltaField != "" && (cfg.noCheckpoint || cfg.naturalOrder || cfg.singleFile || rangeExport) {
+		log.Fatalf("❌ --delta-field keeps its watermark in the checkpoint and can't be used with --no-checkpoint, --natural-order, --single-file, --min-id or --max-id")
+	}
 
-	fmt.Print("Enter collection name: ")
-	collectionName, _ := reader.ReadString('\n')
-	collectionName = strings.TrimSpace(collectionName)
+	if cfg.distinctField != "" {
+		if cfg.distinctOutput != "array" && cfg.distinctOutput != "lines" {
+			log.Fatalf("❌ Invalid --distinct-output %q, expected array or lines", cfg.distinctOutput)
+		}
+		if cfg.format != "json" || cfg.singleFile || cfg.compressEachBatch || cfg.deltaField != "" {
+			log.Fatalf("❌ --distinct writes its own JSON file and can't be used with --format, --single-file, compression or --delta-field")
+		}
+	}
 
+	if cfg.textSearch == "" && (cfg.textLanguage != "" || cfg.textScoreField != "" || cfg.textSortScore) {
+		log.Fatalf("❌ --text-language, --text-score-field and --text-sort-score require --text-search")
+	}
+	if cfg.textSearch != "" {
+		if cfg.naturalOrder || cfg.tail > 0 || cfg.sinceOplog || cfg.distinctField != "" {
+			log.Fatalf("❌ --text-search can't be used with --natural-order, whose sort a $text query doesn't allow, or with --tail-seconds, --since-oplog or --distinct")
+		}
+		if cfg.textScoreField != "" {
+			if err := validTextScoreField(cfg.textScoreField); err != nil {
+				log.Fatalf("❌ Invalid --text-score-field: %v", err)
+			}
+		}
+		if cfg.textSortScore {
+			if cfg.workers > 1 || cfg.descending || cfg.preserveOrder || cfg.deltaField != "" || cfg.idType != idTypeObjectID {
+				log.Fatalf("❌ --text-sort-score exports in a single pass by score instead of by _id, so it can't be used with --workers above 1, --sort-order=desc, --preserve-order, --delta-field or --id-type=uuid")
+			}
+			cfg.naturalOrder = true
+		}
+	}
+
+	if *arraySlices != "" {
+		if cfg.tail > 0 || cfg.sinceOplog || cfg.distinctField != "" {
+			log.Fatalf("❌ --array-slice projects queried documents, so it can't be used with --tail-seconds, --since-oplog or --distinct")
+		}
+		slices, err := parseArraySlices(*arraySlices)
+		if err != nil {
+			log.Fatalf("❌ Invalid --array-slice: %v", err)
+		}
+		for _, slice := range slices {
+			if cfg.textScoreField != "" && pathsCollide(slice.field, cfg.textScoreField) {
+				log.Fatalf("❌ --array-slice on %s overlaps --text-score-field", slice.field)
+			}
+		}
+		cfg.arraySlices = slices
+	}
+
+	// Natural-order exports have no _id ordering to resume from, a single
+	// output file or stream is rewritten from scratch on every run, and range
+	// exports mustn't clobber the checkpoint of the full export
+	if cfg.naturalOrder || cfg.singleFile || cfg.outputStdout || rangeExport {
+		cfg.noCheckpoint = true
+	}
+	if cfg.resumeWindow < 0 {
+		log.Fatalf("❌ --resume-safety-window must be 0 (off) or more")
+	}
+	if cfg.resumeWindow > 0 && cfg.noCheckpoint {
+		log.Fatalf("❌ --resume-safety-window rewinds the checkpoint, which this export doesn't keep")
+	}
+	if cfg.confirm && cfg.distinctField != "" {
+		log.Fatalf("❌ --interactive-confirm summarizes a document export and can't be used with --distinct")
+	}
+	if cfg.hashedScan && (cfg.naturalOrder || cfg.descending || cfg.preserveOrder || cfg.deltaField != "" || cfg.resumeWindow > 0 || cfg.distinctField != "") {
+		log.Fatalf("❌ --hashed-shard-scan reads in shard key order and can't be used with --natural-order, --text-sort-score, --sort-order=desc, --preserve-order, --delta-field, --resume-safety-window or --distinct")
+	}
+	switch cfg.idType {
+	case idTypeObjectID:
+	case idTypeUUID:
+		if cfg.workers != 1 || cfg.resumeWindow > 0 || *minIDHex != "" || *maxIDHex != "" {
+			log.Fatalf("❌ --id-type=uuid runs a single worker and can't be used with --workers other than 1, --resume-safety-window, --min-id or --max-id, which split or move the range by ObjectID")
+		}
+	default:
+		log.Fatalf("❌ Invalid --id-type %q, expected objectid or uuid", cfg.idType)
+	}
+	return cfg
+}
+
+// Register the flags that control how to connect, shared by all subcommands
+func addConnectionFlags(fs *flag.FlagSet, cfg *config) {
+	fs.StringVar(&cfg.readPreference, "read-preference", "", "read preference mode: primary, primaryPreferred, secondary, secondaryPreferred or nearest")
+	fs.DurationVar(&cfg.maxStaleness, "max-staleness", 0, "skip secondaries lagging more than this behind the primary (min 90s, needs a non-primary --read-preference)")
+	fs.StringVar(&cfg.readTags, "read-tags", "", "only read from members with these replica set tags, e.g. region:us-east,disk:ssd; separate fallback tag sets with ';', a trailing ';' allows any member (needs a non-primary --read-preference)")
+	fs.StringVar(&cfg.apiVersion, "api-version", "", "pin the MongoDB Stable API version (only \"1\" exists today)")
+	fs.BoolVar(&cfg.apiStrict, "api-strict", false, "with --api-version, make the server reject commands that aren't part of the Stable API")
+	fs.StringVar(&cfg.credentialsFile, "credentials-file", "", "JSON file with the connection string and/or username and password, instead of typing them in (should be chmod 600)")
+	fs.Uint64Var(&cfg.maxPoolSize, "max-pool-size", 0, "most connections the driver opens per server (0 for the driver default of 100); must be at least --workers")
+	fs.Uint64Var(&cfg.minPoolSize, "min-pool-size", 0, "connections per server the driver keeps open while idle, to avoid reconnecting between batches")
+	fs.StringVar(&cfg.replicaSet, "replica-set", "", "replica set name, overriding the one in the connection string or its SRV TXT record")
+	fs.StringVar(&cfg.tls, "tls", "", "true or false to override TLS from the connection string (mongodb+srv:// turns it on by default)")
+	fs.StringVar(&cfg.srvServiceName, "srv-service-name", "", "with a mongodb+srv:// connection string, look up this SRV service instead of mongodb")
+	fs.IntVar(&cfg.srvMaxHosts, "srv-max-hosts", 0, "with a mongodb+srv:// connection string, connect to at most this many of the hosts in the SRV record, picked at random (0 = all)")
+}
+
+// Parse --read-tags: tag sets separated by ';', each a comma-separated list
+// of name:value pairs. A member must carry every tag of a set to match, and
+// the sets are tried in order. An empty set, as left by a trailing ';',
+// matches any member, so it's only allowed last.
+func parseTagSets(s string) ([]tag.Set, error) {
+	var sets []tag.Set
+	parts := strings.Split(s, ";")
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			if i != len(parts)-1 || i == 0 {
+				return nil, fmt.Errorf("empty tag set, only a trailing ';' may add one to fall back to any member")
+			}
+			sets = append(sets, tag.Set{})
+			continue
+		}
+		var set tag.Set
+		seen := map[string]bool{}
+		for _, pair := range strings.Split(part, ",") {
+			name, value, ok := strings.Cut(pair, ":")
+			name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+			if !ok || name == "" || value == "" {
+				return nil, fmt.Errorf("%q isn't a name:value tag", strings.TrimSpace(pair))
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("tag %q is given twice in %q", name, part)
+			}
+			seen[name] = true
+			set = append(set, tag.Tag{Name: name, Value: value})
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// Human-readable max pool size, where 0 means the driver default
+func poolSizeString(n uint64) string {
+	if n == 0 {
+		return "driver default"
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// Validate the connection flags and load --credentials-file
+func validateConnectionFlags(cfg *config) error {
+	if cfg.maxStaleness > 0 {
+		if cfg.readPreference == "" || strings.EqualFold(cfg.readPreference, "primary") {
+			return fmt.Errorf("--max-staleness requires a non-primary --read-preference")
+		}
+		if cfg.maxStaleness < minMaxStaleness {
+			return fmt.Errorf("--max-staleness must be at least %s, got %s", minMaxStaleness, cfg.maxStaleness)
+		}
+	}
+	if cfg.readTags != "" {
+		if cfg.readPreference == "" || strings.EqualFold(cfg.readPreference, "primary") {
+			return fmt.Errorf("--read-tags requires a non-primary --read-preference")
+		}
+		tagSets, err := parseTagSets(cfg.readTags)
+		if err != nil {
+			return fmt.Errorf("invalid --read-tags: %w", err)
+		}
+		cfg.readTagSets = tagSets
+	}
+	if cfg.apiVersion != "" && cfg.apiVersion != string(options.ServerAPIVersion1) {
+		return fmt.Errorf("unsupported --api-version %q, the only Stable API version is %s", cfg.apiVersion, options.ServerAPIVersion1)
+	}
+	if cfg.apiStrict && cfg.apiVersion == "" {
+		return fmt.Errorf("--api-strict requires --api-version")
+	}
+	if cfg.maxPoolSize > 0 && cfg.minPoolSize > cfg.maxPoolSize {
+		return fmt.Errorf("--min-pool-size (%d) can't be larger than --max-pool-size (%d)", cfg.minPoolSize, cfg.maxPoolSize)
+	}
+	if cfg.tls != "" && cfg.tls != "true" && cfg.tls != "false" {
+		return fmt.Errorf("invalid --tls %q, expected true or false", cfg.tls)
+	}
+	if cfg.srvMaxHosts < 0 {
+		return fmt.Errorf("--srv-max-hosts must be 0 (all hosts) or more")
+	}
+	if cfg.srvMaxHosts > 0 && cfg.replicaSet != "" {
+		return fmt.Errorf("--srv-max-hosts can't be used with --replica-set, since a replica set is discovered from any one of its members")
+	}
+	if cfg.credentialsFile != "" {
+		creds, err := loadCredentials(cfg.credentialsFile)
+		if err != nil {
+			return err
+		}
+		cfg.credentials = creds
+	}
+	return nil
+}
+
+// Get user inputs safely
+func getUserInputs(cfg *config) (string, string, string) {
+	reader := bufio.NewReader(os.Stdin)
+	mongoURI := promptURI(cfg, reader)
+	dbName := promptLine(reader, "Enter database name: ")
+	collectionName := promptLine(reader, "Enter collection name (comma-separated for several, * for all): ")
 	return mongoURI, dbName, collectionName
 }
 
-// Setup logging to console and file
-func setupLogging() {
-	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+// Where prompts and messages for the user go. --output-stdout moves them to
+// stderr, together with a copy of the log, so stdout only carries documents.
+var console io.Writer = os.Stdout
+
+// Print a prompt and read one trimmed line of input
+func promptLine(reader *bufio.Reader, prompt string) string {
+	fmt.Fprint(console, prompt)
+	line, _ := reader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// Setup logging to the log file, rotated as cfg says, and unless
+// --log-to-stdout=false to the console too. Subcommands print their results
+// to stdout, so they only log to the file. If the file can't be opened, the
+// log goes to stderr only and the error is returned for the caller to decide
+// whether that's fatal.
+func setupLogging(cfg *config) error {
+	logFile, err := openRotatingLog(logFilePath, cfg.logMaxBytes, cfg.logKeep, cfg.logCompress)
 	if err != nil {
-		fmt.Printf("⚠️ Warning: Failed to create log file, using default stdout\n")
-		return
+		log.SetOutput(redactor.wrap(os.Stderr))
+		return fmt.Errorf("failed to open log file %s: %w", logFilePath, err)
+	}
+	if cfg.logStdout {
+		log.SetOutput(redactor.wrap(io.MultiWriter(console, logFile)))
+	} else {
+		log.SetOutput(redactor.wrap(logFile))
 	}
-	log.SetOutput(logFile)
 	log.Println("📜 Logging started...")
+	return nil
 }
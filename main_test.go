@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestLoadLastID(t *testing.T) {
+	valid := primitive.NewObjectID()
+
+	tests := []struct {
+		name     string
+		contents *string // nil leaves the file missing
+		want     docID
+	}{
+		{name: "missing file", contents: nil, want: docID{}},
+		{name: "empty file", contents: ptr(""), want: docID{}},
+		{name: "whitespace only", contents: ptr(" \n\t \n"), want: docID{}},
+		{name: "invalid hex", contents: ptr("not-an-objectid"), want: docID{}},
+		{name: "valid ObjectID", contents: ptr(valid.Hex()), want: objectDocID(valid)},
+		{name: "valid ObjectID with trailing whitespace", contents: ptr(valid.Hex() + " \n"), want: objectDocID(valid)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config{checkpointDir: t.TempDir(), idType: idTypeObjectID}
+			if tt.contents != nil {
+				if err := os.WriteFile(filepath.Join(cfg.checkpointDir, lastIDFile), []byte(*tt.contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := loadLastID(cfg); got != tt.want {
+				t.Errorf("loadLastID() = %s, want %s", idString(got, "start"), idString(tt.want, "start"))
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+// A document whose _id is readable but whose string field is missing its
+// terminating null byte, so it can't be decoded
+func corruptDocument(t *testing.T, id primitive.ObjectID) bson.Raw {
+	t.Helper()
+	raw, err := bson.Marshal(bson.D{{Key: "_id", Value: id}, {Key: "s", Value: "hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[bytes.Index(raw, []byte("hello"))+len("hello")] = 'x'
+	return raw
+}
+
+func TestReadBatchDecodeFailureMidBatch(t *testing.T) {
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	newCursor := func(t *testing.T) *mongo.Cursor {
+		t.Helper()
+		docs := []interface{}{
+			bson.D{{Key: "_id", Value: ids[0]}, {Key: "n", Value: 1}},
+			corruptDocument(t, ids[1]),
+			bson.D{{Key: "_id", Value: ids[2]}, {Key: "n", Value: 3}},
+		}
+		cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cursor
+	}
+
+	t.Run("aborts without --skip-errors", func(t *testing.T) {
+		cfg := &config{idType: idTypeObjectID, format: "json"}
+		b, err := readBatch(context.Background(), 1, 7, 10, cfg, newCursor(t))
+		if err == nil {
+			t.Fatal("readBatch() succeeded, want a decode error")
+		}
+		if !strings.Contains(err.Error(), "document #2 in batch 7") || !strings.Contains(err.Error(), "failed to decode") {
+			t.Errorf("readBatch() error = %q, want it to name document #2 in batch 7 and the decode stage", err)
+		}
+		// No batch comes back, so the caller has no _id to checkpoint past
+		// the failed document
+		if b != nil {
+			t.Errorf("readBatch() returned a batch of %d documents, want none", b.count())
+		}
+	})
+
+	t.Run("skips with --skip-errors", func(t *testing.T) {
+		dir := t.TempDir()
+		cfg := &config{idType: idTypeObjectID, format: "json", skipErrors: true, errorLog: newErrorLog(filepath.Join(dir, errorsFile))}
+		b, err := readBatch(context.Background(), 1, 7, 10, cfg, newCursor(t))
+		cfg.errorLog.close()
+		if err != nil {
+			t.Fatalf("readBatch() error = %v", err)
+		}
+		if b.scanned != 3 || b.count() != 2 {
+			t.Fatalf("readBatch() scanned %d and kept %d documents, want 3 and 2", b.scanned, b.count())
+		}
+		for i, want := range []primitive.ObjectID{ids[0], ids[2]} {
+			if got := b.docs[i]["_id"]; got != want {
+				t.Errorf("document %d has _id %v, want %v", i, got, want)
+			}
+		}
+		if b.lastID != objectDocID(ids[2]) {
+			t.Errorf("batch lastID = %s, want %s", b.lastID, ids[2].Hex())
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, errorsFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entry skippedDocument
+		if err := json.Unmarshal(bytes.TrimSpace(data), &entry); err != nil {
+			t.Fatalf("%s isn't a single JSON line: %v", errorsFile, err)
+		}
+		if entry.Batch != 7 || entry.Position != 2 || entry.Stage != "decode" || !strings.Contains(entry.ID, ids[1].Hex()) {
+			t.Errorf("%s entry = %+v, want batch 7, position 2, stage decode and _id %s", errorsFile, entry, ids[1].Hex())
+		}
+	})
+}
+
+func TestSaveLastIDInterruptedWrite(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{checkpointDir: dir, idType: idTypeObjectID}
+	old := objectDocID(primitive.NewObjectID())
+	saveLastID(cfg, old)
+
+	// A crash mid-write leaves a truncated temp file next to the checkpoint,
+	// which must not be mistaken for it
+	next := objectDocID(primitive.NewObjectID())
+	partial := filepath.Join(dir, lastIDFile+".123.tmp")
+	if err := os.WriteFile(partial, []byte(next.String()[:10]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadLastID(cfg); got != old {
+		t.Errorf("loadLastID() after an interrupted write = %s, want %s", got, old)
+	}
+
+	saveLastID(cfg, next)
+	if got := loadLastID(cfg); got != next {
+		t.Errorf("loadLastID() = %s, want %s", got, next)
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, lastIDFile+".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0] != partial {
+		t.Errorf("temp files after saveLastID = %v, want only the stale %s", matches, filepath.Base(partial))
+	}
+}
+
+func TestBatchAddIDKeyCollision(t *testing.T) {
+	id := primitive.NewObjectID()
+	raw, err := bson.Marshal(bson.D{{Key: "_id", Value: id}, {Key: "id", Value: "taken"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"json", "ejson"} {
+		t.Run(format, func(t *testing.T) {
+			cfg := &config{idType: idTypeObjectID, format: format, idKey: "id"}
+			err := (&batch{}).add(1, 4, cfg, raw)
+			if err == nil || !strings.Contains(err.Error(), id.Hex()) || !strings.Contains(err.Error(), "failed to transform") {
+				t.Errorf("add() error = %v, want a transform failure naming _id %s", err, id.Hex())
+			}
+
+			dir := t.TempDir()
+			cfg.skipErrors = true
+			cfg.errorLog = newErrorLog(filepath.Join(dir, errorsFile))
+			b := &batch{}
+			err = b.add(1, 4, cfg, raw)
+			cfg.errorLog.close()
+			if err != nil {
+				t.Fatalf("add() with --skip-errors error = %v", err)
+			}
+			if b.count() != 0 || b.lastID != objectDocID(id) {
+				t.Errorf("batch kept %d documents with lastID %s, want none and %s", b.count(), b.lastID, id.Hex())
+			}
+			data, err := os.ReadFile(filepath.Join(dir, errorsFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var entry skippedDocument
+			if err := json.Unmarshal(bytes.TrimSpace(data), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry.Stage != "transform" || !strings.Contains(entry.Error, "already exists") {
+				t.Errorf("%s entry = %+v, want the transform stage and the collision", errorsFile, entry)
+			}
+		})
+	}
+}
+
+func TestExportCollectionEmpty(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	for _, singleFile := range []bool{false, true} {
+		name := "batch files"
+		if singleFile {
+			name = "single file"
+		}
+		mt.Run(name, func(mt *mtest.T) {
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "ns", Value: "db." + mt.Coll.Name()}, bson.E{Key: "count", Value: 0}, bson.E{Key: "size", Value: 0}),
+				mtest.CreateCursorResponse(0, "db.$cmd.listCollections", mtest.FirstBatch),
+				mtest.CreateCursorResponse(0, "db."+mt.Coll.Name(), mtest.FirstBatch),
+			)
+			dir := mt.TempDir()
+			cfg := &config{checkpointDir: dir, idType: idTypeObjectID, format: "json", workers: 1, singleFile: singleFile}
+			summary := newExportSummary()
+
+			if err := exportCollection(cfg, mt.Client, mt.DB, mt.Coll.Name(), dir, summary); err != nil {
+				mt.Fatalf("exportCollection() error = %v", err)
+			}
+			if err := summary.err(); err != nil {
+				mt.Errorf("summary error = %v", err)
+			}
+			if summary.Documents != 0 || summary.Batches != 0 || summary.Skipped != 0 {
+				mt.Errorf("summary counts %d documents, %d batches and %d skipped, want 0", summary.Documents, summary.Batches, summary.Skipped)
+			}
+
+			batches, err := filepath.Glob(filepath.Join(dir, "worker_*", "batch_*"))
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if len(batches) > 0 {
+				mt.Errorf("batch files written: %v", batches)
+			}
+			// checkpoint.json only records the output format, not a position
+			if _, err := os.Stat(filepath.Join(dir, lastIDFile)); !os.IsNotExist(err) {
+				mt.Errorf("%s was saved, want no checkpoint", lastIDFile)
+			}
+			state, err := loadCheckpointState(cfg)
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if len(state.Partitions) > 0 || len(state.Hashed) > 0 || len(state.Deltas) > 0 || len(state.Oplog) > 0 {
+				mt.Errorf("%s holds a position: %+v", checkpointFile, state)
+			}
+			if singleFile {
+				data, err := os.ReadFile(filepath.Join(dir, "export.json"))
+				if err != nil {
+					mt.Fatal(err)
+				}
+				var docs []interface{}
+				if err := json.Unmarshal(data, &docs); err != nil || len(docs) != 0 {
+					mt.Errorf("export.json = %q, want an empty JSON array", data)
+				}
+			}
+		})
+	}
+}
+
+// An incremental run without --manifest, here a delta run whose checkpoint
+// was cleared by the previous run, mustn't overwrite that run's batch files
+func TestExportCollectionKeepsEarlierBatches(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("continues numbering", func(mt *mtest.T) {
+		ns := "db." + mt.Coll.Name()
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "ns", Value: ns}, bson.E{Key: "count", Value: 1}, bson.E{Key: "size", Value: 30}),
+			mtest.CreateCursorResponse(0, "db.$cmd.listCollections", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "n", Value: 1}}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+		)
+		dir := mt.TempDir()
+		workerDir := filepath.Join(dir, "worker_0")
+		if err := os.MkdirAll(workerDir, 0o755); err != nil {
+			mt.Fatal(err)
+		}
+		earlier := map[string]string{"batch_1.json": `[{"n": "first"}]`, "batch_2.json": `[{"n": "second"}]`}
+		for name, data := range earlier {
+			if err := os.WriteFile(filepath.Join(workerDir, name), []byte(data), 0o644); err != nil {
+				mt.Fatal(err)
+			}
+		}
+
+		cfg := &config{checkpointDir: dir, idType: idTypeObjectID, format: "json", workers: 1}
+		summary := newExportSummary()
+		if err := exportCollection(cfg, mt.Client, mt.DB, mt.Coll.Name(), dir, summary); err != nil {
+			mt.Fatalf("exportCollection() error = %v", err)
+		}
+
+		for name, want := range earlier {
+			data, err := os.ReadFile(filepath.Join(workerDir, name))
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if string(data) != want {
+				mt.Errorf("%s was overwritten with %s", name, data)
+			}
+		}
+		data, err := os.ReadFile(filepath.Join(workerDir, "batch_3.json"))
+		if err != nil {
+			mt.Fatalf("this run's batch wasn't written as batch_3.json: %v", err)
+		}
+		if !strings.Contains(string(data), id.Hex()) {
+			mt.Errorf("batch_3.json = %s, want the exported document", data)
+		}
+		if got := loadLastID(cfg); got != objectDocID(id) {
+			mt.Errorf("%s = %s, want %s", lastIDFile, got, id.Hex())
+		}
+	})
+}
+
+func TestFinishRunAbortedReportsOnce(t *testing.T) {
+	var posts atomic.Int32
+	var status string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		var body struct {
+			Status string `json:"status"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
+			status = body.Status
+		}
+	}))
+	defer server.Close()
+
+	// Queries cancelled by the abort come back as context.Canceled, which
+	// main reports as the abort
+	err := abortError(fmt.Errorf("batch query cancelled: %w", context.Canceled))
+	if !errors.Is(err, errAborted) {
+		t.Fatalf("abortError() = %v, want it to wrap errAborted", err)
+	}
+
+	cfg := &config{webhookURL: server.URL}
+	summary := newExportSummary()
+	if code := finishRun(cfg, summary, err); code != exitAborted {
+		t.Errorf("finishRun() = %d, want %d", code, exitAborted)
+	}
+	// A later call, like the abort watcher's after its grace period, neither
+	// reports again nor changes the outcome
+	if code := finishRun(cfg, summary, errors.New("export failed")); code != exitAborted {
+		t.Errorf("second finishRun() = %d, want %d", code, exitAborted)
+	}
+	if summary.Status != "aborted" {
+		t.Errorf("summary status = %q, want aborted", summary.Status)
+	}
+	if n := posts.Load(); n != 1 {
+		t.Errorf("webhook called %d times, want 1", n)
+	}
+	if status != "aborted" {
+		t.Errorf("webhook status = %q, want aborted", status)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Append-only record of every batch written to an export directory, one
+// JSON object per line. With --manifest it is also the authoritative resume
+// state: a checkpointed export resumes after the last _id it lists, even if
+// last_id.txt is gone or behind.
+const manifestFile = "manifest.jsonl"
+
+type manifestEntry struct {
+	Time      time.Time `json:"time"`
+	Worker    int       `json:"worker"`
+	Batch     int       `json:"batch,omitempty"`
+	Files     []string  `json:"files,omitempty"`              // Relative to the export directory
+	Checksums []string  `json:"checksums,omitempty"`          // Hex digest of each file, in the same order
+	Algorithm string    `json:"checksum_algorithm,omitempty"` // Hash the checksums were computed with
+	Documents int       `json:"documents"`
+	LastID    string    `json:"last_id,omitempty"`
+	Reset     bool      `json:"reset,omitempty"` // The position was cleared, as after a completed delta run
+}
+
+type exportManifest struct {
+	mu        sync.Mutex
+	dir       string
+	file      *os.File
+	algorithm string // Checksum each batch file with this, empty for no checksums
+}
+
+// Resume state rebuilt from a manifest. Only worker 0 is tracked, since
+// checkpointed exports run a single worker (tail batches use another ID).
+type manifestState struct {
+	entries   int
+	lastID    docID // Resume after this _id, zero to start at the beginning
+	nextBatch int   // Number of worker 0's next batch
+}
+
+// Open the manifest in dir for appending and replay it. Its name carries the
+// --sources prefix, so sources sharing the directory keep their own. A fresh
+// export (no checkpoint) truncates it, since its batch files are written
+// anew. With an algorithm, new entries carry a checksum of each file. Saved
+// _ids are read as the --id-type.
+func openManifest(dir, prefix string, fresh bool, algorithm, idType string) (*exportManifest, manifestState, error) {
+	state := manifestState{nextBatch: 1}
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if fresh {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(filepath.Join(dir, prefix+manifestFile), flags, 0o644)
+	if err != nil {
+		return nil, state, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var entry manifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return nil, state, fmt.Errorf("invalid %s line %d: %w", manifestFile, line, err)
+		}
+		if entry.Worker != 0 {
+			continue
+		}
+		state.entries++
+		if entry.Reset {
+			state.lastID = docID{}
+			continue
+		}
+		if entry.LastID != "" {
+			if state.lastID, err = parseDocID(entry.LastID, idType); err != nil {
+				file.Close()
+				return nil, state, fmt.Errorf("invalid last_id in %s line %d: %w", manifestFile, line, err)
+			}
+		}
+		if entry.Batch >= state.nextBatch {
+			state.nextBatch = entry.Batch + 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, state, fmt.Errorf("failed to read %s: %w", manifestFile, err)
+	}
+	return &exportManifest{dir: dir, file: file, algorithm: algorithm}, state, nil
+}
+
+// Append an entry. The file is synced so the manifest never lags the checkpoint.
+func (m *exportManifest) append(entry manifestEntry) error {
+	entry.Time = time.Now().UTC()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifestFile, err)
+	}
+	return m.file.Sync()
+}
+
+func (m *exportManifest) close() error {
+	return m.file.Close()
+}
+
+// Batch writer that records each written batch in the manifest before
+// returning, so the manifest entry lands before the checkpoint moves past it
+type manifestWriter struct {
+	batchWriter
+	manifest *exportManifest
+}
+
+func (w *manifestWriter) WriteBatch(workerID, batchNum int, b *batch) (string, error) {
+	paths, err := w.batchWriter.WriteBatch(workerID, batchNum, b)
+	if err != nil {
+		return paths, err
+	}
+	entry := manifestEntry{Worker: workerID, Batch: batchNum, Documents: b.count(), Algorithm: w.manifest.algorithm}
+	for _, path := range strings.Split(paths, ", ") {
+		if w.manifest.algorithm != "" {
+			sum, err := fileChecksum(w.manifest.algorithm, path)
+			if err != nil {
+				return paths, fmt.Errorf("failed to checksum %s: %w", path, err)
+			}
+			entry.Checksums = append(entry.Checksums, sum)
+		}
+		if rel, err := filepath.Rel(w.manifest.dir, path); err == nil {
+			path = rel
+		}
+		entry.Files = append(entry.Files, path)
+	}
+	if !b.lastID.IsZero() {
+		entry.LastID = b.lastID.String()
+	}
+	if err := w.manifest.append(entry); err != nil {
+		return paths, err
+	}
+	return paths, nil
+}
+
+// Record that the resume position was cleared, if a manifest is kept
+func resetManifest(cfg *config) error {
+	if cfg.manifest == nil {
+		return nil
+	}
+	return cfg.manifest.append(manifestEntry{Reset: true})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestResumeFromManifestWithoutCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{checkpointDir: dir, idType: idTypeObjectID, writeManifest: true}
+	newBatch := func() *batch {
+		b := &batch{}
+		for i := 0; i < 2; i++ {
+			id := primitive.NewObjectID()
+			b.docs = append(b.docs, bson.M{"_id": id})
+			b.lastID = objectDocID(id)
+		}
+		b.scanned = len(b.docs)
+		return b
+	}
+
+	// A first run writes three batches, checkpointing each one
+	manifest, resume, err := openManifest(dir, "", true, "", cfg.idType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &manifestWriter{batchWriter: &batchFileWriter{exportDir: dir, format: "json", idKey: "_id"}, manifest: manifest}
+	var last docID
+	for batchNum := resume.nextBatch; batchNum <= 3; batchNum++ {
+		b := newBatch()
+		if _, err := writer.WriteBatch(0, batchNum, b); err != nil {
+			t.Fatal(err)
+		}
+		last = b.lastID
+		saveLastID(cfg, last)
+	}
+	if err := saveCheckpointState(&checkpointState{path: checkpointPath(cfg, checkpointFile), Format: "json"}); err != nil {
+		t.Fatal(err)
+	}
+	manifest.close()
+
+	for _, name := range []string{lastIDFile, checkpointFile} {
+		if err := os.Remove(checkpointPath(cfg, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The next run finds its place from the manifest alone
+	manifest, resume, err = openManifest(dir, "", false, "", cfg.idType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manifest.close()
+	if got := resumeLastID(cfg, resume); got != last {
+		t.Errorf("resumeLastID() = %s, want %s", idString(got, "start"), last)
+	}
+	if resume.nextBatch != 4 {
+		t.Errorf("next batch = %d, want 4", resume.nextBatch)
+	}
+
+	// And numbers its batches after the ones already written
+	before, err := os.ReadFile(filepath.Join(dir, "worker_0", "batch_3.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer = &manifestWriter{batchWriter: &batchFileWriter{exportDir: dir, format: "json", idKey: "_id"}, manifest: manifest}
+	path, err := writer.WriteBatch(0, resume.nextBatch, newBatch())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "worker_0", "batch_4.json"); path != want {
+		t.Errorf("resumed batch written to %s, want %s", path, want)
+	}
+	after, err := os.ReadFile(filepath.Join(dir, "worker_0", "batch_3.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Error("resuming rewrote batch_3.json")
+	}
+}
+
+func TestResumeLastIDPrefersManifest(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{checkpointDir: dir, idType: idTypeObjectID}
+	behind := objectDocID(primitive.NewObjectID())
+	ahead := objectDocID(primitive.NewObjectID())
+	saveLastID(cfg, behind)
+
+	if got := resumeLastID(cfg, manifestState{nextBatch: 1}); got != behind {
+		t.Errorf("resumeLastID() without a manifest = %s, want %s", got, behind)
+	}
+	if got := resumeLastID(cfg, manifestState{entries: 2, lastID: ahead, nextBatch: 3}); got != ahead {
+		t.Errorf("resumeLastID() with a manifest = %s, want %s", got, ahead)
+	}
+}
+
+func TestManifestPerSource(t *testing.T) {
+	dir := t.TempDir()
+	write := func(prefix string, batches int) docID {
+		t.Helper()
+		manifest, _, err := openManifest(dir, prefix, true, "", idTypeObjectID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer manifest.close()
+		writer := &manifestWriter{batchWriter: &batchFileWriter{exportDir: dir, prefix: prefix, format: "json", idKey: "_id"}, manifest: manifest}
+		var last docID
+		for batchNum := 1; batchNum <= batches; batchNum++ {
+			id := primitive.NewObjectID()
+			b := &batch{docs: []bson.M{{"_id": id}}, lastID: objectDocID(id), scanned: 1}
+			if _, err := writer.WriteBatch(0, batchNum, b); err != nil {
+				t.Fatal(err)
+			}
+			last = b.lastID
+		}
+		return last
+	}
+
+	// A fresh second source doesn't wipe the first one's manifest
+	euLast := write("eu_", 3)
+	usLast := write("us_", 1)
+
+	for _, tt := range []struct {
+		prefix    string
+		last      docID
+		nextBatch int
+	}{
+		{prefix: "eu_", last: euLast, nextBatch: 4},
+		{prefix: "us_", last: usLast, nextBatch: 2},
+	} {
+		manifest, resume, err := openManifest(dir, tt.prefix, false, "", idTypeObjectID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.close()
+		if resume.lastID != tt.last || resume.nextBatch != tt.nextBatch {
+			t.Errorf("%s%s resumes after %s at batch %d, want %s at batch %d", tt.prefix, manifestFile, resume.lastID, resume.nextBatch, tt.last, tt.nextBatch)
+		}
+
+		// verify --source checks the source's files against its own manifest
+		v := &exportVerifier{dir: dir, prefix: tt.prefix, idField: "_id"}
+		v.run()
+		if len(v.problems) > 0 || len(v.warnings) > 0 {
+			t.Errorf("verify --source=%s: problems %v, warnings %v", strings.TrimSuffix(tt.prefix, "_"), v.problems, v.warnings)
+		}
+		if v.files != tt.nextBatch-1 {
+			t.Errorf("verify --source=%s checked %d files, want %d", strings.TrimSuffix(tt.prefix, "_"), v.files, tt.nextBatch-1)
+		}
+	}
+}
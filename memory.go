@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Adaptive batch sizing never shrinks batches below this
+const minBatchSize = 1000
+
+// Adjusts the batch size between batches to keep heap usage under a limit.
+// It halves the size whenever the heap is over the limit and doubles it
+// back towards batchSize once usage drops below half the limit. A nil
+// sizer always returns batchSize.
+type batchSizer struct {
+	mu      sync.Mutex
+	limit   uint64
+	current int
+}
+
+func newBatchSizer(limit int64) *batchSizer {
+	if limit <= 0 {
+		return nil
+	}
+	// Also make the GC work harder as the heap approaches the limit
+	debug.SetMemoryLimit(limit)
+	return &batchSizer{limit: uint64(limit), current: batchSize}
+}
+
+// Batch size to use for the next batch
+func (s *batchSizer) next() int {
+	if s == nil {
+		return batchSize
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case mem.HeapAlloc > s.limit && s.current > minBatchSize:
+		s.current = max(s.current/2, minBatchSize)
+		log.Printf("🧠 Heap at %s is over --mem-limit %s, reducing batch size to %d\n",
+			formatBytes(int64(mem.HeapAlloc)), formatBytes(int64(s.limit)), s.current)
+	case mem.HeapAlloc < s.limit/2 && s.current < batchSize:
+		s.current = min(s.current*2, batchSize)
+		log.Printf("🧠 Heap at %s, memory pressure eased, raising batch size to %d\n",
+			formatBytes(int64(mem.HeapAlloc)), s.current)
+	}
+	return s.current
+}
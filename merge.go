@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Single-file writer that keeps the output globally sorted by _id. Each
+// worker's range arrives in _id order, so batches are spooled to one raw BSON
+// file per worker and k-way merged into the final file on Close. This costs a
+// second copy of the data on disk and a second pass over it at the end.
+// Under --max-open-files spool files are closed between batches and reopened
+// in append mode, and the merge reopens them at their read position as needed.
+type orderedFileWriter struct {
+	mu         sync.Mutex
+	out        batchWriter // The single file, or a sequenceWriter in front of it
+	exportDir  string
+	prefix     string       // Prepended to spool file names
+	fieldOrder bool         // Decode merged documents into bson.D to keep their field order
+	idKey      string       // Field holding the _id in spooled documents
+	files      *fileLimiter // --max-open-files, nil for no limit
+	spools     map[int]*spoolFile
+}
+
+// Per-worker run of documents in _id order
+type spoolFile struct {
+	path string
+	file *os.File // Nil while closed between batches
+	buf  *bufio.Writer
+}
+
+func newOrderedFileWriter(out batchWriter, exportDir, prefix string, fieldOrder bool, idKey string, files *fileLimiter) *orderedFileWriter {
+	return &orderedFileWriter{out: out, exportDir: exportDir, prefix: prefix, fieldOrder: fieldOrder, idKey: idKey, files: files, spools: map[int]*spoolFile{}}
+}
+
+func (w *orderedFileWriter) WriteBatch(workerID, batchNum int, b *batch) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	spool := w.spools[workerID]
+	if spool == nil {
+		path := filepath.Join(w.exportDir, fmt.Sprintf(".%sworker_%d.spool.bson", w.prefix, workerID))
+		w.files.acquire()
+		file, err := os.Create(path)
+		if err != nil {
+			w.files.release()
+			return "", fmt.Errorf("failed to create spool file: %w", err)
+		}
+		spool = &spoolFile{path: path, file: file, buf: bufio.NewWriter(file)}
+		w.spools[workerID] = spool
+	} else if spool.file == nil {
+		w.files.acquire()
+		file, err := os.OpenFile(spool.path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			w.files.release()
+			return "", fmt.Errorf("failed to reopen spool file: %w", err)
+		}
+		spool.file = file
+		spool.buf.Reset(file)
+	}
+
+	docs := make([]interface{}, 0, b.count())
+	for _, doc := range b.docs {
+		docs = append(docs, doc)
+	}
+	for _, doc := range b.ordered {
+		docs = append(docs, doc)
+	}
+	for _, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return "", fmt.Errorf("failed to spool document: %w", err)
+		}
+		if _, err := spool.buf.Write(data); err != nil {
+			return "", fmt.Errorf("failed to spool document: %w", err)
+		}
+	}
+	if w.files != nil {
+		if err := spool.close(w.files); err != nil {
+			return "", err
+		}
+	}
+	return spool.path, nil
+}
+
+// Flush and close the spool file and give back its slot
+func (s *spoolFile) close(files *fileLimiter) error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.buf.Flush()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	files.release()
+	if err != nil {
+		return fmt.Errorf("failed to flush spool file: %w", err)
+	}
+	return nil
+}
+
+// Merge the spool files into the output in _id order and remove them
+func (w *orderedFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var cursors spoolHeap
+	defer func() {
+		for _, spool := range w.spools {
+			spool.close(w.files)
+			os.Remove(spool.path)
+		}
+		for _, cursor := range cursors {
+			cursor.close()
+		}
+	}()
+
+	for _, spool := range w.spools {
+		if err := spool.close(w.files); err != nil {
+			return err
+		}
+		cursor := &spoolCursor{path: spool.path, idKey: w.idKey, cursors: &cursors, files: w.files}
+		ok, err := cursor.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			cursors = append(cursors, cursor)
+		} else {
+			cursor.close()
+		}
+	}
+	heap.Init(&cursors)
+
+	for cursors.Len() > 0 {
+		cursor := cursors[0]
+		b, err := w.decodeSpooled(cursor.raw)
+		if err != nil {
+			return err
+		}
+		if _, err := w.out.WriteBatch(0, 0, b); err != nil {
+			return err
+		}
+		ok, err := cursor.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&cursors, 0)
+		} else {
+			heap.Pop(&cursors).(*spoolCursor).close()
+		}
+	}
+	return w.out.Close()
+}
+
+// Decode a spooled document into a one-document batch for the output
+func (w *orderedFileWriter) decodeSpooled(raw bson.Raw) (*batch, error) {
+	if w.fieldOrder {
+		var doc bson.D
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("failed to decode spooled document: %w", err)
+		}
+		return &batch{ordered: []bson.D{doc}}, nil
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode spooled document: %w", err)
+	}
+	return &batch{docs: []bson.M{doc}}, nil
+}
+
+// Reads one spool file back a document at a time. Under --max-open-files a
+// cursor that finds no free slot closes another one of the merge, which
+// reopens its file at the same offset when it's read next.
+type spoolCursor struct {
+	path    string
+	file    *os.File // Nil while closed
+	reader  *bufio.Reader
+	offset  int64 // Bytes of the spool file read so far
+	idKey   string
+	raw     bson.Raw
+	id      docID
+	cursors *spoolHeap // The merge's other cursors, to close one for a slot
+	files   *fileLimiter
+}
+
+func (c *spoolCursor) next() (bool, error) {
+	if err := c.open(); err != nil {
+		return false, err
+	}
+	raw, err := bson.NewFromIOReader(c.reader)
+	if errors.Is(err, io.EOF) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read spool file: %w", err)
+	}
+	c.offset += int64(len(raw))
+	id, ok := rawDocID(raw.Lookup(c.idKey))
+	if !ok {
+		return false, fmt.Errorf("spooled document has no ObjectID or UUID _id")
+	}
+	c.raw, c.id = raw, id
+	return true, nil
+}
+
+func (c *spoolCursor) open() error {
+	if c.file != nil {
+		return nil
+	}
+	if !c.files.tryAcquire() {
+		// Close the open cursor furthest down the heap, which is likely
+		// read last, or wait for a slot if the merge has none open
+		for i := len(*c.cursors) - 1; i >= 0; i-- {
+			if other := (*c.cursors)[i]; other != c && other.file != nil {
+				other.close()
+				break
+			}
+		}
+		c.files.acquire()
+	}
+	file, err := os.Open(c.path)
+	if err != nil {
+		c.files.release()
+		return fmt.Errorf("failed to open spool file: %w", err)
+	}
+	if _, err := file.Seek(c.offset, io.SeekStart); err != nil {
+		file.Close()
+		c.files.release()
+		return fmt.Errorf("failed to seek spool file: %w", err)
+	}
+	c.file, c.reader = file, bufio.NewReader(file)
+	return nil
+}
+
+func (c *spoolCursor) close() {
+	if c.file != nil {
+		c.file.Close()
+		c.file, c.reader = nil, nil
+		c.files.release()
+	}
+}
+
+// Min-heap of spool cursors ordered by their current document's _id
+type spoolHeap []*spoolCursor
+
+func (h spoolHeap) Len() int            { return len(h) }
+func (h spoolHeap) Less(i, j int) bool  { return h[i].id.compare(h[j].id) < 0 }
+func (h spoolHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *spoolHeap) Push(x interface{}) { *h = append(*h, x.(*spoolCursor)) }
+func (h *spoolHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
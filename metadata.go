@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Written by --with-metadata next to a collection's batch files
+const metadataFile = "metadata.json"
+
+// Definition of an exported collection, enough to recreate an equivalent
+// empty one before loading the documents. Options and index specs are
+// canonical extended JSON, so they keep their exact BSON types and can be
+// passed as they are to createCollection and createIndexes.
+type collectionMetadata struct {
+	Database   string            `json:"database"`
+	Collection string            `json:"collection"`
+	Type       string            `json:"type"`                // "collection", "view" or "timeseries"
+	Options    json.RawMessage   `json:"options"`             // createCollection options: collation, validator, capped, timeseries, viewOn...
+	Collation  json.RawMessage   `json:"collation,omitempty"` // Default collation, also in options
+	Validator  json.RawMessage   `json:"validator,omitempty"` // Validator, also in options
+	Indexes    []json.RawMessage `json:"indexes,omitempty"`   // listIndexes output, _id index included
+	ExportedAt time.Time         `json:"exported_at"`
+}
+
+// Fetch the collection's options and indexes and write them to metadata.json
+// in dir. Runs before any document is read, so the file exists even for an
+// export that fails partway.
+func writeMetadata(cfg *config, db *mongo.Database, name, dir string) error {
+	specs, err := db.ListCollectionSpecifications(context.TODO(), bson.M{"name": name})
+	if err != nil {
+		return fmt.Errorf("failed to list collection options: %w", err)
+	}
+	if len(specs) == 0 {
+		return fmt.Errorf("collection %s not found", name)
+	}
+	spec := specs[0]
+
+	meta := collectionMetadata{Database: db.Name(), Collection: name, Type: spec.Type, ExportedAt: time.Now().UTC()}
+	options := spec.Options
+	if options == nil {
+		options = bson.Raw{5, 0, 0, 0, 0} // Empty document
+	}
+	if meta.Options, err = canonicalJSON(options); err != nil {
+		return err
+	}
+	for field, dest := range map[string]*json.RawMessage{"collation": &meta.Collation, "validator": &meta.Validator} {
+		if value, ok := options.Lookup(field).DocumentOK(); ok {
+			if *dest, err = canonicalJSON(value); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Views have no indexes of their own
+	if spec.Type != "view" {
+		cursor, err := db.Collection(name).Indexes().List(context.TODO())
+		if err != nil {
+			return fmt.Errorf("failed to list indexes: %w", err)
+		}
+		defer cursor.Close(context.TODO())
+		for cursor.Next(context.TODO()) {
+			index, err := canonicalJSON(cursor.Current)
+			if err != nil {
+				return err
+			}
+			meta.Indexes = append(meta.Indexes, index)
+		}
+		if err := cursor.Err(); err != nil {
+			return fmt.Errorf("failed to list indexes: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, cfg.filePrefix+metadataFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	log.Printf("🗂️ Wrote %s with %d indexes\n", path, len(meta.Indexes))
+	return nil
+}
+
+// Canonical extended JSON of a BSON document
+func canonicalJSON(doc bson.Raw) (json.RawMessage, error) {
+	data, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return data, nil
+}
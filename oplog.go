@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Written by --since-oplog replays, one line per deleted document
+const deletedFile = "deleted.jsonl"
+
+// Server error code of a change stream whose start has left the oplog
+const changeStreamHistoryLost = 286
+
+// Position of a --since-oplog export in the oplog, kept per collection in
+// checkpoint.json. Cluster times are (seconds, ordinal) pairs, as in the
+// oplog's ts field.
+type oplogState struct {
+	Since *clusterTime    `json:"since,omitempty"` // Every change up to here is exported, nil before the first full export completes
+	Start *clusterTime    `json:"start,omitempty"` // Cluster time the full export in progress started at
+	Token json.RawMessage `json:"token,omitempty"` // Resume token of the last change exported by an unfinished replay, as extended JSON
+}
+
+type clusterTime struct {
+	T uint32 `json:"t"`
+	I uint32 `json:"i"`
+}
+
+func (c *clusterTime) timestamp() primitive.Timestamp {
+	return primitive.Timestamp{T: c.T, I: c.I}
+}
+
+// The cluster time just after this one, where a replay of the changes after
+// it starts
+func (c *clusterTime) next() primitive.Timestamp {
+	if c.I == math.MaxUint32 {
+		return primitive.Timestamp{T: c.T + 1}
+	}
+	return primitive.Timestamp{T: c.T, I: c.I + 1}
+}
+
+func (c *clusterTime) String() string {
+	return fmt.Sprintf("%s (%d, %d)", time.Unix(int64(c.T), 0).UTC().Format(time.RFC3339), c.T, c.I)
+}
+
+// Find where this run starts in the oplog. Returns the cluster time to
+// replay changes from, or nil if there is no completed full export yet, in
+// which case this run is one, and the current cluster time is saved first so
+// changes made while it runs are left for the next run.
+func prepareOplog(cfg *config, client *mongo.Client, state *checkpointState, key string) (*clusterTime, error) {
+	if cfg.noCheckpoint {
+		return nil, fmt.Errorf("--since-oplog keeps its position in the checkpoint, which is disabled for this collection")
+	}
+	if state.Oplog == nil {
+		state.Oplog = map[string]*oplogState{}
+	}
+	oplog := state.Oplog[key]
+	if oplog == nil {
+		oplog = &oplogState{}
+		state.Oplog[key] = oplog
+	}
+	if oplog.Since != nil {
+		return oplog.Since, nil
+	}
+
+	if oplog.Start == nil {
+		now, err := currentClusterTime(client)
+		if err != nil {
+			return nil, err
+		}
+		oplog.Start = now
+		if err := saveCheckpointState(state); err != nil {
+			return nil, fmt.Errorf("failed to save %s: %w", checkpointFile, err)
+		}
+	}
+	log.Printf("🕰️ No oplog position saved yet, exporting every document. The next --since-oplog run replays changes from %s.\n", oplog.Start)
+	return nil, nil
+}
+
+// Make the cluster time the full export started at the replay position once
+// it has completed, and clear its _id checkpoint
+func completeOplogBaseline(cfg *config, state *checkpointState, key string) error {
+	oplog := state.Oplog[key]
+	if oplog == nil || oplog.Start == nil {
+		return nil
+	}
+	oplog.Since, oplog.Start = oplog.Start, nil
+	delete(state.Partitions, key)
+	if err := saveCheckpointState(state); err != nil {
+		return err
+	}
+	if err := resetManifest(cfg); err != nil {
+		return err
+	}
+	if err := os.Remove(checkpointPath(cfg, lastIDFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	log.Printf("🕰️ Oplog position saved: %s\n", oplog.Since)
+	return nil
+}
+
+// Cluster time of the deployment right now, as the operationTime of a ping
+func currentClusterTime(client *mongo.Client) (*clusterTime, error) {
+	session, err := client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(context.TODO())
+	err = mongo.WithSession(context.TODO(), session, func(ctx mongo.SessionContext) error {
+		return client.Database("admin").RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cluster time: %w", err)
+	}
+	ts := session.OperationTime()
+	if ts == nil {
+		return nil, fmt.Errorf("the server reported no cluster time, --since-oplog needs a replica set or sharded cluster")
+	}
+	return &clusterTime{T: ts.T, I: ts.I}, nil
+}
+
+// Export every change after the saved cluster time instead of scanning by
+// _id: the current version of inserted, updated and replaced documents as
+// batches, and the _ids of deleted ones in deleted.jsonl. Reads the change
+// stream up to the cluster time at the start of the run, saving the position
+// after every batch, so an interrupted replay continues where it stopped.
+// Changes in one transaction share a cluster time, so an interrupted replay
+// resumes after the token of the last change it exported rather than after
+// its cluster time, which would skip the rest of the transaction.
+func replayOplog(cfg *config, client *mongo.Client, collection *mongo.Collection, writer batchWriter, summary *exportSummary, state *checkpointState, key, dir string, batchNum int) error {
+	oplog := state.Oplog[key]
+	end, err := currentClusterTime(client)
+	if err != nil {
+		return err
+	}
+	log.Printf("🕰️ Replaying changes from %s to %s...\n", oplog.Since, end)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}}},
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if oplog.Token != nil {
+		var resume bson.D
+		if err := bson.UnmarshalExtJSON(oplog.Token, true, &resume); err != nil {
+			return fmt.Errorf("invalid oplog resume token in %s: %w", checkpointFile, err)
+		}
+		opts.SetStartAfter(resume)
+	} else {
+		start := oplog.Since.next()
+		opts.SetStartAtOperationTime(&start)
+	}
+	stream, err := collection.Watch(rootCtx, pipeline, opts)
+	if err != nil {
+		var serverErr mongo.ServerError
+		if errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamHistoryLost) {
+			return fmt.Errorf("the oplog no longer reaches back to %s, so changes since the last run are lost. Remove %q from \"oplog\" in %s to export everything again", oplog.Since, key, checkpointFile)
+		}
+		return fmt.Errorf("failed to open change stream (needs a replica set or sharded cluster): %w", err)
+	}
+	defer stream.Close(context.TODO())
+
+	deletes := &deleteLog{path: filepath.Join(dir, cfg.filePrefix+deletedFile)}
+	defer deletes.close()
+
+	size := cfg.sizer.next()
+	b := &batch{}
+	var last *clusterTime
+	var lastToken bson.Raw       // Resume token of the change at last, nil once the replay is complete
+	var idle primitive.Timestamp // Resume point of the last empty batch
+	flush := func() error {
+		if err := cfg.plugin.apply(0, batchNum, b); err != nil {
+			return err
+		}
+		// Change events aren't in _id order, so the manifest mustn't resume from them
+		b.lastID = docID{}
+		if b.count() > 0 {
+			filePath, err := writer.WriteBatch(0, batchNum, b)
+			if err != nil {
+				return err
+			}
+			log.Printf("✅ Oplog: Exported batch %d (%d changed records) -> %s\n", batchNum, b.count(), filePath)
+			batchNum++
+		}
+		summary.addBatch(b.count(), b.scanned-b.count(), 0)
+		cfg.progress.addBatch(b.count(), b.lastID)
+		cfg.heartbeat.addBatch(b.count(), b.lastID)
+		b, size = &batch{}, cfg.sizer.next()
+		if err := deletes.sync(); err != nil {
+			return err
+		}
+		if last != nil {
+			oplog.Since, oplog.Token = last, nil
+			if lastToken != nil {
+				token, err := bson.MarshalExtJSON(lastToken, true, false)
+				if err != nil {
+					return fmt.Errorf("failed to encode resume token: %w", err)
+				}
+				oplog.Token = token
+			}
+			if err := saveCheckpointState(state); err != nil {
+				return fmt.Errorf("failed to save checkpoint: %w", err)
+			}
+		}
+		return nil
+	}
+
+	for {
+		if !stream.TryNext(rootCtx) {
+			if err := stream.Err(); err != nil {
+				return fmt.Errorf("change stream failed: %w", err)
+			}
+			// An empty batch only means the server stopped scanning for now,
+			// unless its resume point has reached the end. Servers before 4.2
+			// don't advance the resume point past the last event.
+			ts, ok := resumeTokenTime(stream.ResumeToken())
+			if !ok || !ts.Before(end.timestamp()) || ts.Equal(idle) {
+				break
+			}
+			idle = ts
+			continue
+		}
+		var event struct {
+			ID            bson.Raw            `bson:"_id"`
+			OperationType string              `bson:"operationType"`
+			ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+			DocumentKey   bson.Raw            `bson:"documentKey"`
+			FullDocument  bson.RawValue       `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			return fmt.Errorf("failed to decode change event: %w", err)
+		}
+		if end.timestamp().Before(event.ClusterTime) {
+			break
+		}
+		last, lastToken = &clusterTime{T: event.ClusterTime.T, I: event.ClusterTime.I}, append(bson.Raw(nil), event.ID...)
+
+		switch {
+		case event.OperationType == "delete":
+			if err := deletes.record(event.ClusterTime, event.DocumentKey); err != nil {
+				return err
+			}
+		case event.FullDocument.Type != bsontype.EmbeddedDocument:
+			// The document was deleted after this update, and its delete
+			// event follows
+		default:
+			if err := b.add(0, batchNum, cfg, event.FullDocument.Document()); err != nil {
+				return err
+			}
+		}
+		if b.scanned >= size {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	last, lastToken = end, nil
+	if err := flush(); err != nil {
+		return err
+	}
+	if deletes.count > 0 {
+		log.Printf("🗑️ Oplog: %d documents were deleted, their _ids are in %s\n", deletes.count, deletes.path)
+	}
+	log.Printf("🕰️ Oplog position saved: %s\n", oplog.Since)
+	return nil
+}
+
+// Cluster time a change stream resume token points at. The token's _data
+// starts with a type byte and the cluster time as a big-endian timestamp.
+func resumeTokenTime(token bson.Raw) (primitive.Timestamp, bool) {
+	data, ok := token.Lookup("_data").StringValueOK()
+	if !ok {
+		return primitive.Timestamp{}, false
+	}
+	raw, err := hex.DecodeString(data)
+	if err != nil || len(raw) < 9 || raw[0] != 130 {
+		return primitive.Timestamp{}, false
+	}
+	return primitive.Timestamp{T: binary.BigEndian.Uint32(raw[1:5]), I: binary.BigEndian.Uint32(raw[5:9])}, true
+}
+
+// Append-only list of the documents a replay saw deleted, opened on the
+// first delete
+type deleteLog struct {
+	path  string
+	file  *os.File
+	count int
+}
+
+type deletedDocument struct {
+	Time        time.Time       `json:"time"`         // When the document was deleted
+	ClusterTime clusterTime     `json:"cluster_time"` // Oplog position of the delete
+	DocumentKey json.RawMessage `json:"document_key"` // _id, plus the shard key on sharded collections, as relaxed extended JSON
+}
+
+func (l *deleteLog) record(ts primitive.Timestamp, key bson.Raw) error {
+	if l.file == nil {
+		file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", l.path, err)
+		}
+		l.file = file
+	}
+	documentKey, err := bson.MarshalExtJSON(key, false, false)
+	if err != nil {
+		return err
+	}
+	entry := deletedDocument{
+		Time:        time.Unix(int64(ts.T), 0).UTC(),
+		ClusterTime: clusterTime{T: ts.T, I: ts.I},
+		DocumentKey: documentKey,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write %s: %w", l.path, err)
+	}
+	l.count++
+	return nil
+}
+
+// Make the deletes recorded so far durable before the checkpoint moves past them
+func (l *deleteLog) sync() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Sync()
+}
+
+func (l *deleteLog) close() {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
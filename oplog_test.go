@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Fails every batch after the first, like a run interrupted mid-replay
+type failingWriter struct{ written int }
+
+func (w *failingWriter) WriteBatch(workerID, batchNum int, b *batch) (string, error) {
+	if w.written++; w.written > 1 {
+		return "", errors.New("disk full")
+	}
+	return "batch", nil
+}
+
+func (w *failingWriter) Close() error { return nil }
+
+func TestReplayOplogResumesAfterSavedPosition(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	end := primitive.Timestamp{T: 200}
+	ping := func() bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "operationTime", Value: end})
+	}
+	// The $changeStream stage of the replay's aggregate
+	changeStream := func(mt *mtest.T) bson.Raw {
+		mt.Helper()
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "aggregate" {
+				return event.Command.Lookup("pipeline").Array().Index(0).Value().Document().Lookup("$changeStream").Document()
+			}
+		}
+		mt.Fatal("no aggregate was sent")
+		return nil
+	}
+	event := func(token string, ts primitive.Timestamp) bson.D {
+		return bson.D{
+			{Key: "_id", Value: bson.D{{Key: "_data", Value: token}}},
+			{Key: "operationType", Value: "insert"},
+			{Key: "clusterTime", Value: ts},
+			{Key: "documentKey", Value: bson.D{{Key: "_id", Value: primitive.NewObjectID()}}},
+			{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: primitive.NewObjectID()}}},
+		}
+	}
+
+	mt.Run("after a completed run", func(mt *mtest.T) {
+		ns := "db." + mt.Coll.Name()
+		mt.AddMockResponses(ping(), mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+		dir := mt.TempDir()
+		state := &checkpointState{path: filepath.Join(dir, checkpointFile), Oplog: map[string]*oplogState{ns: {Since: &clusterTime{T: 100, I: 5}}}}
+		cfg := &config{checkpointDir: dir, idType: idTypeObjectID}
+		if err := replayOplog(cfg, mt.Client, mt.Coll, &failingWriter{}, newExportSummary(), state, ns, dir, 1); err != nil {
+			mt.Fatalf("replayOplog() error = %v", err)
+		}
+
+		// Changes at the saved cluster time were exported by the last run
+		stage := changeStream(mt)
+		if t, i, ok := stage.Lookup("startAtOperationTime").TimestampOK(); !ok || t != 100 || i != 6 {
+			mt.Errorf("$changeStream = %s, want startAtOperationTime (100, 6)", stage)
+		}
+		if oplog := state.Oplog[ns]; oplog.Since.timestamp() != end || oplog.Token != nil {
+			mt.Errorf("saved position = %s, token %s, want the run's end and no token", oplog.Since, oplog.Token)
+		}
+	})
+
+	mt.Run("after an interrupted run", func(mt *mtest.T) {
+		ns := "db." + mt.Coll.Name()
+		// Two changes of one transaction, written in separate batches
+		commit := primitive.Timestamp{T: 150, I: 1}
+		mt.AddMockResponses(ping(), mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, event("first", commit), event("second", commit)))
+		dir := mt.TempDir()
+		state := &checkpointState{path: filepath.Join(dir, checkpointFile), Oplog: map[string]*oplogState{ns: {Since: &clusterTime{T: 100, I: 5}}}}
+		cfg := &config{checkpointDir: dir, idType: idTypeObjectID, sizer: &batchSizer{limit: 1, current: 1}}
+		if err := replayOplog(cfg, mt.Client, mt.Coll, &failingWriter{}, newExportSummary(), state, ns, dir, 1); err == nil {
+			mt.Fatal("replayOplog() error = nil, want the second batch's write error")
+		}
+		oplog := state.Oplog[ns]
+		if oplog.Since.timestamp() != commit || oplog.Token == nil {
+			mt.Fatalf("saved position = %s, token %s, want the first change's", oplog.Since, oplog.Token)
+		}
+
+		// The next run resumes after the first change, not after its cluster
+		// time, which would skip the second
+		mt.ClearEvents()
+		mt.AddMockResponses(ping(), mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+		if err := replayOplog(cfg, mt.Client, mt.Coll, &failingWriter{}, newExportSummary(), state, ns, dir, 2); err != nil {
+			mt.Fatalf("resumed replayOplog() error = %v", err)
+		}
+		stage := changeStream(mt)
+		if token, ok := stage.Lookup("startAfter", "_data").StringValueOK(); !ok || token != "first" {
+			mt.Errorf("$changeStream = %s, want startAfter the first change's token", stage)
+		}
+		if _, err := stage.LookupErr("startAtOperationTime"); err == nil {
+			mt.Errorf("$changeStream = %s, want no startAtOperationTime alongside startAfter", stage)
+		}
+	})
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"runtime"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// --workers=auto never starts more workers than this, to avoid overwhelming the cluster
+const maxAutoWorkers = 16
+
+// One worker's share of the export
+type workItem struct {
+	lastID docID  // Resume after this _id, zero to start at the beginning
+	bounds bson.M // Extra _id bounds of this worker's partition, nil for the whole range
+
+	partition  int // Index of the partition, which is also the ID of the worker exporting it
+	firstBatch int // Number of the first batch written, 0 to start at 1
+
+	// --hashed-shard-scan: the shard key, and the chunks to scan by it
+	shardKey   bson.D
+	chunks     []hashedChunk
+	chunksDone int // Of chunks, how many an earlier run finished
+}
+
+// Number of workers to run, resolving --workers=auto and falling back to one
+// worker for exports that can't be split
+func resolveWorkers(cfg *config, client *mongo.Client) int {
+	n := cfg.workers
+	if n == 0 {
+		n = autoWorkers(client)
+		log.Printf("🧮 --workers=auto picked %d workers (%d CPUs)\n", n, runtime.NumCPU())
+		if cfg.maxPoolSize > 0 && uint64(n) > cfg.maxPoolSize {
+			n = int(cfg.maxPoolSize)
+			log.Printf("🧮 Capping --workers=auto at --max-pool-size (%d)\n", n)
+		}
+	}
+	switch {
+	case n > 1 && cfg.naturalOrder:
+		log.Println("⚠️ Warning: Natural-order scans can't be split by _id, using a single worker.")
+		return 1
+	case n > 1 && cfg.deltaField != "":
+		log.Println("⚠️ Warning: Delta exports resume from the checkpoint, using a single worker.")
+		return 1
+	case n > 1 && cfg.snapshot:
+		log.Println("⚠️ Warning: Snapshot reads share one session, using a single worker.")
+		return 1
+	}
+	return n
+}
+
+// A worker per CPU, or two per shard on a sharded cluster if that's more,
+// capped at maxAutoWorkers
+func autoWorkers(client *mongo.Client) int {
+	n := runtime.NumCPU()
+	// config.shards only has entries when connected through mongos
+	shards, err := client.Database("config").Collection("shards").CountDocuments(context.TODO(), bson.M{})
+	if err == nil && shards > 0 {
+		log.Printf("🧩 Sharded cluster with %d shards\n", shards)
+		n = max(n, 2*int(shards))
+	}
+	return min(n, maxAutoWorkers)
+}
+
+// Documents $sample reads per partition to place split points
+const samplesPerPartition = 100
+
+// What the connected deployment supports, as far as partitioning goes
+type serverInfo struct {
+	version      string // e.g. "7.0.4", empty if unknown
+	major, minor int
+	mongos       bool
+}
+
+// Whether the server is at least version major.minor. An unknown version
+// counts as older.
+func (s serverInfo) atLeast(major, minor int) bool {
+	return s.major > major || s.major == major && s.minor >= minor
+}
+
+// Detect the server version and whether it is a mongos. Failures leave the
+// fields unknown, so every strategy is still tried.
+func detectServer(client *mongo.Client) serverInfo {
+	admin := client.Database("admin")
+	var info serverInfo
+	var build struct {
+		Version      string  `bson:"version"`
+		VersionArray []int32 `bson:"versionArray"`
+	}
+	if err := admin.RunCommand(context.TODO(), bson.D{{Key: "buildInfo", Value: 1}}).Decode(&build); err != nil {
+		log.Printf("⚠️ Warning: buildInfo failed, can't detect the server version: %v\n", err)
+	} else if len(build.VersionArray) >= 2 {
+		info.version = build.Version
+		info.major, info.minor = int(build.VersionArray[0]), int(build.VersionArray[1])
+	}
+
+	// hello replaced isMaster in 4.4.2, and only mongos answers isdbgrid
+	command := "hello"
+	if !info.atLeast(5, 0) {
+		command = "isMaster"
+	}
+	var reply struct {
+		Msg string `bson:"msg"`
+	}
+	if err := admin.RunCommand(context.TODO(), bson.D{{Key: command, Value: 1}}).Decode(&reply); err == nil {
+		info.mongos = reply.Msg == "isdbgrid"
+	}
+	return info
+}
+
+// Split the _id range into up to n partitions, with the most accurate split
+// points the deployment can provide: the chunk boundaries of a collection
+// sharded on {_id: 1}, then splitVector (not through mongos), then the
+// quantiles of a $sample of _ids. If none are available the range is
+// divided into equal widths. The chosen strategy is logged.
+func partitionIDRange(collection *mongo.Collection, filter bson.M, n int) ([]workItem, error) {
+	server := detectServer(collection.Database().Client())
+	if server.version != "" {
+		kind := "mongod"
+		if server.mongos {
+			kind = "mongos"
+		}
+		log.Printf("🧩 Connected to %s %s\n", kind, server.version)
+	}
+
+	strategy := "chunk boundaries"
+	points, err := chunkSplitPoints(collection, server, n)
+	if err != nil {
+		log.Printf("⚠️ Warning: Can't read chunk boundaries: %v\n", err)
+	}
+	if len(points) == 0 && !server.mongos {
+		strategy = "splitVector"
+		if points, err = splitVectorPoints(collection, n); err != nil {
+			log.Printf("⚠️ Warning: splitVector failed: %v\n", err)
+		}
+	}
+	if len(points) == 0 {
+		strategy = "$sample"
+		if points, err = samplePoints(collection, n); err != nil {
+			log.Printf("⚠️ Warning: $sample failed, splitting the _id range into equal widths: %v\n", err)
+		}
+	}
+	if len(points) == 0 {
+		strategy = "equal widths"
+		if points, err = equalWidthSplitPoints(collection, filter, n); err != nil {
+			return nil, err
+		}
+	}
+	if len(points) > 0 {
+		log.Printf("🧩 Partitioning strategy: %s, %d partitions\n", strategy, len(points)+1)
+	}
+	return partitionItems(points), nil
+}
+
+// Work items for the partitions between consecutive split points. The first
+// partition has no lower bound and the last no upper bound, so documents
+// outside the sampled range inserted meanwhile aren't lost.
+func partitionItems(points []primitive.ObjectID) []workItem {
+	items := make([]workItem, len(points)+1)
+	for i := range items {
+		items[i].partition = i
+		idRange := bson.M{}
+		if i > 0 {
+			idRange["$gt"] = points[i-1]
+		}
+		if i < len(points) {
+			idRange["$lte"] = points[i]
+		}
+		if len(idRange) > 0 {
+			items[i].bounds = bson.M{"_id": idRange}
+		}
+		log.Printf("🧩 Worker %d: _id range (%s, %s]\n", i, idString(objectDocID(lookupObjectID(idRange, "$gt")), "start"), idString(objectDocID(lookupObjectID(idRange, "$lte")), "end"))
+	}
+	return items
+}
+
+// A sharded collection's entry in config.collections
+type shardingMeta struct {
+	Key  bson.D      `bson:"key"`
+	UUID interface{} `bson:"uuid"`
+	ns   string
+}
+
+// Read how the collection is sharded. Returns nil if it isn't, or the
+// server isn't a mongos, whose config database is the only one with entries.
+func loadShardingMeta(collection *mongo.Collection) (*shardingMeta, error) {
+	config := collection.Database().Client().Database("config")
+	meta := &shardingMeta{ns: collection.Database().Name() + "." + collection.Name()}
+	err := config.Collection("collections").FindOne(context.TODO(), bson.M{"_id": meta.ns}).Decode(meta)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// The config.chunks query for the collection's chunks. Chunks are keyed by
+// collection UUID since MongoDB 5.0, by namespace before. Both are tried if
+// the version is unknown.
+func (meta *shardingMeta) chunks(server serverInfo) bson.M {
+	if server.atLeast(5, 0) {
+		return bson.M{"uuid": meta.UUID}
+	}
+	if server.version != "" {
+		return bson.M{"ns": meta.ns}
+	}
+	return bson.M{"$or": bson.A{bson.M{"uuid": meta.UUID}, bson.M{"ns": meta.ns}}}
+}
+
+// Split points at chunk boundaries, if the collection is sharded on {_id: 1}.
+// Returns nil for unsharded collections and other shard keys.
+func chunkSplitPoints(collection *mongo.Collection, server serverInfo, n int) ([]primitive.ObjectID, error) {
+	meta, err := loadShardingMeta(collection)
+	if meta == nil || err != nil {
+		return nil, err
+	}
+	if len(meta.Key) != 1 || meta.Key[0].Key != "_id" || meta.Key[0].Value == "hashed" {
+		return nil, nil
+	}
+
+	config := collection.Database().Client().Database("config")
+	cursor, err := config.Collection("chunks").Find(
+		context.TODO(),
+		meta.chunks(server),
+		options.Find().SetSort(bson.D{{Key: "min", Value: 1}}).SetProjection(bson.M{"min": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	var boundaries []primitive.ObjectID
+	for cursor.Next(context.TODO()) {
+		id, ok := cursor.Current.Lookup("min", "_id").ObjectIDOK()
+		if ok {
+			boundaries = append(boundaries, id)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	log.Printf("🧩 Choosing split points from %d chunk boundaries\n", len(boundaries))
+	return pickSplitPoints(boundaries, n), nil
+}
+
+// Split points from splitVector, which walks the _id index and returns keys
+// roughly every 1/n of the documents. Only runs on a replica set member or
+// standalone server, not through mongos.
+func splitVectorPoints(collection *mongo.Collection, n int) ([]primitive.ObjectID, error) {
+	count, err := collection.EstimatedDocumentCount(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	if count < int64(n) {
+		return nil, nil
+	}
+	var result struct {
+		SplitKeys []bson.Raw `bson:"splitKeys"`
+	}
+	err = collection.Database().RunCommand(context.TODO(), bson.D{
+		{Key: "splitVector", Value: collection.Database().Name() + "." + collection.Name()},
+		{Key: "keyPattern", Value: bson.D{{Key: "_id", Value: 1}}},
+		// The document count decides, the size limit is only required by the command
+		{Key: "maxChunkObjects", Value: count / int64(n)},
+		{Key: "maxChunkSizeBytes", Value: int64(1) << 50},
+	}).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	var keys []primitive.ObjectID
+	for _, key := range result.SplitKeys {
+		if id, ok := key.Lookup("_id").ObjectIDOK(); ok {
+			keys = append(keys, id)
+		}
+	}
+	log.Printf("🧩 splitVector returned %d split points\n", len(keys))
+	return pickSplitPoints(keys, n), nil
+}
+
+// Split points at the quantiles of a random sample of _ids. As the first
+// stage of a pipeline, $sample reads through a random cursor instead of
+// scanning when the sample is under 5% of the collection, so this is cheap on
+// large collections, works through mongos and only needs the read role.
+func samplePoints(collection *mongo.Collection, n int) ([]primitive.ObjectID, error) {
+	cursor, err := collection.Aggregate(context.TODO(), mongo.Pipeline{
+		{{Key: "$sample", Value: bson.D{{Key: "size", Value: n * samplesPerPartition}}}},
+		{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	var ids []primitive.ObjectID
+	for cursor.Next(context.TODO()) {
+		// A random cursor can return the same document twice
+		id, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+		if ok && (len(ids) == 0 || id != ids[len(ids)-1]) {
+			ids = append(ids, id)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) < n {
+		return nil, nil
+	}
+	log.Printf("🧩 $sample returned %d distinct _ids\n", len(ids))
+	return pickSplitPoints(ids, n), nil
+}
+
+// Up to n-1 of the sorted candidate points, spread evenly over them
+func pickSplitPoints(candidates []primitive.ObjectID, n int) []primitive.ObjectID {
+	if len(candidates) < n {
+		return candidates
+	}
+	points := make([]primitive.ObjectID, 0, n-1)
+	for i := 1; i < n; i++ {
+		points = append(points, candidates[i*len(candidates)/n])
+	}
+	return points
+}
+
+// n-1 split points dividing the _id range matching filter into equal widths.
+// ObjectIDs start with their creation time, so this divides the range into
+// equal time slices, which is skewed if the insert rate varied a lot. Returns
+// no points if the range can't be split.
+func equalWidthSplitPoints(collection *mongo.Collection, filter bson.M, n int) ([]primitive.ObjectID, error) {
+	lo, err := boundaryID(collection, filter, 1)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := boundaryID(collection, filter, -1)
+	if err != nil {
+		return nil, err
+	}
+	if lo.IsZero() || hi.IsZero() {
+		log.Println("⚠️ Warning: No ObjectID _id range to split (no documents, or _ids of another type), using a single worker.")
+		return nil, nil
+	}
+
+	start := new(big.Int).SetBytes(lo[:])
+	width := new(big.Int).Sub(new(big.Int).SetBytes(hi[:]), start)
+	if width.Cmp(big.NewInt(int64(n))) < 0 {
+		return nil, nil
+	}
+	points := make([]primitive.ObjectID, n-1)
+	for i := range points {
+		offset := new(big.Int).Mul(width, big.NewInt(int64(i+1)))
+		offset.Div(offset, big.NewInt(int64(n)))
+		new(big.Int).Add(start, offset).FillBytes(points[i][:])
+	}
+	return points, nil
+}
+
+// Lowest (dir 1) or highest (dir -1) ObjectID _id matching filter, zero if
+// there are no documents or the _id isn't an ObjectID
+func boundaryID(collection *mongo.Collection, filter bson.M, dir int) (primitive.ObjectID, error) {
+	var doc struct {
+		ID interface{} `bson:"_id"`
+	}
+	err := collection.FindOne(
+		context.TODO(),
+		andFilters(filter, nil),
+		options.FindOne().SetSort(bson.D{{Key: "_id", Value: dir}}).SetProjection(bson.M{"_id": 1}),
+	).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return primitive.NilObjectID, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("failed to find _id range: %w", err)
+	}
+	id, _ := doc.ID.(primitive.ObjectID)
+	return id, nil
+}
+
+// ObjectID stored under key, zero if it's not set
+func lookupObjectID(m bson.M, key string) primitive.ObjectID {
+	id, _ := m[key].(primitive.ObjectID)
+	return id
+}
+
+// Progress of a parallel export's partitions, saved in checkpoint.json
+type partitionCheckpoint struct {
+	Points  []string `json:"points"`   // Split points between partitions, hex ObjectIDs
+	LastIDs []string `json:"last_ids"` // Last _id each partition exported, empty if none yet
+	Batches []int    `json:"batches"`  // Batches each partition has written
+}
+
+// Saves each worker's position in the checkpoint state as its batches complete
+type partitionTracker struct {
+	mu    sync.Mutex
+	state *checkpointState
+	key   string
+}
+
+// Work items for a parallel export. A checkpointed export resumes the
+// partitions saved by the previous run, whatever --workers is now, since
+// its progress only makes sense for the same split points.
+func planPartitions(cfg *config, collection *mongo.Collection, state *checkpointState, key string) ([]workItem, error) {
+	if cfg.noCheckpoint {
+		return partitionIDRange(collection, cfg.filter, cfg.workers)
+	}
+	cfg.partitions = &partitionTracker{state: state, key: key}
+
+	if saved := state.Partitions[key]; saved != nil {
+		points := make([]primitive.ObjectID, len(saved.Points))
+		for i, hex := range saved.Points {
+			var err error
+			if points[i], err = primitive.ObjectIDFromHex(hex); err != nil {
+				return nil, fmt.Errorf("invalid split point %q in %s: %w", hex, checkpointFile, err)
+			}
+		}
+		if len(saved.LastIDs) != len(points)+1 || len(saved.Batches) != len(points)+1 {
+			return nil, fmt.Errorf("partitions of %s in %s are inconsistent", key, checkpointFile)
+		}
+		log.Printf("🔁 Resuming the %d partitions saved in %s\n", len(points)+1, checkpointFile)
+		items := partitionItems(points)
+		for i := range items {
+			if saved.LastIDs[i] != "" {
+				id, err := parseDocID(saved.LastIDs[i], cfg.idType)
+				if err != nil {
+					return nil, fmt.Errorf("invalid last _id %q in %s: %w", saved.LastIDs[i], checkpointFile, err)
+				}
+				log.Printf("🔄 Worker %d: Resuming after _id %s\n", i, id)
+				recordHistory(cfg, "resume", i, 0, id)
+				items[i].lastID = rewindResumeID(cfg, i, id)
+			}
+			items[i].firstBatch = saved.Batches[i] + 1
+		}
+		return items, nil
+	}
+
+	items, err := partitionIDRange(collection, cfg.filter, cfg.workers)
+	if err != nil {
+		return nil, err
+	}
+	saved := &partitionCheckpoint{LastIDs: make([]string, len(items)), Batches: make([]int, len(items))}
+	for _, item := range items[:len(items)-1] {
+		saved.Points = append(saved.Points, lookupObjectID(item.bounds["_id"].(bson.M), "$lte").Hex())
+	}
+	if state.Partitions == nil {
+		state.Partitions = map[string]*partitionCheckpoint{}
+	}
+	state.Partitions[key] = saved
+	if err := saveCheckpointState(state); err != nil {
+		return nil, fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return items, nil
+}
+
+// Record that a partition is exported up to lastID
+func (t *partitionTracker) save(partition, batchNum int, lastID docID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	saved := t.state.Partitions[t.key]
+	saved.LastIDs[partition] = lastID.String()
+	saved.Batches[partition] = batchNum
+	if err := saveCheckpointState(t.state); err != nil {
+		log.Printf("⚠️ Warning: Failed to save partition checkpoint: %v\n", err)
+	}
+}
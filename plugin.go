@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Longest line read back from a --transform-plugin. BSON documents are at
+// most 16 MiB, and their extended JSON can be a few times larger.
+const maxPluginLine = 64 << 20
+
+// External command that reshapes documents for --transform-plugin. It is
+// started once per batch, reads the batch's documents on stdin as relaxed
+// extended JSON, one per line, and must write one line per document to
+// stdout in the same order: the document to write instead, or null to drop
+// it. Running a process per batch means a plugin needn't flush its output
+// early or keep state between documents.
+type transformPlugin struct {
+	args []string // Program and its arguments
+}
+
+// Split the command on whitespace. There is no shell quoting, so anything
+// more elaborate belongs in a script.
+func newTransformPlugin(command string) (*transformPlugin, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, err
+	}
+	return &transformPlugin{args: args}, nil
+}
+
+// Pipe the batch's decoded documents through the plugin and replace them
+// with its output. Dropped documents are counted in b.dropped. A nil
+// plugin leaves the batch as it is.
+func (p *transformPlugin) apply(workerID, batchNum int, b *batch) error {
+	n := len(b.docs) + len(b.ordered)
+	if p == nil || n == 0 {
+		return nil
+	}
+	cmd := exec.CommandContext(rootCtx, p.args[0], p.args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start --transform-plugin: %w", err)
+	}
+
+	// Feed the documents while reading the output, so neither pipe fills up
+	// and blocks the other
+	written := make(chan error, 1)
+	go func() {
+		written <- writePluginInput(stdin, b)
+	}()
+
+	var docs []bson.M
+	var ordered []bson.D
+	lines := 0
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, maxPluginLine)
+	var parseErr error
+	for scanner.Scan() && parseErr == nil {
+		lines++
+		line := bytes.TrimSpace(scanner.Bytes())
+		switch {
+		case lines > n:
+			parseErr = fmt.Errorf("more lines than the %d documents it was given", n)
+		case string(line) == "null":
+			b.dropped++
+		case b.ordered != nil:
+			var doc bson.D
+			if err := bson.UnmarshalExtJSON(line, false, &doc); err != nil {
+				parseErr = fmt.Errorf("invalid document on line %d: %w", lines, err)
+			}
+			ordered = append(ordered, doc)
+		default:
+			var doc bson.M
+			if err := bson.UnmarshalExtJSON(line, false, &doc); err != nil {
+				parseErr = fmt.Errorf("invalid document on line %d: %w", lines, err)
+			}
+			docs = append(docs, doc)
+		}
+	}
+	if parseErr == nil {
+		parseErr = scanner.Err()
+	}
+	// Let the plugin exit rather than block on a full pipe after a bad line
+	io.Copy(io.Discard, stdout)
+	writeErr := <-written
+	waitErr := cmd.Wait()
+
+	switch {
+	case waitErr != nil:
+		return fmt.Errorf("--transform-plugin failed on batch %d: %w%s", batchNum, waitErr, stderrTail(&stderr))
+	case parseErr != nil:
+		return fmt.Errorf("--transform-plugin output for batch %d: %w", batchNum, parseErr)
+	case writeErr != nil:
+		return fmt.Errorf("failed to write batch %d to --transform-plugin: %w", batchNum, writeErr)
+	case lines < n:
+		return fmt.Errorf("--transform-plugin returned %d lines for the %d documents of batch %d, expected one per document (null to drop it)", lines, n, batchNum)
+	}
+	if b.ordered != nil {
+		b.ordered = ordered
+	} else {
+		b.docs = docs
+	}
+	if b.dropped > 0 {
+		log.Printf("🔌 Worker %d: --transform-plugin dropped %d documents from batch %d\n", workerID, b.dropped, batchNum)
+	}
+	return nil
+}
+
+// Write each document of the batch as a line of relaxed extended JSON, then
+// close stdin to tell the plugin the batch is complete
+func writePluginInput(stdin io.WriteCloser, b *batch) error {
+	w := bufio.NewWriter(stdin)
+	var err error
+	for i := 0; i < len(b.docs)+len(b.ordered) && err == nil; i++ {
+		var line []byte
+		if b.ordered != nil {
+			line, err = bson.MarshalExtJSON(b.ordered[i], false, false)
+		} else {
+			line, err = bson.MarshalExtJSON(b.docs[i], false, false)
+		}
+		if err == nil {
+			w.Write(line)
+			err = w.WriteByte('\n')
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := stdin.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Last line the plugin wrote to stderr, to explain why it failed
+func stderrTail(stderr *bytes.Buffer) string {
+	text := strings.TrimSpace(stderr.String())
+	if text == "" {
+		return ""
+	}
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		text = text[i+1:]
+	}
+	return ": " + text
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Subset of the collStats command output used for preflight checks
+type collStats struct {
+	Count          int64            `bson:"count"`
+	AvgObjSize     float64          `bson:"avgObjSize"`
+	Size           int64            `bson:"size"`
+	StorageSize    int64            `bson:"storageSize"`
+	TotalIndexSize int64            `bson:"totalIndexSize"`
+	IndexSizes     map[string]int64 `bson:"indexSizes"`
+	Capped         bool             `bson:"capped"`
+}
+
+// Run collStats for the collection
+func fetchCollStats(db *mongo.Database, collectionName string) (*collStats, error) {
+	var stats collStats
+	err := db.RunCommand(context.TODO(), bson.D{{Key: "collStats", Value: collectionName}}).Decode(&stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Log collection stats and the estimated export size, refusing to continue
+// past --max-export-bytes unless --yes was given
+func runPreflight(cfg *config, stats *collStats) error {
+	log.Printf("📊 Collection stats: %d documents, avg %s/doc, data %s, storage %s, indexes %s\n",
+		stats.Count, formatBytes(int64(stats.AvgObjSize)), formatBytes(stats.Size),
+		formatBytes(stats.StorageSize), formatBytes(stats.TotalIndexSize))
+	for name, size := range stats.IndexSizes {
+		log.Printf("📊   Index %s: %s\n", name, formatBytes(size))
+	}
+
+	estimate := estimateOutputSize(cfg, stats.Size)
+	compression := "uncompressed"
+	if cfg.compressEachBatch || cfg.compressFinal {
+		compression = "gzip"
+	}
+	log.Printf("📊 Estimated export size on disk: ~%s (%s, %s)\n", formatBytes(estimate), cfg.format, compression)
+
+	if cfg.maxExportBytes > 0 && estimate > cfg.maxExportBytes {
+		if !cfg.yes {
+			return fmt.Errorf("estimated export size %s exceeds --max-export-bytes (%s), re-run with --yes to proceed",
+				formatBytes(estimate), formatBytes(cfg.maxExportBytes))
+		}
+		log.Printf("⚠️ Warning: Estimated export size exceeds --max-export-bytes, continuing because --yes was given\n")
+	}
+	return nil
+}
+
+// Refuse to scan a collection of at least --primary-guard-bytes on a
+// replica set primary, where the reads compete with production traffic,
+// unless --allow-primary is given. Reads through mongos go to the shard
+// primaries with the same effect. Standalone servers have no secondary to
+// move the load to and aren't checked.
+func guardPrimary(cfg *config, collection *mongo.Collection, stats *collStats) error {
+	if cfg.allowPrimary || cfg.primaryGuardBytes == 0 || stats.Size < cfg.primaryGuardBytes {
+		return nil
+	}
+	if cfg.readPref != nil && cfg.readPref.Mode() != readpref.PrimaryMode && cfg.readPref.Mode() != readpref.PrimaryPreferredMode {
+		return nil
+	}
+	target := primaryTarget(collection.Database().Client())
+	if target == "" {
+		return nil
+	}
+	return fmt.Errorf("%s holds %s and would be read from %s, which can slow down production. Read from a secondary with --read-preference=secondary, or pass --allow-primary to export from the primary anyway (--primary-guard-bytes=0 turns this check off)",
+		collection.Name(), formatBytes(stats.Size), target)
+}
+
+// Describe the primary the connection reads from, or return "" if it isn't
+// a replica set primary or a mongos, or hello fails
+func primaryTarget(client *mongo.Client) string {
+	admin := client.Database("admin")
+	var reply struct {
+		Msg     string `bson:"msg"`
+		SetName string `bson:"setName"`
+		Me      string `bson:"me"`
+	}
+	// Servers before 4.4.2 only know hello as isMaster
+	if err := admin.RunCommand(context.TODO(), bson.D{{Key: "hello", Value: 1}}).Decode(&reply); err != nil {
+		if err := admin.RunCommand(context.TODO(), bson.D{{Key: "isMaster", Value: 1}}).Decode(&reply); err != nil {
+			return ""
+		}
+	}
+	switch {
+	case reply.Msg == "isdbgrid":
+		return "the shard primaries behind mongos"
+	case reply.SetName != "":
+		return fmt.Sprintf("the primary %s of replica set %s", reply.Me, reply.SetName)
+	}
+	return ""
+}
+
+// Capped collections have to be read in insertion order: old documents are
+// overwritten as new ones arrive, so _id pagination can silently skip ranges.
+// Switch to a single natural-order scan and warn about the moving window.
+func checkCapped(cfg *config, stats *collStats) {
+	if !stats.Capped {
+		return
+	}
+	if !cfg.naturalOrder {
+		log.Println("⚠️ Warning: Capped collection detected, switching to natural (insertion) order scan...")
+		cfg.naturalOrder = true
+		cfg.noCheckpoint = true
+	}
+	log.Println("⚠️ Warning: Capped collections are a moving window, documents overwritten during the export will be missed")
+}
+
+// Format a byte count as a human-readable string
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
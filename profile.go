@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// pprof profiles of the run for --cpuprofile and --memprofile. A nil
+// *profiler does nothing, so stop can be called on every exit path.
+type profiler struct {
+	once    sync.Once
+	cpuFile *os.File // Open CPU profile, nil unless --cpuprofile is set
+	memPath string   // Where to write the heap profile, empty unless --memprofile is set
+}
+
+// Start the CPU profile, if any. Returns nil if neither profile is wanted.
+func startProfiling(cpuPath, memPath string) (*profiler, error) {
+	if cpuPath == "" && memPath == "" {
+		return nil, nil
+	}
+	p := &profiler{memPath: memPath}
+	if cpuPath != "" {
+		file, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		p.cpuFile = file
+		log.Printf("🔬 Writing a CPU profile to %s\n", cpuPath)
+	}
+	return p, nil
+}
+
+// Flush the CPU profile and write the heap profile. Only the first call
+// does anything, so the abort handler and the normal exit can both call it.
+func (p *profiler) stop() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() {
+		if p.cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := p.cpuFile.Close(); err != nil {
+				log.Printf("⚠️ Warning: Failed to write CPU profile: %v\n", err)
+			}
+		}
+		if p.memPath != "" {
+			if err := writeHeapProfile(p.memPath); err != nil {
+				log.Printf("⚠️ Warning: Failed to write memory profile: %v\n", err)
+			} else {
+				log.Printf("🔬 Wrote a memory profile to %s\n", p.memPath)
+			}
+		}
+	})
+}
+
+// Heap profile after a GC, so live objects are up to date. It also holds
+// every allocation since the start, for pprof -sample_index=alloc_space.
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Progress of the run for --progress-file, rewritten every --progress-interval
+// so an orchestrator can poll it instead of parsing the log. Each write
+// replaces the file atomically, so readers never see partial JSON. A nil
+// reporter does nothing.
+type progressReporter struct {
+	mu       sync.Mutex
+	path     string
+	interval time.Duration
+	stop     chan struct{}
+	stopped  sync.Once
+	done     chan struct{}
+
+	startedAt time.Time
+	documents int64 // Across every collection of the run
+	batches   int64
+	lastID    docID
+
+	// The collection being exported
+	database        string
+	collection      string
+	collectionStart time.Time
+	collectionDocs  int64
+	total           int64 // Estimated documents to export, -1 if unknown
+}
+
+// Contents of the progress file
+type progressSnapshot struct {
+	Status              string     `json:"status"` // "running", then the summary's status once the run ends
+	Database            string     `json:"database,omitempty"`
+	Collection          string     `json:"collection,omitempty"`
+	Documents           int64      `json:"documents"`            // Written by this run, all collections
+	Batches             int64      `json:"batches"`              // Written by this run, all collections
+	CollectionDocuments int64      `json:"collection_documents"` // Written by this run in the current collection
+	EstimatedTotal      *int64     `json:"estimated_total,omitempty"`
+	LastID              string     `json:"last_id,omitempty"` // _id the most recent batch ended at
+	Rate                float64    `json:"docs_per_second"`   // Average over the current collection
+	ETA                 *time.Time `json:"eta,omitempty"`
+	ETASeconds          *int64     `json:"eta_seconds,omitempty"`
+	StartedAt           time.Time  `json:"started_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// Write the first snapshot and keep rewriting it in the background. Returns
+// nil if path is empty.
+func startProgress(path string, interval time.Duration) *progressReporter {
+	if path == "" {
+		return nil
+	}
+	now := time.Now()
+	p := &progressReporter{path: path, interval: interval, stop: make(chan struct{}), done: make(chan struct{}), startedAt: now, collectionStart: now, total: -1}
+	p.write("running")
+	log.Printf("📈 Writing progress to %s every %s\n", path, interval)
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.write("running")
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Start reporting on a collection. The total, and so the ETA, is only known
+// when the export covers the whole collection from the start, since the
+// metadata count can't tell how many documents a filter or a resume leaves.
+func (p *progressReporter) startCollection(database, name string, collection *mongo.Collection, whole bool) {
+	if p == nil {
+		return
+	}
+	total := int64(-1)
+	if whole {
+		if n, err := collection.EstimatedDocumentCount(context.TODO()); err == nil {
+			total = n
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.database, p.collection = database, name
+	p.collectionStart, p.collectionDocs, p.total = time.Now(), 0, total
+}
+
+// Count a written batch
+func (p *progressReporter) addBatch(documents int, lastID docID) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.documents += int64(documents)
+	p.collectionDocs += int64(documents)
+	p.batches++
+	if !lastID.IsZero() {
+		p.lastID = lastID
+	}
+}
+
+// Stop the background writes and write the final snapshot with the run's
+// status. Only the first call does anything.
+func (p *progressReporter) finish(status string) {
+	if p == nil {
+		return
+	}
+	p.stopped.Do(func() {
+		close(p.stop)
+		<-p.done
+		p.write(status)
+	})
+}
+
+func (p *progressReporter) write(status string) {
+	p.mu.Lock()
+	now := time.Now()
+	snap := progressSnapshot{
+		Status:              status,
+		Database:            p.database,
+		Collection:          p.collection,
+		Documents:           p.documents,
+		Batches:             p.batches,
+		CollectionDocuments: p.collectionDocs,
+		StartedAt:           p.startedAt.UTC(),
+		UpdatedAt:           now.UTC(),
+	}
+	if !p.lastID.IsZero() {
+		snap.LastID = p.lastID.String()
+	}
+	if elapsed := now.Sub(p.collectionStart).Seconds(); elapsed > 0 {
+		snap.Rate = float64(p.collectionDocs) / elapsed
+	}
+	if p.total >= 0 {
+		total := p.total
+		snap.EstimatedTotal = &total
+		if status == "running" && snap.Rate > 0 {
+			remaining := p.total - p.collectionDocs
+			if remaining < 0 {
+				remaining = 0 // Documents inserted since the estimate
+			}
+			seconds := int64(float64(remaining) / snap.Rate)
+			eta := now.Add(time.Duration(seconds) * time.Second).UTC()
+			snap.ETA, snap.ETASeconds = &eta, &seconds
+		}
+	}
+	p.mu.Unlock()
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(p.path, append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("⚠️ Warning: Failed to write progress file: %v\n", err)
+	}
+}
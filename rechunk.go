@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The rechunk subcommand: rewrite an export's batch files with a new number
+// of documents per file or a size limit, reading the files instead of
+// querying MongoDB again
+func runRechunk(args []string) error {
+	fs := flag.NewFlagSet("rechunk", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s rechunk [flags] <export-dir> <output-dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	batchSize := fs.Int("batch-size", 0, "documents per output file (0 = no limit)")
+	maxBytes := fs.Int64("max-bytes", 0, "start a new file once one holds this many bytes, before compression (0 = no limit)")
+	idField := fs.String("id-field", "_id", "field holding the _id, for the manifest's last_id")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("give an export directory and an output directory")
+	}
+	if *batchSize < 0 || *maxBytes < 0 {
+		return fmt.Errorf("--batch-size and --max-bytes can't be negative")
+	}
+	if *batchSize == 0 && *maxBytes == 0 {
+		return fmt.Errorf("give --batch-size, --max-bytes or both")
+	}
+	r := &rechunker{in: fs.Arg(0), out: fs.Arg(1), batchSize: *batchSize, maxBytes: *maxBytes, idField: *idField, outputs: map[int]*rechunkOutput{}}
+	if err := r.prepare(); err != nil {
+		return err
+	}
+	if err := r.run(); err != nil {
+		r.abort()
+		return err
+	}
+	log.Printf("✂️ Rechunked %d documents from %d files in %s into %d files in %s\n", r.documents, r.read, r.in, r.written, r.out)
+	fmt.Printf("✂️ %d documents from %d files rewritten as %d files in %s\n", r.documents, r.read, r.written, r.out)
+	return nil
+}
+
+// Rewrites one export directory into another
+type rechunker struct {
+	in, out   string
+	batchSize int
+	maxBytes  int64
+	idField   string
+
+	// Taken from the first batch file; every other file must match
+	format     string
+	compressed bool
+	prefix     string
+
+	manifest *exportManifest // Nil if the input has no manifest
+	outputs  map[int]*rechunkOutput
+
+	read, written, documents int
+}
+
+// The file a worker's documents are being written to
+type rechunkOutput struct {
+	worker    int
+	batch     int // Number of the last file opened, counting from 1
+	path      string
+	file      *os.File
+	gz        *gzip.Writer
+	buf       *bufio.Writer
+	counter   *countingWriter
+	json      *jsonArrayWriter
+	documents int
+	lastID    docID // Last _id from a batch in _id order, zero if none
+}
+
+// Check the directories and create the output one
+func (r *rechunker) prepare() error {
+	if info, err := os.Stat(r.in); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", r.in)
+	}
+	in, err := filepath.Abs(r.in)
+	if err != nil {
+		return err
+	}
+	out, err := filepath.Abs(r.out)
+	if err != nil {
+		return err
+	}
+	if in == out {
+		return fmt.Errorf("the output directory must differ from the export directory")
+	}
+	entries, err := os.ReadDir(r.out)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	case len(entries) > 0:
+		return fmt.Errorf("%s is not empty", r.out)
+	}
+	return os.MkdirAll(r.out, 0o755)
+}
+
+// Copy each worker's documents in order, batch by batch, as the manifest
+// lists them or, without one, as the file names number them
+func (r *rechunker) run() error {
+	v := &exportVerifier{dir: r.in, idField: r.idField}
+	onDisk, err := v.findFiles()
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+	batches, _, err := v.readManifest()
+	if err != nil {
+		return err
+	}
+	if batches != nil {
+		if r.manifest, _, err = openManifest(r.out, "", true, r.algorithm(batches), idTypeObjectID); err != nil {
+			return fmt.Errorf("failed to create %s: %w", manifestFile, err)
+		}
+		defer r.manifest.close()
+	} else {
+		batches = v.batchesFromNames(onDisk)
+	}
+	for _, warning := range v.warnings {
+		log.Printf("⚠️ Warning: %s\n", warning)
+	}
+
+	seen := map[string]bool{} // A single export file is listed by every batch
+	for _, b := range batches {
+		if b.rewritten {
+			continue
+		}
+		if b.reset {
+			if err := r.reset(b.worker); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, path := range b.files {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if err := r.copyFile(b, path); err != nil {
+				return err
+			}
+		}
+	}
+	workers := make([]int, 0, len(r.outputs))
+	for worker := range r.outputs {
+		workers = append(workers, worker)
+	}
+	sort.Ints(workers)
+	for _, worker := range workers {
+		if err := r.finish(r.outputs[worker]); err != nil {
+			return err
+		}
+	}
+	if r.read == 0 {
+		return fmt.Errorf("no batch files in %s", r.in)
+	}
+	return nil
+}
+
+// Checksum the new files with the algorithm the input's manifest used
+func (r *rechunker) algorithm(batches []verifyBatch) string {
+	for _, b := range batches {
+		if b.algorithm != "" {
+			return b.algorithm
+		}
+	}
+	return ""
+}
+
+// A manifest reset: end the worker's file so the reset falls between the
+// same documents as before
+func (r *rechunker) reset(worker int) error {
+	if o := r.outputs[worker]; o != nil {
+		if err := r.finish(o); err != nil {
+			return err
+		}
+		o.lastID = docID{}
+	}
+	if r.manifest == nil {
+		return nil
+	}
+	return r.manifest.append(manifestEntry{Worker: worker, Reset: true})
+}
+
+// Read one input file and append its documents to the worker's output
+func (r *rechunker) copyFile(b verifyBatch, path string) error {
+	name := filepath.Base(path)
+	format, compressed, prefix := "json", strings.HasSuffix(name, ".gz"), ""
+	if m := batchFilePattern.FindStringSubmatch(name); m != nil {
+		prefix, format = m[1], m[5]
+	} else if m := singleFilePattern.FindStringSubmatch(name); m != nil {
+		prefix = m[1]
+	}
+	switch format {
+	case "csv":
+		return fmt.Errorf("%s: CSV exports can't be rechunked, since their rows don't carry types to write back", path)
+	case "avro":
+		return fmt.Errorf("%s: Avro exports can't be rechunked, since each file may have its own schema", path)
+	}
+	if r.read == 0 {
+		r.format, r.compressed, r.prefix = format, compressed, prefix
+	} else if format != r.format || compressed != r.compressed {
+		return fmt.Errorf("%s doesn't match the format of the other files", path)
+	}
+
+	file, err := os.Open(filepath.Join(r.in, path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var in io.Reader = bufio.NewReader(file)
+	if compressed {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	o := r.outputs[b.worker]
+	if o == nil {
+		o = &rechunkOutput{worker: b.worker}
+		r.outputs[b.worker] = o
+	}
+	write := func(doc []byte, id docID) error {
+		if err := r.write(o, doc); err != nil {
+			return err
+		}
+		if b.ordered && !id.IsZero() {
+			o.lastID = id
+		}
+		return nil
+	}
+	switch format {
+	case "json":
+		err = readJSONDocs(in, r.idField, write)
+	case "jsonl":
+		err = readJSONLineDocs(in, r.idField, write)
+	case "bson":
+		err = readBSONDocs(in, r.idField, write)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	r.read++
+	return nil
+}
+
+// Append a document, first closing the open file if it's full
+func (r *rechunker) write(o *rechunkOutput, doc []byte) error {
+	if o.file != nil && ((r.batchSize > 0 && o.documents >= r.batchSize) || (r.maxBytes > 0 && o.counter.n >= r.maxBytes)) {
+		if err := r.finish(o); err != nil {
+			return err
+		}
+	}
+	if o.file == nil {
+		if err := r.open(o); err != nil {
+			return err
+		}
+	}
+	var err error
+	switch r.format {
+	case "json":
+		err = o.json.write(json.RawMessage(doc), nil)
+	case "jsonl":
+		if _, err = o.counter.Write(doc); err == nil {
+			_, err = io.WriteString(o.counter, "\n")
+		}
+	default:
+		_, err = o.counter.Write(doc)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", o.path, err)
+	}
+	o.documents++
+	r.documents++
+	return nil
+}
+
+// Start the worker's next file, as a temp file until it's complete
+func (r *rechunker) open(o *rechunkOutput) error {
+	o.batch++
+	dir := filepath.Join(r.out, fmt.Sprintf("worker_%d", o.worker))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	o.path = filepath.Join(dir, fmt.Sprintf("%sbatch_%d.%s", r.prefix, o.batch, r.format))
+	if r.compressed {
+		o.path += ".gz"
+	}
+	file, err := os.Create(o.path + tmpSuffix)
+	if err != nil {
+		return err
+	}
+	o.file, o.documents = file, 0
+	var w io.Writer = file
+	if r.compressed {
+		o.gz = gzip.NewWriter(file)
+		w = o.gz
+	}
+	o.buf = bufio.NewWriter(w)
+	o.counter = &countingWriter{w: o.buf}
+	if r.format == "json" {
+		if o.json, err = newJSONArrayWriter(o.counter, "", false, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Complete the worker's open file and record it in the manifest
+func (r *rechunker) finish(o *rechunkOutput) error {
+	if o.file == nil {
+		return nil
+	}
+	file := o.file
+	o.file = nil
+	if o.json != nil {
+		if err := o.json.close(); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write %s: %w", o.path, err)
+		}
+	}
+	if err := o.buf.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", o.path, err)
+	}
+	if o.gz != nil {
+		if err := o.gz.Close(); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to compress %s: %w", o.path, err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", o.path, err)
+	}
+	if err := os.Rename(file.Name(), o.path); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", o.path, err)
+	}
+	r.written++
+	log.Printf("📝 Wrote %d documents to %s\n", o.documents, o.path)
+	if r.manifest == nil {
+		return nil
+	}
+
+	entry := manifestEntry{Worker: o.worker, Batch: o.batch, Documents: o.documents, Algorithm: r.manifest.algorithm}
+	if r.manifest.algorithm != "" {
+		sum, err := fileChecksum(r.manifest.algorithm, o.path)
+		if err != nil {
+			return fmt.Errorf("failed to checksum %s: %w", o.path, err)
+		}
+		entry.Checksums = []string{sum}
+	}
+	rel, err := filepath.Rel(r.out, o.path)
+	if err != nil {
+		rel = o.path
+	}
+	entry.Files = []string{rel}
+	if !o.lastID.IsZero() {
+		entry.LastID = o.lastID.String()
+	}
+	return r.manifest.append(entry)
+}
+
+// Remove the temp files left open by a failed run
+func (r *rechunker) abort() {
+	for _, o := range r.outputs {
+		if o.file != nil {
+			o.file.Close()
+			os.Remove(o.file.Name())
+		}
+	}
+}
+
+// Stream the documents of a JSON array as they were written
+func readJSONDocs(r io.Reader, idField string, fn func([]byte, docID) error) error {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == json.Delim('{') {
+		return fmt.Errorf("files written with --json-root can't be rechunked")
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("expected an array of documents")
+	}
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		var id docID
+		if oid := jsonObjectID(doc[idField]); !oid.IsZero() {
+			id = objectDocID(oid)
+		}
+		if err := fn(raw, id); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil { // Closing ]
+		return err
+	}
+	return nil
+}
+
+// Stream the lines of an extended JSON lines file, unchanged
+func readJSONLineDocs(r io.Reader, idField string, fn func([]byte, docID) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxPluginLine)
+	for scanner.Scan() {
+		var doc bson.Raw
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), false, &doc); err != nil {
+			return err
+		}
+		id, _ := rawDocID(doc.Lookup(idField))
+		if err := fn(scanner.Bytes(), id); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Stream raw BSON documents
+func readBSONDocs(r io.Reader, idField string, fn func([]byte, docID) error) error {
+	for {
+		doc, err := bson.NewFromIOReader(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.Validate(); err != nil {
+			return err
+		}
+		id, _ := rawDocID(doc.Lookup(idField))
+		if err := fn(doc, id); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// How often replication lag is checked, and rechecked while paused
+const replLagInterval = 10 * time.Second
+
+// Pauses the export between batches while the secondaries lag the primary
+// by more than a limit, so a large export off a secondary doesn't leave it
+// ever further behind. Lag is checked with replSetGetStatus at most every
+// replLagInterval. A nil throttle never pauses.
+type lagThrottle struct {
+	mu        sync.Mutex
+	admin     *mongo.Database
+	limit     time.Duration
+	lastCheck time.Time
+}
+
+// Set up the throttle and check that replSetGetStatus works, returns nil if limit is 0
+func newLagThrottle(client *mongo.Client, limit time.Duration) (*lagThrottle, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	t := &lagThrottle{admin: client.Database("admin"), limit: limit}
+	lag, err := t.replicationLag()
+	if err != nil {
+		return nil, fmt.Errorf("--throttle-on-replication-lag needs replSetGetStatus on a replica set member (not mongos) and the clusterMonitor role: %w", err)
+	}
+	t.lastCheck = time.Now()
+	log.Printf("🐢 Throttling on replication lag over %s (currently %s)\n", limit, lag)
+	return t, nil
+}
+
+// Block while replication lag is over the limit. Workers share the throttle,
+// so while one waits here the others queue up behind it.
+func (t *lagThrottle) wait() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.lastCheck) < replLagInterval {
+		return
+	}
+
+	paused := false
+	for {
+		lag, err := t.replicationLag()
+		t.lastCheck = time.Now()
+		if err != nil {
+			// Don't stall the export on a monitoring hiccup
+			log.Printf("⚠️ Warning: Failed to check replication lag, carrying on: %v\n", err)
+			return
+		}
+		if lag <= t.limit {
+			if paused {
+				log.Printf("▶️ Replication lag back down to %s, resuming the export\n", lag)
+			}
+			return
+		}
+		if !paused {
+			log.Printf("⏸️ Replication lag of %s is over --max-repl-lag %s, pausing the export\n", lag, t.limit)
+			paused = true
+		}
+		time.Sleep(replLagInterval)
+	}
+}
+
+// Largest lag of any healthy secondary behind the primary
+func (t *lagThrottle) replicationLag() (time.Duration, error) {
+	var status struct {
+		Members []struct {
+			State      int       `bson:"state"`
+			Health     float64   `bson:"health"`
+			OptimeDate time.Time `bson:"optimeDate"`
+		} `bson:"members"`
+	}
+	if err := t.admin.RunCommand(context.TODO(), bson.D{{Key: "replSetGetStatus", Value: 1}}).Decode(&status); err != nil {
+		return 0, err
+	}
+
+	const primary, secondary = 1, 2
+	var primaryOptime time.Time
+	for _, m := range status.Members {
+		if m.State == primary {
+			primaryOptime = m.OptimeDate
+		}
+	}
+	if primaryOptime.IsZero() {
+		return 0, fmt.Errorf("replica set has no primary")
+	}
+	var lag time.Duration
+	for _, m := range status.Members {
+		if m.State == secondary && m.Health == 1 {
+			lag = max(lag, primaryOptime.Sub(m.OptimeDate))
+		}
+	}
+	return lag, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"reflect"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Most mismatching _ids logged individually by --sample-validate
+const maxLoggedMismatches = 20
+
+// Uniform random sample of the documents written during the run, kept with
+// reservoir sampling so it costs a fixed amount of memory however large the
+// export is
+type documentSampler struct {
+	mu    sync.Mutex
+	size  int
+	idKey string // Field the written documents hold the _id in
+	seen  int64
+	docs  []sampledDoc
+}
+
+type sampledDoc struct {
+	id  interface{} // _id as written, to fetch the source document by
+	doc interface{} // bson.M, bson.D or bson.Raw, as handed to the writer
+}
+
+func newDocumentSampler(cfg *config) *documentSampler {
+	idKey := "_id"
+	if cfg.idKey != "" {
+		idKey = cfg.idKey
+	}
+	return &documentSampler{size: cfg.sampleValidate, idKey: idKey}
+}
+
+// Offer every document of a written batch to the sample. Documents without
+// an _id, e.g. dropped by --map-expr, can't be fetched again and are ignored.
+func (s *documentSampler) add(b *batch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, doc := range b.docs {
+		if id, ok := doc[s.idKey]; ok {
+			s.offer(sampledDoc{id: id, doc: doc})
+		}
+	}
+	for _, doc := range b.ordered {
+		for _, e := range doc {
+			if e.Key == s.idKey {
+				s.offer(sampledDoc{id: e.Value, doc: doc})
+				break
+			}
+		}
+	}
+	for _, doc := range b.raw {
+		if id, err := doc.LookupErr(s.idKey); err == nil {
+			s.offer(sampledDoc{id: id, doc: doc})
+		}
+	}
+}
+
+func (s *documentSampler) offer(d sampledDoc) {
+	s.seen++
+	if len(s.docs) < s.size {
+		s.docs = append(s.docs, d)
+	} else if i := rand.Int63n(s.seen); i < int64(s.size) {
+		s.docs[i] = d
+	}
+}
+
+// Batch writer that offers each written batch to the sampler
+type sampleWriter struct {
+	batchWriter
+	sampler *documentSampler
+}
+
+func (w *sampleWriter) WriteBatch(workerID, batchNum int, b *batch) (string, error) {
+	paths, err := w.batchWriter.WriteBatch(workerID, batchNum, b)
+	if err == nil {
+		w.sampler.add(b)
+	}
+	return paths, err
+}
+
+// Fetch every sampled document again and compare it with the exported copy.
+// The fetched document goes through the same decoding and transforms as
+// during the export, so any difference means it changed since it was read
+// or the export didn't write what it read.
+func validateSample(cfg *config, collection *mongo.Collection, sampler *documentSampler, summary *exportSummary) {
+	if len(sampler.docs) == 0 {
+		log.Println("🔎 Sample validation: no documents with an _id were written, nothing to check")
+		return
+	}
+	log.Printf("🔎 Sample validation: re-fetching %d of %d written documents...\n", len(sampler.docs), sampler.seen)
+
+	// Not a repeat, so --dedupe-on-id mustn't drop it
+	check := *cfg
+	check.dedupe = nil
+	check.skipErrors = false
+
+	mismatches := 0
+	report := func(id interface{}, format string, args ...interface{}) {
+		mismatches++
+		if mismatches <= maxLoggedMismatches {
+			log.Printf("⚠️ Sample validation: _id=%v "+format+"\n", append([]interface{}{id}, args...)...)
+		}
+	}
+	for _, sampled := range sampler.docs {
+		raw, err := collection.FindOne(context.TODO(), bson.M{"_id": sampled.id}).Raw()
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			report(sampled.id, "is no longer in the collection")
+			continue
+		}
+		if err != nil {
+			report(sampled.id, "couldn't be fetched: %v", err)
+			continue
+		}
+		b := &batch{}
+		if err := b.add(0, 0, &check, raw); err != nil {
+			report(sampled.id, "couldn't be decoded: %v", err)
+			continue
+		}
+		if !sameDocument(sampled.doc, b) {
+			report(sampled.id, "differs from the exported copy")
+		}
+	}
+	if mismatches > maxLoggedMismatches {
+		log.Printf("⚠️ Sample validation: %d more mismatches not listed\n", mismatches-maxLoggedMismatches)
+	}
+	if mismatches == 0 {
+		log.Printf("✅ Sample validation: all %d sampled documents match the collection\n", len(sampler.docs))
+	} else {
+		log.Printf("⚠️ Sample validation: %d of %d sampled documents don't match the collection\n", mismatches, len(sampler.docs))
+	}
+	summary.addSampleValidation(len(sampler.docs), mismatches)
+}
+
+// Whether the exported document equals the one-document batch decoded from
+// the fetched copy
+func sameDocument(exported interface{}, b *batch) bool {
+	switch exported := exported.(type) {
+	case bson.M:
+		return len(b.docs) == 1 && reflect.DeepEqual(exported, b.docs[0])
+	case bson.D:
+		return len(b.ordered) == 1 && reflect.DeepEqual(exported, b.ordered[0])
+	case bson.Raw:
+		return len(b.raw) == 1 && bytes.Equal(exported, b.raw[0])
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Field --emit-sequence adds to every document
+const sequenceField = "_seq"
+
+// Batch writer that numbers the documents of a collection's export from 1 in
+// the order they're written, with one counter for all workers. A batch takes
+// a contiguous run of numbers, so sorting on the field puts the interleaved
+// files of parallel workers back into one order without merging them.
+type sequenceWriter struct {
+	batchWriter
+	next atomic.Int64
+}
+
+// Wrap w in a sequenceWriter if --emit-sequence is set
+func sequenced(cfg *config, w batchWriter) batchWriter {
+	if !cfg.emitSequence {
+		return w
+	}
+	return &sequenceWriter{batchWriter: w}
+}
+
+func (w *sequenceWriter) WriteBatch(workerID, batchNum int, b *batch) (string, error) {
+	n := int64(b.count())
+	stamped, err := stampSequence(b, w.next.Add(n)-n+1)
+	if err != nil {
+		return "", err
+	}
+	return w.batchWriter.WriteBatch(workerID, batchNum, stamped)
+}
+
+// Copy of the batch with the sequence field added to each document, which
+// leaves the batch itself untouched for the wrappers that still read it
+func stampSequence(b *batch, first int64) (*batch, error) {
+	out := *b
+	out.docs, out.ordered, out.raw = nil, nil, nil
+	for i, doc := range b.docs {
+		if _, ok := doc[sequenceField]; ok {
+			return nil, sequenceCollision()
+		}
+		copied := make(bson.M, len(doc)+1)
+		for key, value := range doc {
+			copied[key] = value
+		}
+		copied[sequenceField] = first + int64(i)
+		out.docs = append(out.docs, copied)
+	}
+	for i, doc := range b.ordered {
+		copied := make(bson.D, 0, len(doc)+1)
+		for _, e := range doc {
+			if e.Key == sequenceField {
+				return nil, sequenceCollision()
+			}
+			copied = append(copied, e)
+		}
+		out.ordered = append(out.ordered, append(copied, bson.E{Key: sequenceField, Value: first + int64(i)}))
+	}
+	for i, doc := range b.raw {
+		if _, err := doc.LookupErr(sequenceField); err == nil {
+			return nil, sequenceCollision()
+		}
+		// Append the field after the document's elements, before its terminating 0
+		idx, copied := bsoncore.AppendDocumentStart(nil)
+		copied = append(copied, doc[4:len(doc)-1]...)
+		copied = bsoncore.AppendInt64Element(copied, sequenceField, first+int64(i))
+		copied, err := bsoncore.AppendDocumentEnd(copied, idx)
+		if err != nil {
+			return nil, err
+		}
+		out.raw = append(out.raw, bson.Raw(copied))
+	}
+	return &out, nil
+}
+
+func sequenceCollision() error {
+	return fmt.Errorf("--emit-sequence field %q already exists in the document", sequenceField)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Contents of the batch_N.meta.json sidecar written next to each batch file
+// with --emit-sidecars, so tooling can index an export without reading it
+type sidecar struct {
+	File      string      `json:"file"` // Name of the batch file, in the same directory
+	Documents int         `json:"documents"`
+	FirstID   interface{} `json:"first_id"`
+	LastID    interface{} `json:"last_id"`
+	Bytes     int64       `json:"bytes"` // Size of the file on disk, after compression
+	WrittenAt time.Time   `json:"written_at"`
+}
+
+// Write the sidecar of a closed part file holding documents [first, end) of the batch
+func writeSidecar(path, dataPath, idKey string, b *batch, first, end int) error {
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		return fmt.Errorf("failed to write sidecar: %w", err)
+	}
+	meta := sidecar{
+		File:      filepath.Base(dataPath),
+		Documents: end - first,
+		FirstID:   batchDocID(b, first, idKey),
+		LastID:    batchDocID(b, end-1, idKey),
+		Bytes:     info.Size(),
+		WrittenAt: time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sidecar: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write sidecar: %w", err)
+	}
+	return nil
+}
+
+// Move a sidecar to target, pointing it at its batch file's new name
+func moveSidecar(path, target, file string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var meta sidecar
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	meta.File = file
+	if data, err = json.MarshalIndent(meta, "", "  "); err != nil {
+		return err
+	}
+	if err := os.WriteFile(target, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// _id of document i of the batch, nil if it has none
+func batchDocID(b *batch, i int, idKey string) interface{} {
+	switch {
+	case b.docs != nil:
+		return b.docs[i][idKey]
+	case b.ordered != nil:
+		return lookupD(b.ordered[i], idKey)
+	}
+	rv, err := b.raw[i].LookupErr(idKey)
+	if err != nil {
+		return nil
+	}
+	var id interface{}
+	if err := bson.UnmarshalValue(rv.Type, rv.Value, &id); err != nil {
+		return nil
+	}
+	return id
+}
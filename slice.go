@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// One --array-slice projection: the first limit elements of field, the last
+// ones for a negative limit, or limit elements from skip with skipped set
+type arraySlice struct {
+	field   string
+	skip    int
+	limit   int
+	skipped bool
+}
+
+// The $slice operand: n, or [skip, n]
+func (s arraySlice) operand() interface{} {
+	if s.skipped {
+		return bson.A{s.skip, s.limit}
+	}
+	return s.limit
+}
+
+// Parse comma-separated field:n or field:skip:n slices, e.g.
+// comments:5,history:-10,log:100:20
+func parseArraySlices(s string) ([]arraySlice, error) {
+	var slices []arraySlice
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pieces := strings.Split(part, ":")
+		if len(pieces) != 2 && len(pieces) != 3 {
+			return nil, fmt.Errorf("invalid slice %q, expected field:n or field:skip:n", part)
+		}
+		slice := arraySlice{field: pieces[0]}
+		for _, segment := range strings.Split(slice.field, ".") {
+			if segment == "" || strings.HasPrefix(segment, "$") {
+				return nil, fmt.Errorf("invalid field %q", slice.field)
+			}
+		}
+		if slice.field == "_id" || strings.HasPrefix(slice.field, "_id.") {
+			return nil, fmt.Errorf("can't slice _id, which resuming needs whole")
+		}
+		limit, err := strconv.Atoi(pieces[len(pieces)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid element count in %q", part)
+		}
+		slice.limit = limit
+		if len(pieces) == 3 {
+			if slice.skip, err = strconv.Atoi(pieces[1]); err != nil {
+				return nil, fmt.Errorf("invalid skip in %q", part)
+			}
+			if limit < 1 {
+				// The server rejects a non-positive count after a skip
+				return nil, fmt.Errorf("the element count in %q must be positive when a skip is given", part)
+			}
+			slice.skipped = true
+		}
+		for _, other := range slices {
+			if pathsCollide(other.field, slice.field) {
+				return nil, fmt.Errorf("%s and %s overlap", other.field, slice.field)
+			}
+		}
+		slices = append(slices, slice)
+	}
+	if len(slices) == 0 {
+		return nil, fmt.Errorf("no slices given")
+	}
+	return slices, nil
+}
+
+// Whether two dotted paths are the same field or one is inside the other,
+// which the server rejects in a projection as a path collision
+func pathsCollide(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+// Add --array-slice projections to an existing projection. $slice neither
+// includes nor excludes other fields, so on its own it keeps every field,
+// _id included, and next to inclusions it's one more included field.
+func addArraySlices(projection bson.D, slices []arraySlice) (bson.D, error) {
+	for _, slice := range slices {
+		for _, elem := range projection {
+			if pathsCollide(elem.Key, slice.field) {
+				return nil, fmt.Errorf("--array-slice on %s overlaps %s in the projection", slice.field, elem.Key)
+			}
+		}
+		projection = append(projection, bson.E{Key: slice.field, Value: bson.M{"$slice": slice.operand()}})
+	}
+	return projection, nil
+}
+
+// Add the --text-score-field and --array-slice projection to a query.
+// parseFlags has already rejected any overlap between them.
+func withProjection(cfg *config, opts *options.FindOptions) *options.FindOptions {
+	var projection bson.D
+	if cfg.textScoreField != "" {
+		projection = append(projection, bson.E{Key: cfg.textScoreField, Value: textScore})
+	}
+	projection, _ = addArraySlices(projection, cfg.arraySlices)
+	if len(projection) > 0 {
+		opts.SetProjection(projection)
+	}
+	return opts
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server error returned once a snapshot falls out of the history window
+const snapshotTooOldCode = 239
+
+// With --snapshot, start the session every batch query of this connection
+// runs in. Its reads all see the data as of the session's first read, however
+// long the export takes, as long as that stays within the server's snapshot
+// history window. Returns a function that ends the session.
+func startSnapshot(cfg *config, client *mongo.Client) (func(), error) {
+	if !cfg.snapshot {
+		return func() {}, nil
+	}
+	session, err := client.StartSession(options.Session().SetSnapshot(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start snapshot session: %w", err)
+	}
+	cfg.session = session
+	log.Println("📸 Reading from a single point-in-time snapshot, fixed by the first batch")
+	return func() {
+		if t := session.OperationTime(); t != nil {
+			log.Printf("📸 Snapshot was taken at cluster time %d.%d\n", t.T, t.I)
+		}
+		session.EndSession(context.TODO())
+		cfg.session = nil
+	}, nil
+}
+
+// Whether err means the snapshot is older than the server keeps history for
+func isSnapshotTooOld(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(snapshotTooOldCode)
+}
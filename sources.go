@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Each source's last_id.txt, checkpoint.json and history live in
+// checkpoints/<name>/, so sources resume independently of each other
+const sourcesCheckpointDir = "checkpoints"
+
+// Source names end up in file and directory names
+var sourceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// One collection to export with --sources, possibly on its own cluster
+type exportSource struct {
+	Name       string `json:"name"` // Tags the output files, e.g. eu_batch_1.json
+	URI        string `json:"uri"`
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+}
+
+// Read and validate a --sources file, a JSON array of sources
+func loadSources(path string) ([]exportSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sources []exportSource
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%s lists no sources", path)
+	}
+	seen := map[string]bool{}
+	for i, src := range sources {
+		if !sourceNamePattern.MatchString(src.Name) {
+			return nil, fmt.Errorf("source %d: name %q must be letters, digits, - or _", i+1, src.Name)
+		}
+		if seen[src.Name] {
+			return nil, fmt.Errorf("source %d: duplicate name %q", i+1, src.Name)
+		}
+		seen[src.Name] = true
+		if src.URI == "" || src.Database == "" || src.Collection == "" {
+			return nil, fmt.Errorf("source %s: uri, database and collection are required", src.Name)
+		}
+	}
+	return sources, nil
+}
+
+// Export every source into the shared exportDir, up to
+// cfg.sourceConcurrency at a time, each with its own connection and
+// checkpoint. A failed source stops new sources from starting, or with
+// --best-effort is recorded in the summary while the rest carry on.
+func exportSources(cfg *config, summary *exportSummary) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+		first  error
+	)
+	slots := make(chan struct{}, cfg.sourceConcurrency)
+	for i, src := range cfg.sources {
+		slots <- struct{}{}
+		mu.Lock()
+		stop := first != nil && !cfg.bestEffort
+		mu.Unlock()
+		if stop {
+			<-slots
+			break
+		}
+
+		log.Printf("📂 Exporting source %s: %s.%s (%d/%d)...\n", src.Name, src.Database, src.Collection, i+1, len(cfg.sources))
+		wg.Add(1)
+		go func(src exportSource) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			sourceSummary := newExportSummary()
+			err := exportOneSource(cfg, src, sourceSummary)
+			summary.addSource(src, sourceSummary, err)
+			if err == nil {
+				log.Printf("✅ Source %s: exported %d documents\n", src.Name, sourceSummary.Documents)
+				return
+			}
+			log.Printf("❌ Source %s failed: %v\n", src.Name, err)
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, src.Name)
+			if first == nil {
+				first = fmt.Errorf("source %s: %w", src.Name, err)
+			}
+		}(src)
+	}
+	wg.Wait()
+
+	switch {
+	case len(failed) == 0:
+		return nil
+	case !cfg.bestEffort:
+		return first
+	case len(failed) == len(cfg.sources):
+		return fmt.Errorf("all %d sources failed to export", len(cfg.sources))
+	}
+	return fmt.Errorf("%w: %s", errPartialExport, strings.Join(failed, ", "))
+}
+
+// Connect to one source and export its collection
+func exportOneSource(cfg *config, src exportSource, summary *exportSummary) error {
+	// Each source gets a fresh copy, since exporting adjusts the config
+	// (filters, capped or time-series detection, worker count)
+	sourceCfg := *cfg
+	sourceCfg.filePrefix = src.Name + "_"
+	sourceCfg.checkpointDir = filepath.Join(sourcesCheckpointDir, src.Name)
+	if !sourceCfg.noCheckpoint {
+		if err := os.MkdirAll(sourceCfg.checkpointDir, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create checkpoint directory: %w", err)
+		}
+	}
+
+	client, err := connectMongo(&sourceCfg, src.URI)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.TODO())
+	endSnapshot, err := startSnapshot(&sourceCfg, client)
+	if err != nil {
+		return err
+	}
+	defer endSnapshot()
+	return exportCollection(&sourceCfg, client, client.Database(src.Database), src.Collection, exportDir, summary)
+}
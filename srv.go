@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const srvScheme = "mongodb+srv://"
+
+// Add --srv-service-name and --srv-max-hosts to the connection string. The
+// driver resolves the SRV and TXT records while parsing it, so they only
+// take effect as URI options, not as client options set afterwards.
+func applySRVFlags(cfg *config, mongoURI string) (string, error) {
+	if cfg.srvServiceName == "" && cfg.srvMaxHosts == 0 {
+		return mongoURI, nil
+	}
+	if !strings.HasPrefix(mongoURI, srvScheme) {
+		return "", fmt.Errorf("--srv-service-name and --srv-max-hosts need a %s connection string", srvScheme)
+	}
+	u, err := url.Parse(mongoURI)
+	if err != nil {
+		return "", fmt.Errorf("invalid connection string: %w", err)
+	}
+	query := u.Query()
+	if cfg.srvServiceName != "" {
+		query.Set("srvServiceName", cfg.srvServiceName)
+	}
+	if cfg.srvMaxHosts > 0 {
+		query.Set("srvMaxHosts", strconv.Itoa(cfg.srvMaxHosts))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Override the replica set name and TLS setting from the connection string
+// or, for SRV, its TXT record
+func applyTopologyFlags(cfg *config, clientOpts *options.ClientOptions) {
+	if cfg.replicaSet != "" {
+		clientOpts.SetReplicaSet(cfg.replicaSet)
+	}
+	switch cfg.tls {
+	case "true":
+		if clientOpts.TLSConfig == nil {
+			clientOpts.SetTLSConfig(&tls.Config{})
+		}
+	case "false":
+		clientOpts.TLSConfig = nil
+	}
+}
+
+// Log the hosts the driver starts discovering the deployment from, resolved
+// from the SRV record for a mongodb+srv:// URI, with the settings that decide
+// how it connects to them
+func logSeedList(clientOpts *options.ClientOptions, mongoURI string) {
+	settings := []string{"TLS off"}
+	if clientOpts.TLSConfig != nil {
+		settings[0] = "TLS on"
+	}
+	if clientOpts.ReplicaSet != nil {
+		settings = append(settings, "replica set "+*clientOpts.ReplicaSet)
+	}
+	if clientOpts.LoadBalanced != nil && *clientOpts.LoadBalanced {
+		settings = append(settings, "load balanced")
+	}
+	if clientOpts.Auth != nil && clientOpts.Auth.AuthSource != "" {
+		settings = append(settings, "auth source "+clientOpts.Auth.AuthSource)
+	}
+	hosts := strings.Join(clientOpts.Hosts, ", ")
+	if strings.HasPrefix(mongoURI, srvScheme) {
+		log.Printf("🌐 SRV record resolved to %d hosts: %s (%s)\n", len(clientOpts.Hosts), hosts, strings.Join(settings, ", "))
+	} else {
+		log.Printf("🌐 Seed list: %s (%s)\n", hosts, strings.Join(settings, ", "))
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	webhookAttempts = 3                // Deliveries tried before giving up
+	webhookBackoff  = 2 * time.Second  // Delay before the first retry, doubled after each
+	webhookTimeout  = 10 * time.Second // Per-request timeout
+)
+
+// Final outcome of an export run, shared by all workers
+type exportSummary struct {
+	Status     string    `json:"status"` // "success", "partial" (--best-effort with failures), "aborted" (signal or --max-duration) or "failed"
+	Database   string    `json:"database"`
+	Collection string    `json:"collection"`
+	Documents  int64     `json:"documents"`
+	Batches    int64     `json:"batches"`
+	Skipped    int64     `json:"skipped"`
+	Duplicates int64     `json:"duplicates,omitempty"` // Dropped by --dedupe-on-id
+	Sampled    int64     `json:"sampled,omitempty"`    // Re-fetched by --sample-validate
+	Mismatches int64     `json:"mismatches,omitempty"` // Sampled documents that didn't match the collection
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Duration   string    `json:"duration"`
+	Warmup     string    `json:"warmup,omitempty"` // Part of Duration spent in --warmup
+	Errors     []string  `json:"errors,omitempty"`
+
+	// Per-collection outcomes when exporting several collections
+	Collections []collectionResult `json:"collections,omitempty"`
+
+	mu       sync.Mutex
+	errs     []error
+	warmup   time.Duration
+	reported sync.Once // finishRun reports the run once, from main or the abort watcher
+	exitCode int       // Process exit code decided by finishRun
+}
+
+func newExportSummary() *exportSummary {
+	return &exportSummary{StartedAt: time.Now()}
+}
+
+// Record a finished batch
+func (s *exportSummary) addBatch(documents, skipped, duplicates int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Batches++
+	s.Documents += int64(documents)
+	s.Skipped += int64(skipped)
+	s.Duplicates += int64(duplicates)
+}
+
+// Record the outcome of --sample-validate
+func (s *exportSummary) addSampleValidation(sampled, mismatches int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Sampled += int64(sampled)
+	s.Mismatches += int64(mismatches)
+}
+
+// Record time spent warming up, which counts towards the total duration
+func (s *exportSummary) addWarmup(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.warmup += d
+	s.Warmup = s.warmup.Round(time.Millisecond).String()
+}
+
+// Record a worker failure
+func (s *exportSummary) addError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+// Outcome of one collection of a multi-collection export
+type collectionResult struct {
+	Source     string `json:"source,omitempty"` // --sources name
+	Collection string `json:"collection"`
+	Status     string `json:"status"` // "success" or "failed"
+	Documents  int64  `json:"documents"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Fold a finished collection's own summary into the overall one
+func (s *exportSummary) addCollection(name string, c *exportSummary, err error) {
+	s.addResult(collectionResult{Collection: name}, c, err)
+}
+
+// Fold a finished --sources entry's own summary into the overall one
+func (s *exportSummary) addSource(src exportSource, c *exportSummary, err error) {
+	s.addResult(collectionResult{Source: src.Name, Collection: src.Database + "." + src.Collection}, c, err)
+}
+
+func (s *exportSummary) addResult(result collectionResult, c *exportSummary, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Documents += c.Documents
+	s.Batches += c.Batches
+	s.Skipped += c.Skipped
+	s.Duplicates += c.Duplicates
+	s.Sampled += c.Sampled
+	s.Mismatches += c.Mismatches
+	if c.warmup > 0 {
+		s.warmup += c.warmup
+		s.Warmup = s.warmup.Round(time.Millisecond).String()
+	}
+	result.Status, result.Documents = "success", c.Documents
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	s.Collections = append(s.Collections, result)
+}
+
+// Combined error of all recorded failures, nil if there were none
+func (s *exportSummary) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.errs...)
+}
+
+// Stamp the final status and duration once the run is over
+func (s *exportSummary) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FinishedAt = time.Now()
+	s.Duration = s.FinishedAt.Sub(s.StartedAt).String()
+	s.Status = "success"
+	if errors.Is(err, errAborted) {
+		s.Status = "aborted"
+		s.Errors = []string{err.Error()}
+		return
+	}
+	if errors.Is(err, errPartialExport) {
+		// Failed collections are already listed in Collections
+		s.Status = "partial"
+		return
+	}
+	if err != nil {
+		s.Status = "failed"
+		// Worker errors are already joined into err, so list them individually
+		if len(s.errs) == 0 {
+			s.Errors = []string{err.Error()}
+		}
+		for _, e := range s.errs {
+			s.Errors = append(s.Errors, e.Error())
+		}
+	}
+}
+
+// POST the summary to the webhook, retrying with backoff on failure
+func notifyWebhook(url string, summary *exportSummary) {
+	summary.mu.Lock()
+	body, err := json.Marshal(summary)
+	summary.mu.Unlock()
+	if err != nil {
+		log.Printf("⚠️ Warning: Failed to encode webhook summary: %v\n", err)
+		return
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err = postWebhook(client, url, body)
+		if err == nil {
+			log.Println("📨 Webhook notified")
+			return
+		}
+		log.Printf("⚠️ Warning: Webhook attempt %d/%d failed: %v\n", attempt, webhookAttempts, err)
+		if attempt < webhookAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	log.Printf("⚠️ Warning: Giving up on webhook after %d attempts\n", webhookAttempts)
+}
+
+// Send a single webhook request, treating any non-2xx response as a failure
+func postWebhook(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
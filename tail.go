@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Open the change stream for --tail-seconds before the export starts, so
+// it sees every change made while the export runs. With a checkpoint, the
+// stream resumes from the token an interrupted run saved.
+func openTail(collection *mongo.Collection, state *checkpointState, key string) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}}},
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if state != nil {
+		if token, ok := state.Tails[key]; ok {
+			var resume bson.D
+			if err := bson.UnmarshalExtJSON(token, true, &resume); err != nil {
+				return nil, fmt.Errorf("invalid resume token in %s: %w", checkpointFile, err)
+			}
+			opts.SetResumeAfter(resume)
+			log.Println("🔁 Resuming the change stream from the token saved by the previous run")
+		}
+	}
+
+	stream, err := collection.Watch(context.TODO(), pipeline, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open change stream (needs a replica set or sharded cluster): %w", err)
+	}
+	if err := saveTailToken(stream, state, key); err != nil {
+		stream.Close(context.TODO())
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Read change events for cfg.tailSeconds once the export is done, and write
+// the inserted, updated and replaced documents as extra batches
+func runTail(workerID int, cfg *config, stream *mongo.ChangeStream, writer batchWriter, summary *exportSummary, state *checkpointState, key string) error {
+	log.Printf("👀 Tailing the change stream for %s to catch changes made during the export...\n", cfg.tail)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.tail)
+	defer cancel()
+
+	batchNum, deleted := 1, 0
+	size := cfg.sizer.next()
+	b := &batch{}
+	flush := func() error {
+		if err := cfg.plugin.apply(workerID, batchNum, b); err != nil {
+			return err
+		}
+		// Change events aren't in _id order, so the manifest records no last _id
+		b.lastID = docID{}
+		if b.count() > 0 {
+			filePath, err := writer.WriteBatch(workerID, batchNum, b)
+			if err != nil {
+				return err
+			}
+			log.Printf("✅ Tail: Exported batch %d (%d changed records) -> %s\n", batchNum, b.count(), filePath)
+			batchNum++
+		}
+		summary.addBatch(b.count(), b.scanned-b.count(), 0)
+		cfg.progress.addBatch(b.count(), b.lastID)
+		cfg.heartbeat.addBatch(b.count(), b.lastID)
+		b, size = &batch{}, cfg.sizer.next()
+		return saveTailToken(stream, state, key)
+	}
+
+	for stream.Next(ctx) {
+		var event struct {
+			OperationType string        `bson:"operationType"`
+			FullDocument  bson.RawValue `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			return fmt.Errorf("failed to decode change event: %w", err)
+		}
+		// An update's lookup finds nothing if the document was deleted since
+		if event.OperationType == "delete" || event.FullDocument.Type != bsontype.EmbeddedDocument {
+			deleted++
+			continue
+		}
+		if err := b.add(workerID, batchNum, cfg, event.FullDocument.Document()); err != nil {
+			return err
+		}
+		if b.scanned >= size {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	// Running out the window ends Next with the context's deadline error
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("change stream failed: %w", err)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("⚠️ Warning: %d documents were deleted during the export or tail, earlier copies of them remain in the output\n", deleted)
+	}
+	return nil
+}
+
+// Save the stream's resume token to the checkpoint, if checkpointing is on
+func saveTailToken(stream *mongo.ChangeStream, state *checkpointState, key string) error {
+	token := stream.ResumeToken()
+	if state == nil || token == nil {
+		return nil
+	}
+	data, err := bson.MarshalExtJSON(token, true, false)
+	if err != nil {
+		return fmt.Errorf("failed to encode resume token: %w", err)
+	}
+	if state.Tails == nil {
+		state.Tails = map[string]json.RawMessage{}
+	}
+	state.Tails[key] = data
+	if err := saveCheckpointState(state); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
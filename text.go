@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The $meta expression for a document's --text-search relevance
+var textScore = bson.M{"$meta": "textScore"}
+
+// A $text query needs a text index, and the server only says so once the
+// first batch is fetched, so look for one up front
+func checkTextIndex(collection *mongo.Collection) error {
+	cursor, err := collection.Indexes().List(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to list indexes: %w", err)
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		elems, err := cursor.Current.Lookup("key").Document().Elements()
+		if err != nil {
+			return fmt.Errorf("invalid index: %w", err)
+		}
+		for _, elem := range elems {
+			if kind, ok := elem.Value().StringValueOK(); ok && kind == "text" {
+				return nil
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to list indexes: %w", err)
+	}
+	return fmt.Errorf("%s has no text index, which --text-search needs", collection.Name())
+}
+
+// The $text query for --text-search and --text-language
+func textSearchFilter(cfg *config) bson.M {
+	text := bson.M{"$search": cfg.textSearch}
+	if cfg.textLanguage != "" {
+		text["$language"] = cfg.textLanguage
+	}
+	return bson.M{"$text": text}
+}
+
+// A top-level field name for --text-score-field
+func validTextScoreField(name string) error {
+	if name == "_id" || strings.ContainsAny(name, "$.") {
+		return fmt.Errorf("%q must be a top-level field name other than _id, without $ or dots", name)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Consecutive interrupted reads a batch may hit before the worker gives up
+const readRetries = 3
+
+// Server error for a cursor that no longer exists, usually because it sat
+// idle past cursorTimeoutMillis (10 minutes by default)
+const cursorNotFoundCode = 43
+
+// Context for one batch's queries, bounded by --op-timeout if set and
+// cancelled with the whole run. With --snapshot it carries the snapshot session.
+func opContext(cfg *config) (context.Context, context.CancelFunc) {
+	ctx := rootCtx
+	if cfg.session != nil {
+		ctx = mongo.NewSessionContext(ctx, cfg.session)
+	}
+	if cfg.opTimeout > 0 {
+		return context.WithTimeout(ctx, cfg.opTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Whether an operation failed because --op-timeout expired, as opposed to
+// the run being cancelled or the server returning an error
+func isOpTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || (mongo.IsTimeout(err) && !errors.Is(err, context.Canceled))
+}
+
+func isCursorNotFound(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(cursorNotFoundCode)
+}
+
+// Why a read was cut short in a way the batch can be picked up again from its
+// last _id, empty if it failed for good
+func interruptedRead(cfg *config, err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case isOpTimeout(err):
+		return fmt.Sprintf("timed out after %s", cfg.opTimeout)
+	case isCursorNotFound(err):
+		return "lost its cursor, which the server timed out"
+	}
+	return ""
+}
+
+// Wrap an operation error so the log says whether it timed out or was cancelled
+func describeOpError(cfg *config, what string, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("%s cancelled: %w", what, err)
+	case isOpTimeout(err):
+		return fmt.Errorf("%s timed out after --op-timeout %s: %w", what, cfg.opTimeout, err)
+	case isCursorNotFound(err):
+		return fmt.Errorf("failed to %s, the server timed out the idle cursor (see --no-cursor-timeout): %w", what, err)
+	case cfg.session != nil && isSnapshotTooOld(err):
+		return fmt.Errorf("failed to %s, the snapshot is older than the server's history window (raise minSnapshotHistoryWindowInSeconds): %w", what, err)
+	}
+	return fmt.Errorf("failed to %s: %w", what, err)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// timeField of a time-series collection, empty for any other kind of collection
+func timeSeriesField(db *mongo.Database, name string) (string, error) {
+	specs, err := db.ListCollectionSpecifications(context.TODO(), bson.M{"name": name})
+	if err != nil {
+		return "", err
+	}
+	if len(specs) == 0 || specs[0].Type != "timeseries" {
+		return "", nil
+	}
+	var opts struct {
+		Timeseries struct {
+			TimeField string `bson:"timeField"`
+		} `bson:"timeseries"`
+	}
+	if err := bson.Unmarshal(specs[0].Options, &opts); err != nil {
+		return "", fmt.Errorf("invalid time-series options: %w", err)
+	}
+	return opts.Timeseries.TimeField, nil
+}
+
+// Time-series collections are views over internal buckets with no _id
+// index, so export them in a single pass sorted by the timeField instead of
+// paginating by _id
+func checkTimeSeries(cfg *config, db *mongo.Database, name string) {
+	field, err := timeSeriesField(db, name)
+	if err != nil {
+		log.Printf("⚠️ Warning: listCollections failed, can't detect time-series collections: %v\n", err)
+		return
+	}
+	if field == "" {
+		return
+	}
+	log.Printf("📈 Time-series collection detected, exporting in a single pass sorted by %q (not resumable)...\n", field)
+	cfg.timeField = field
+	cfg.naturalOrder = true
+	cfg.noCheckpoint = true
+}
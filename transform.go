@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Compile a --map-expr expression. The expression sees the document as
+// `doc` and must evaluate to the document to write, typically built with
+// set(doc, "field", value) and unset(doc, "field", ...). Expressions can't
+// do I/O or touch anything but the document.
+func compileMapExpr(code string) (*vm.Program, error) {
+	return expr.Compile(code,
+		expr.Env(map[string]interface{}{"doc": map[string]interface{}{}}),
+		expr.Function("set", setField, new(func(map[string]interface{}, string, interface{}) map[string]interface{})),
+		expr.Function("unset", unsetFields, new(func(map[string]interface{}, ...string) map[string]interface{})),
+	)
+}
+
+// Run the compiled --map-expr on one document
+func applyMapExpr(program *vm.Program, doc bson.M) (bson.M, error) {
+	out, err := expr.Run(program, map[string]interface{}{"doc": map[string]interface{}(doc)})
+	if err != nil {
+		return nil, fmt.Errorf("--map-expr: %w", err)
+	}
+	switch out := out.(type) {
+	case map[string]interface{}:
+		return bson.M(out), nil
+	case bson.M:
+		return out, nil
+	}
+	return nil, fmt.Errorf("--map-expr must return a document, got %T", out)
+}
+
+// set(doc, key, value): copy of doc with key set to value
+func setField(params ...interface{}) (interface{}, error) {
+	doc := copyDoc(params[0].(map[string]interface{}))
+	doc[params[1].(string)] = params[2]
+	return doc, nil
+}
+
+// unset(doc, keys...): copy of doc without the given keys
+func unsetFields(params ...interface{}) (interface{}, error) {
+	doc := copyDoc(params[0].(map[string]interface{}))
+	for _, key := range params[1:] {
+		delete(doc, key.(string))
+	}
+	return doc, nil
+}
+
+// Shallow copy, so set and unset never change the document they were given
+func copyDoc(doc map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(doc)+1)
+	for k, v := range doc {
+		out[k] = v
+	}
+	return out
+}
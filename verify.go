@@ -0,0 +1,735 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Most problems listed per export directory, the rest are only counted
+const maxVerifyProblems = 20
+
+// Batch file names, as written by batchFileWriter and renamed by
+// --merge-on-complete: prefix, batch, merged worker, part, format, gzip
+var batchFilePattern = regexp.MustCompile(`^(.*)batch_(\d+)(?:_worker_(\d+))?(?:\.part(\d+))?\.(json|csv|bson|jsonl|avro)(\.gz)?$`)
+
+// The --single-file output
+var singleFilePattern = regexp.MustCompile(`^(.*)export\.json(\.gz)?$`)
+
+// The verify subcommand: check an existing export offline. Every batch file
+// must decode completely in its format, hold the documents the manifest or
+// its sidecar says, match its recorded checksum, and continue each worker's
+// _id sequence from the batch before.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify [flags] export-dir ...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	idField := fs.String("id-field", "_id", "field holding the _id in the exported documents, as set by --id-as")
+	sortOrder := fs.String("sort-order", "asc", "_id order the export was written in: asc or desc")
+	source := fs.String("source", "", "only check the files of this --sources entry in a shared export directory")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("give at least one export directory")
+	}
+	if *sortOrder != "asc" && *sortOrder != "desc" {
+		return fmt.Errorf("unknown --sort-order %q, expected asc or desc", *sortOrder)
+	}
+
+	failed := 0
+	for _, dir := range fs.Args() {
+		v := &exportVerifier{dir: dir, idField: *idField, descending: *sortOrder == "desc"}
+		if *source != "" {
+			v.prefix = *source + "_"
+		}
+		v.run()
+		v.print()
+		if len(v.problems) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d exports have problems", failed, fs.NArg())
+	}
+	return nil
+}
+
+// Checks one export directory, collecting what it finds
+type exportVerifier struct {
+	dir        string
+	prefix     string // File name prefix of the --sources entry checked, empty for all files
+	idField    string
+	descending bool
+
+	files     int
+	documents int
+	problems  []string // Anything that makes the export incomplete or unreadable
+	warnings  []string // Worth knowing, but expected in some exports
+}
+
+// One batch as the manifest or the file names describe it
+type verifyBatch struct {
+	worker    int
+	batch     int
+	files     []string // Relative to the export directory
+	checksums []string // From the manifest, empty without
+	algorithm string
+	documents int    // Expected documents across its files, -1 if unknown
+	lastID    string // From the manifest, empty if not in _id order
+	ordered   bool   // Its _ids continue the worker's sequence
+	reset     bool   // A manifest reset: the worker's sequence starts over
+	rewritten bool   // A later entry wrote the same files again
+}
+
+func (v *exportVerifier) fail(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *exportVerifier) warn(format string, args ...interface{}) {
+	v.warnings = append(v.warnings, fmt.Sprintf(format, args...))
+}
+
+func (v *exportVerifier) run() {
+	if info, err := os.Stat(v.dir); err != nil || !info.IsDir() {
+		v.fail("%s is not a directory", v.dir)
+		return
+	}
+	onDisk, err := v.findFiles()
+	if err != nil {
+		v.fail("failed to list files: %v", err)
+		return
+	}
+
+	batches, listed, err := v.readManifest()
+	switch {
+	case err != nil:
+		v.fail("%v", err)
+		return
+	case batches == nil:
+		batches = v.batchesFromNames(onDisk)
+	default:
+		for _, path := range onDisk {
+			if !listed[path] {
+				v.warn("%s isn't listed in %s, it may be left from an earlier run", path, manifestFile)
+			}
+		}
+	}
+
+	// Files shared by several batches, like a --single-file export.json,
+	// can only be counted as a whole
+	shared := map[string]int{}
+	for _, b := range batches {
+		if !b.rewritten {
+			for _, file := range b.files {
+				shared[file]++
+			}
+		}
+	}
+	expected := map[string]int{}
+	for _, b := range batches {
+		if !b.rewritten && len(b.files) == 1 && shared[b.files[0]] > 1 && b.documents >= 0 {
+			expected[b.files[0]] += b.documents
+		}
+	}
+	for file, n := range expected {
+		if scan, ok := v.scanFile(file, "", ""); ok && scan.documents != n {
+			v.fail("%s holds %d documents, the manifest lists %d", file, scan.documents, n)
+		}
+	}
+
+	prev := map[int]primitive.ObjectID{} // Last _id of each worker's previous batch
+	for _, b := range batches {
+		if b.reset {
+			delete(prev, b.worker)
+			continue
+		}
+		if b.rewritten || len(b.files) == 1 && shared[b.files[0]] > 1 {
+			continue
+		}
+		v.verifyBatch(b, prev)
+	}
+}
+
+// Check a batch's files, then that they continue the worker's _id sequence
+func (v *exportVerifier) verifyBatch(b verifyBatch, prev map[int]primitive.ObjectID) {
+	name := fmt.Sprintf("worker %d batch %d", b.worker, b.batch)
+	documents := 0
+	var first, last primitive.ObjectID
+	complete := true
+	for i, file := range b.files {
+		checksum := ""
+		if i < len(b.checksums) {
+			checksum = b.checksums[i]
+		}
+		scan, ok := v.scanFile(file, b.algorithm, checksum)
+		if !ok {
+			complete = false
+			continue
+		}
+		documents += scan.documents
+		if scan.disorder > 0 && b.ordered {
+			v.fail("%s: %d documents out of _id order, first at document %d", file, scan.disorder, scan.firstDisorder)
+		}
+		if first.IsZero() {
+			first = scan.first
+		}
+		if !scan.last.IsZero() {
+			if !last.IsZero() && b.ordered && !v.follows(scan.first, last) {
+				v.fail("%s: first _id %s doesn't follow the previous part's last _id %s", file, scan.first.Hex(), last.Hex())
+			}
+			last = scan.last
+		}
+	}
+	if !complete {
+		return
+	}
+	if b.documents >= 0 && documents != b.documents {
+		v.fail("%s: %d documents in its files, %d expected", name, documents, b.documents)
+	}
+	if !b.ordered || first.IsZero() {
+		return
+	}
+	if b.lastID != "" && b.lastID != last.Hex() {
+		v.fail("%s: last _id is %s, the manifest records %s", name, last.Hex(), b.lastID)
+	}
+	if before, ok := prev[b.worker]; ok && !v.follows(first, before) {
+		v.warn("%s starts at _id %s, before the previous batch's last _id %s, so its documents overlap. A resume with --resume-safety-window does this on purpose.", name, first.Hex(), before.Hex())
+	}
+	prev[b.worker] = last
+}
+
+// Whether id comes after prev in the export's sort order
+func (v *exportVerifier) follows(id, prev primitive.ObjectID) bool {
+	c := bytes.Compare(id[:], prev[:])
+	if v.descending {
+		return c < 0
+	}
+	return c > 0
+}
+
+// Batch files under the export directory and its worker directories, and
+// leftover temp files, which only warn
+func (v *exportVerifier) findFiles() ([]string, error) {
+	var files []string
+	dirs := []string{"."}
+	entries, err := os.ReadDir(v.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "worker_") {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(filepath.Join(v.dir, dir))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			switch {
+			case entry.IsDir(), !strings.HasPrefix(name, v.prefix):
+			case strings.HasSuffix(name, tmpSuffix):
+				v.warn("%s is an incomplete file left by an interrupted run", filepath.Join(dir, name))
+			case batchFilePattern.MatchString(name), dir == "." && singleFilePattern.MatchString(name):
+				files = append(files, filepath.Join(dir, name))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Read the manifest's batches in the order they were written, keeping only
+// the last entry of a batch written twice. Returns nil batches if there is
+// no manifest, and the files it lists.
+func (v *exportVerifier) readManifest() ([]verifyBatch, map[string]bool, error) {
+	file, err := os.Open(filepath.Join(v.dir, v.prefix+manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var batches []verifyBatch
+	latest := map[string]int{} // Index in batches of each worker's batch and files
+	listed := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var entry manifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s line %d: %w", manifestFile, line, err)
+		}
+		if entry.Reset {
+			batches = append(batches, verifyBatch{worker: entry.Worker, reset: true})
+			continue
+		}
+		b := verifyBatch{
+			worker:    entry.Worker,
+			batch:     entry.Batch,
+			checksums: entry.Checksums,
+			algorithm: entry.Algorithm,
+			documents: entry.Documents,
+			lastID:    entry.LastID,
+			ordered:   entry.LastID != "",
+		}
+		for _, path := range entry.Files {
+			path = v.resolve(path, entry.Worker)
+			b.files = append(b.files, path)
+			listed[path] = true
+		}
+		key := fmt.Sprintf("%d/%d/%s", b.worker, b.batch, strings.Join(b.files, ","))
+		if i, ok := latest[key]; ok {
+			batches[i].rewritten = true
+		}
+		latest[key] = len(batches)
+		batches = append(batches, b)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", manifestFile, err)
+	}
+	if batches == nil {
+		batches = []verifyBatch{}
+	}
+	return batches, listed, nil
+}
+
+// A manifest path, or where --merge-on-complete moved it
+func (v *exportVerifier) resolve(path string, worker int) string {
+	if _, err := os.Stat(filepath.Join(v.dir, path)); err == nil {
+		return path
+	}
+	stem, ext, _ := strings.Cut(filepath.Base(path), ".")
+	merged := fmt.Sprintf("%s_worker_%d.%s", stem, worker, ext)
+	if _, err := os.Stat(filepath.Join(v.dir, merged)); err == nil {
+		return merged
+	}
+	return path
+}
+
+// Without a manifest, make one batch per worker and batch number from the
+// file names. Counts come from the sidecars if there are any, and every
+// batch is expected in _id order.
+func (v *exportVerifier) batchesFromNames(files []string) []verifyBatch {
+	v.warn("no %s, so document counts are only checked against sidecars and every batch must be in _id order", manifestFile)
+	type part struct {
+		path string
+		num  int
+	}
+	parts := map[[2]int][]part{}
+	var batches []verifyBatch
+	for _, path := range files {
+		m := batchFilePattern.FindStringSubmatch(filepath.Base(path))
+		if m == nil {
+			// Workers' documents interleave in a single file
+			batches = append(batches, verifyBatch{files: []string{path}, documents: -1})
+			continue
+		}
+		batchNum, _ := strconv.Atoi(m[2])
+		worker := 0
+		if m[3] != "" {
+			worker, _ = strconv.Atoi(m[3])
+		} else if dir := filepath.Dir(path); dir != "." {
+			worker, _ = strconv.Atoi(strings.TrimPrefix(dir, "worker_"))
+		}
+		num := 1
+		if m[4] != "" {
+			num, _ = strconv.Atoi(m[4])
+		}
+		key := [2]int{worker, batchNum}
+		parts[key] = append(parts[key], part{path: path, num: num})
+	}
+
+	for key, list := range parts {
+		sort.Slice(list, func(i, j int) bool { return list[i].num < list[j].num })
+		b := verifyBatch{worker: key[0], batch: key[1], documents: -1, ordered: true}
+		for _, p := range list {
+			b.files = append(b.files, p.path)
+		}
+		batches = append(batches, b)
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		if batches[i].worker != batches[j].worker {
+			return batches[i].worker < batches[j].worker
+		}
+		return batches[i].batch < batches[j].batch
+	})
+	return batches
+}
+
+// What reading a file found
+type fileScan struct {
+	documents     int
+	first, last   primitive.ObjectID // First and last ObjectID _ids, zero if none
+	disorder      int                // Documents whose _id doesn't follow the one before
+	firstDisorder int
+}
+
+// Read a whole file, checking its checksum, format and sidecar. Problems are
+// recorded, and ok is false if the file couldn't be read to the end.
+func (v *exportVerifier) scanFile(path, algorithm, checksum string) (scan fileScan, ok bool) {
+	full := filepath.Join(v.dir, path)
+	info, err := os.Stat(full)
+	if err != nil {
+		v.fail("%s is missing", path)
+		return scan, false
+	}
+	v.files++
+	if checksum != "" {
+		if _, known := checksumAlgorithms[algorithm]; !known {
+			v.fail("%s: unknown checksum algorithm %q", path, algorithm)
+		} else if sum, err := fileChecksum(algorithm, full); err != nil {
+			v.fail("%s: failed to checksum: %v", path, err)
+		} else if sum != checksum {
+			v.fail("%s: %s checksum %s doesn't match the manifest's %s", path, algorithm, sum, checksum)
+		}
+	}
+
+	m := batchFilePattern.FindStringSubmatch(filepath.Base(path))
+	format, compressed := "json", strings.HasSuffix(path, ".gz")
+	if m != nil {
+		format = m[5]
+	}
+	file, err := os.Open(full)
+	if err != nil {
+		v.fail("%s: %v", path, err)
+		return scan, false
+	}
+	defer file.Close()
+	var r io.Reader = bufio.NewReader(file)
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			v.fail("%s: not a valid gzip file: %v", path, err)
+			return scan, false
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	track := func(id primitive.ObjectID) {
+		scan.documents++
+		if id.IsZero() {
+			return
+		}
+		if !scan.last.IsZero() && !v.follows(id, scan.last) {
+			if scan.disorder == 0 {
+				scan.firstDisorder = scan.documents
+			}
+			scan.disorder++
+		}
+		if scan.first.IsZero() {
+			scan.first = id
+		}
+		scan.last = id
+	}
+	switch format {
+	case "csv":
+		err = scanCSV(r, v.idField, track)
+	case "bson":
+		err = scanBSON(r, v.idField, track)
+	case "jsonl":
+		err = scanJSONLines(r, v.idField, track)
+	case "avro":
+		err = scanAvro(r, track)
+	default:
+		err = scanJSON(r, v.idField, track)
+	}
+	if err != nil {
+		v.fail("%s: invalid %s after %d documents: %v", path, format, scan.documents, err)
+		return scan, false
+	}
+	v.documents += scan.documents
+	if m != nil {
+		v.checkSidecar(path, info.Size(), scan)
+	}
+	return scan, true
+}
+
+// Compare a file with its .meta.json sidecar, if it has one
+func (v *exportVerifier) checkSidecar(path string, size int64, scan fileScan) {
+	stem, _, _ := strings.Cut(filepath.Base(path), ".")
+	if m := batchFilePattern.FindStringSubmatch(filepath.Base(path)); m[4] != "" {
+		stem += ".part" + m[4]
+	}
+	data, err := os.ReadFile(filepath.Join(v.dir, filepath.Dir(path), stem+".meta.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var meta sidecar
+	if err == nil {
+		err = json.Unmarshal(data, &meta)
+	}
+	switch {
+	case err != nil:
+		v.fail("%s: unreadable sidecar: %v", path, err)
+	case meta.Documents != scan.documents:
+		v.fail("%s holds %d documents, its sidecar lists %d", path, scan.documents, meta.Documents)
+	case meta.Bytes != size:
+		v.fail("%s is %d bytes, its sidecar lists %d", path, size, meta.Bytes)
+	}
+}
+
+// Stream a JSON array of documents, or an object wrapping one under a
+// --json-root key, checking its count field if it has one
+func scanJSON(r io.Reader, idField string, track func(primitive.ObjectID)) error {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	rooted := tok == json.Delim('{')
+	if rooted {
+		if _, err := dec.Token(); err != nil { // The root key
+			return err
+		}
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("expected an array of documents")
+	}
+	count := 0
+	for dec.More() {
+		var doc map[string]json.RawMessage
+		if err := dec.Decode(&doc); err != nil {
+			return err
+		}
+		track(jsonObjectID(doc[idField]))
+		count++
+	}
+	if _, err := dec.Token(); err != nil { // Closing ]
+		return err
+	}
+	if rooted {
+		var meta map[string]json.RawMessage
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return err
+			}
+			if meta == nil {
+				meta = map[string]json.RawMessage{}
+			}
+			meta[fmt.Sprint(key)] = value
+		}
+		if _, err := dec.Token(); err != nil { // Closing }
+			return err
+		}
+		if raw, ok := meta["count"]; ok && string(raw) != strconv.Itoa(count) {
+			return fmt.Errorf("count field is %s, but the array holds %d documents", raw, count)
+		}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the documents")
+	}
+	return nil
+}
+
+// ObjectID of a JSON _id, a hex string or an extended JSON {"$oid": ...}
+func jsonObjectID(raw json.RawMessage) primitive.ObjectID {
+	var hex string
+	if json.Unmarshal(raw, &hex) != nil {
+		var oid struct {
+			OID string `json:"$oid"`
+		}
+		if json.Unmarshal(raw, &oid) != nil {
+			return primitive.NilObjectID
+		}
+		hex = oid.OID
+	}
+	id, _ := primitive.ObjectIDFromHex(hex)
+	return id
+}
+
+// One relaxed extended JSON document per line
+func scanJSONLines(r io.Reader, idField string, track func(primitive.ObjectID)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxPluginLine)
+	for scanner.Scan() {
+		var doc bson.Raw
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), false, &doc); err != nil {
+			return err
+		}
+		id, _ := doc.Lookup(idField).ObjectIDOK()
+		track(id)
+	}
+	return scanner.Err()
+}
+
+// Raw BSON documents back to back
+func scanBSON(r io.Reader, idField string, track func(primitive.ObjectID)) error {
+	for {
+		doc, err := bson.NewFromIOReader(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.Validate(); err != nil {
+			return err
+		}
+		id, _ := doc.Lookup(idField).ObjectIDOK()
+		track(id)
+	}
+}
+
+// An Avro object container file, counted block by block without decoding
+// the documents, so their _ids aren't checked
+func scanAvro(r io.Reader, track func(primitive.ObjectID)) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != "Obj\x01" {
+		return fmt.Errorf("not an Avro object container file")
+	}
+	// The header's metadata map, in blocks of key and value pairs
+	for {
+		n, err := binary.ReadVarint(br)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if n < 0 {
+			n = -n
+			if _, err := binary.ReadVarint(br); err != nil { // Block size
+				return err
+			}
+		}
+		for i := int64(0); i < 2*n; i++ {
+			if err := skipAvroBytes(br); err != nil {
+				return err
+			}
+		}
+	}
+	marker := make([]byte, 16)
+	if _, err := io.ReadFull(br, marker); err != nil {
+		return err
+	}
+	sync := make([]byte, 16)
+	for block := 1; ; block++ {
+		count, err := binary.ReadVarint(br)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if count < 0 {
+			return fmt.Errorf("block %d has a negative document count", block)
+		}
+		if err := skipAvroBytes(br); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(br, sync); err != nil {
+			return err
+		}
+		if !bytes.Equal(sync, marker) {
+			return fmt.Errorf("block %d doesn't end with the file's sync marker", block)
+		}
+		for i := int64(0); i < count; i++ {
+			track(primitive.NilObjectID)
+		}
+	}
+}
+
+// Skip a length-prefixed Avro string or bytes value
+func skipAvroBytes(br *bufio.Reader) error {
+	n, err := binary.ReadVarint(br)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("negative length")
+	}
+	_, err = io.CopyN(io.Discard, br, n)
+	return err
+}
+
+// A header line and one row per document
+func scanCSV(r io.Reader, idField string, track func(primitive.ObjectID)) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	column := -1
+	var previous primitive.ObjectID
+	for i, name := range header {
+		if name == idField {
+			column = i
+		}
+	}
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var id primitive.ObjectID
+		if column >= 0 {
+			id, _ = primitive.ObjectIDFromHex(row[column])
+		}
+		// Rows --unwind made from one document are adjacent and share its _id
+		if !id.IsZero() && id == previous {
+			continue
+		}
+		previous = id
+		track(id)
+	}
+}
+
+// Print the result for one export directory to stdout
+func (v *exportVerifier) print() {
+	status := "✅ PASS"
+	if len(v.problems) > 0 {
+		status = "❌ FAIL"
+	}
+	fmt.Printf("%s %s: %d files, %d documents, %d problems, %d warnings\n", status, v.dir, v.files, v.documents, len(v.problems), len(v.warnings))
+	for i, problem := range v.problems {
+		if i == maxVerifyProblems {
+			fmt.Printf("   ... and %d more problems\n", len(v.problems)-i)
+			break
+		}
+		fmt.Printf("   ❌ %s\n", problem)
+	}
+	for i, warning := range v.warnings {
+		if i == maxVerifyProblems {
+			fmt.Printf("   ... and %d more warnings\n", len(v.warnings)-i)
+			break
+		}
+		fmt.Printf("   ⚠️ %s\n", warning)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page the _id index into the server's cache before exporting, with a
+// covered index scan that counts entries server-side so no documents are
+// fetched or sent. Stops after timeout, leaving the index partly warm.
+// Returns how long the warmup took.
+func warmupIDIndex(collection *mongo.Collection, timeout time.Duration) time.Duration {
+	log.Printf("🔥 Warming up the _id index (for up to %s)...\n", timeout)
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$gte": primitive.MinKey{}}}}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+		{{Key: "$count", Value: "entries"}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetHint(bson.D{{Key: "_id", Value: 1}}).SetMaxTime(timeout))
+	if err == nil {
+		defer cursor.Close(context.TODO())
+		var result struct {
+			Entries int64 `bson:"entries"`
+		}
+		if cursor.Next(ctx) {
+			err = cursor.Decode(&result)
+		} else {
+			err = cursor.Err()
+		}
+		if err == nil {
+			log.Printf("🔥 Warmup scanned %d _id index entries in %s\n", result.Entries, time.Since(start).Round(time.Millisecond))
+			return time.Since(start)
+		}
+	}
+
+	if mongo.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("🔥 Warmup stopped at the %s limit, the _id index is partly cached\n", timeout)
+		return time.Since(start)
+	}
+	log.Printf("⚠️ Warning: Warmup failed after %s, continuing without it: %v\n", time.Since(start).Round(time.Millisecond), err)
+	return time.Since(start)
+}
@@ -0,0 +1,854 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Destination for exported batches, shared by all workers. Every output
+// (files, stdout, spooled single files) and wrapper (manifest, sampling)
+// implements it, so the export loop never creates files itself.
+//
+// WriteBatch is called concurrently by the workers and must be safe for that.
+// Once it returns nil the batch must be as durable as the destination allows,
+// since the checkpoint then moves past it. It must not modify the batch, which
+// a wrapper may still read afterwards.
+type batchWriter interface {
+	// Write a batch of records and return the path it was written to,
+	// or several joined by ", " if it was split across files
+	WriteBatch(workerID, batchNum int, b *batch) (string, error)
+	// Flush and close anything still open once all workers are done
+	Close() error
+}
+
+// Create the writer for the configured output mode
+func newBatchWriter(cfg *config, exportDir string) (batchWriter, error) {
+	if cfg.outputStdout {
+		return sequenced(cfg, newStdoutWriter(cfg)), nil
+	}
+	if cfg.singleFile {
+		if cfg.preserveOrder && cfg.naturalOrder {
+			return nil, fmt.Errorf("--preserve-order needs _id-ordered reads and can't be used with a natural-order scan")
+		}
+		out, err := newSingleFileWriter(filepath.Join(exportDir, cfg.filePrefix+"export.json"), cfg.compressFinal, cfg.jsonRoot, cfg.jsonRootMeta, idKey(cfg), cfg.bench)
+		if err != nil {
+			return nil, err
+		}
+		out.syncInterval = cfg.flushInterval
+		out.syncDocs = cfg.flushDocs
+		if !cfg.preserveOrder {
+			return sequenced(cfg, out), err
+		}
+		// Numbered as they're merged, so the sequence follows _id order
+		return newOrderedFileWriter(sequenced(cfg, out), exportDir, cfg.filePrefix, cfg.preserveFieldOrder, idKey(cfg), cfg.openFiles), nil
+	}
+	return sequenced(cfg, &batchFileWriter{
+		exportDir:      exportDir,
+		prefix:         cfg.filePrefix,
+		compress:       cfg.compressEachBatch,
+		format:         cfg.format,
+		flattenDepth:   cfg.flattenDepth,
+		csvNull:        cfg.csvNull,
+		csvMissing:     cfg.csvMissing,
+		unwind:         cfg.unwind,
+		avroSchema:     cfg.avroSchema,
+		avroCodec:      cfg.avroCodec,
+		jsonRoot:       cfg.jsonRoot,
+		jsonRootMeta:   cfg.jsonRootMeta,
+		idKey:          idKey(cfg),
+		merge:          cfg.mergeOnComplete,
+		maxFileBytes:   cfg.fileMaxBytes,
+		normalizeEJSON: cfg.normalizeEJSON,
+		sidecars:       cfg.emitSidecars,
+		files:          cfg.openFiles,
+		bench:          cfg.bench,
+	}), nil
+}
+
+// Suffix of a batch file while it's being written
+const tmpSuffix = ".tmp"
+
+// Writes every batch to its own file in the chosen format. With compression each file is a
+// complete gzip stream, so files can be uploaded and decoded independently.
+// Each worker writes into its own worker_M subdirectory, so workers never
+// have to coordinate file names. With a size limit a batch rolls over into
+// batch_N.part2.ext, batch_N.part3.ext... between documents, each part a
+// valid file on its own. Parts are written as batch_N.ext.tmp and only
+// renamed to their final name once complete, so readers never see a partial
+// file under a final name. A rerun of the batch after a crash overwrites the
+// temp file it left behind.
+type batchFileWriter struct {
+	exportDir      string
+	prefix         string // Prepended to every file name, e.g. a --sources name
+	compress       bool
+	format         string
+	flattenDepth   int
+	csvNull        string
+	csvMissing     string
+	unwind         []string    // Array fields expanded into a row per element
+	avroSchema     *avroSchema // Nil to infer each batch's
+	avroCodec      string
+	jsonRoot       string
+	jsonRootMeta   bool
+	idKey          string
+	merge          bool
+	maxFileBytes   int64
+	normalizeEJSON bool
+	sidecars       bool         // Write a .meta.json sidecar next to every file
+	files          *fileLimiter // --max-open-files, nil for no limit
+	bench          *benchmark   // Times file writes, nil unless --benchmark is set
+
+	mu      sync.Mutex
+	written []writtenBatch // Files to flatten into exportDir on Close when merging
+}
+
+type writtenBatch struct {
+	workerID int
+	path     string
+	sidecar  string // Its .meta.json, empty without --emit-sidecars
+}
+
+// Every part is flushed and closed before this returns, so the caller only
+// ever checkpoints past documents that are fully on disk
+func (w *batchFileWriter) WriteBatch(workerID, batchNum int, b *batch) (string, error) {
+	workerDir := filepath.Join(w.exportDir, fmt.Sprintf("worker_%d", workerID))
+	if err := os.MkdirAll(workerDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// CSV columns are the union over the whole batch, so every part shares one header
+	var table *csvTable
+	if w.format == "csv" {
+		table = flattenBatch(b.docs, w.flattenDepth, w.csvNull, w.csvMissing, w.unwind)
+	}
+	// Likewise an inferred Avro schema covers the whole batch
+	schema := w.avroSchema
+	if w.format == "avro" && schema == nil {
+		schema = inferAvroSchema(b.raw)
+	}
+
+	var paths, sidecars []string
+	var part *batchPart
+	done := false
+	defer func() {
+		if part != nil {
+			part.closeFile()
+			os.Remove(part.file.Name())
+		}
+		// A failed batch leaves no final files behind, not even parts it
+		// had already finished, since it's rewritten from the checkpoint
+		if !done {
+			for _, path := range append(paths, sidecars...) {
+				os.Remove(path)
+			}
+		}
+	}()
+	// Close the current part, which holds the documents from partStart up to end
+	partStart := 0
+	finishPart := func(end int) error {
+		if err := part.close(); err != nil {
+			return err
+		}
+		part = nil
+		if w.sidecars {
+			path := filepath.Join(workerDir, w.partStem(batchNum, len(paths))+".meta.json")
+			w.files.acquire()
+			err := writeSidecar(path, paths[len(paths)-1], w.idKey, b, partStart, end)
+			w.files.release()
+			if err != nil {
+				return err
+			}
+			sidecars = append(sidecars, path)
+		}
+		partStart = end
+		return nil
+	}
+	for i := 0; i < b.count(); i++ {
+		// Roll over to a new part between documents once this one is full
+		if part != nil && w.maxFileBytes > 0 && part.counter.n >= w.maxFileBytes {
+			if err := finishPart(i); err != nil {
+				return "", err
+			}
+		}
+		if part == nil {
+			path := filepath.Join(workerDir, w.partName(batchNum, len(paths)+1))
+			var err error
+			if part, err = w.openPart(path, table, schema); err != nil {
+				return "", err
+			}
+			paths = append(paths, path)
+		}
+		if err := part.enc.writeDoc(b, i); err != nil {
+			return "", fmt.Errorf("failed to write document _id=%v as %s: %w", batchDocID(b, i, w.idKey), w.format, err)
+		}
+	}
+	if part != nil {
+		if err := finishPart(b.count()); err != nil {
+			return "", err
+		}
+	}
+
+	done = true
+
+	w.mu.Lock()
+	for i, path := range paths {
+		written := writtenBatch{workerID: workerID, path: path}
+		if w.sidecars {
+			written.sidecar = sidecars[i]
+		}
+		w.written = append(w.written, written)
+	}
+	w.mu.Unlock()
+	return strings.Join(paths, ", "), nil
+}
+
+// File name of one part of a batch
+func (w *batchFileWriter) partName(batchNum, part int) string {
+	name := w.partStem(batchNum, part) + "." + formats[w.format].extension
+	if w.compress {
+		name += ".gz"
+	}
+	return name
+}
+
+// File name of one part of a batch without its extension, which its sidecar shares
+func (w *batchFileWriter) partStem(batchNum, part int) string {
+	if part > 1 {
+		return fmt.Sprintf("%sbatch_%d.part%d", w.prefix, batchNum, part)
+	}
+	return fmt.Sprintf("%sbatch_%d", w.prefix, batchNum)
+}
+
+// Number after the highest batch of a worker already in exportDir, in its
+// worker directory or merged into exportDir, so a run that has no manifest to
+// say where the last one stopped doesn't overwrite its files. A temp file
+// doesn't count, since its batch is written again.
+func nextBatchNum(exportDir, prefix string, workerID int) (int, error) {
+	highest := 0
+	scan := func(dir, after string) error {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			rest, ok := strings.CutPrefix(entry.Name(), prefix+"batch_")
+			if !ok || strings.HasSuffix(rest, tmpSuffix) {
+				continue
+			}
+			end := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+			if end <= 0 || !strings.HasPrefix(rest[end:], after) {
+				continue
+			}
+			if n, err := strconv.Atoi(rest[:end]); err == nil && n > highest {
+				highest = n
+			}
+		}
+		return nil
+	}
+	if err := scan(filepath.Join(exportDir, fmt.Sprintf("worker_%d", workerID)), "."); err != nil {
+		return 0, err
+	}
+	if err := scan(exportDir, fmt.Sprintf("_worker_%d.", workerID)); err != nil {
+		return 0, err
+	}
+	return highest + 1, nil
+}
+
+// Create a part's temp file and start its encoder, waiting for a free slot
+// under --max-open-files first
+func (w *batchFileWriter) openPart(path string, table *csvTable, schema *avroSchema) (*batchPart, error) {
+	w.files.acquire()
+	file, err := os.Create(path + tmpSuffix)
+	if err != nil {
+		w.files.release()
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	part := &batchPart{path: path, file: file, files: w.files}
+	out := w.bench.timed(file)
+	if w.compress {
+		part.gz = gzip.NewWriter(out)
+		out = part.gz
+	}
+	part.buf = bufio.NewWriter(out)
+	part.counter = &countingWriter{w: part.buf}
+
+	switch w.format {
+	case "csv":
+		part.enc, err = newCSVEncoder(part.counter, table)
+	case "bson":
+		part.enc = &bsonEncoder{out: part.counter}
+	case "ejson":
+		part.enc = &extJSONEncoder{out: part.counter, normalize: w.normalizeEJSON}
+	case "avro":
+		part.enc, err = newAvroEncoder(part.counter, schema, w.avroCodec)
+	default:
+		part.enc, err = newJSONArrayWriter(part.counter, w.jsonRoot, w.jsonRootMeta, w.idKey)
+	}
+	if err != nil {
+		part.closeFile()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to write %s: %w", w.format, err)
+	}
+	return part, nil
+}
+
+// One output file of a batch. Encoders write through a byte counter (before
+// compression) into a buffer, then gzip if enabled, then the file.
+type batchPart struct {
+	path    string   // Final name, which the temp file gets once complete
+	file    *os.File // The temp file being written
+	closed  bool
+	files   *fileLimiter // Holds a slot for the file until it's closed
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	counter *countingWriter
+	enc     docEncoder
+}
+
+// Finish the encoder, flush everything down to a closed file and give it its
+// final name
+func (p *batchPart) close() error {
+	if err := p.enc.close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := p.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if p.gz != nil {
+		if err := p.gz.Close(); err != nil {
+			return fmt.Errorf("failed to compress: %w", err)
+		}
+	}
+	if err := p.closeFile(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := os.Rename(p.file.Name(), p.path); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
+// Close the temp file and give back its slot, once
+func (p *batchPart) closeFile() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	err := p.file.Close()
+	p.files.release()
+	return err
+}
+
+// Counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// With merging enabled, move worker_M/batch_N.ext to batch_N_worker_M.ext
+// directly under exportDir and drop the emptied worker directories
+func (w *batchFileWriter) Close() error {
+	if !w.merge {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	workerDirs := map[string]bool{}
+	for _, batch := range w.written {
+		target := w.mergedPath(batch.path, batch.workerID)
+		if err := os.Rename(batch.path, target); err != nil {
+			return fmt.Errorf("failed to merge %s: %w", batch.path, err)
+		}
+		if batch.sidecar != "" {
+			if err := moveSidecar(batch.sidecar, w.mergedPath(batch.sidecar, batch.workerID), filepath.Base(target)); err != nil {
+				return fmt.Errorf("failed to merge %s: %w", batch.sidecar, err)
+			}
+		}
+		workerDirs[filepath.Dir(batch.path)] = true
+	}
+	for dir := range workerDirs {
+		os.Remove(dir) // Only succeeds if empty, leaving files from earlier runs alone
+	}
+	log.Printf("📁 Merged %d batch files into %s\n", len(w.written), w.exportDir)
+	return nil
+}
+
+// Where a worker's file ends up once merged into exportDir
+func (w *batchFileWriter) mergedPath(path string, workerID int) string {
+	stem, ext, _ := strings.Cut(filepath.Base(path), ".")
+	return filepath.Join(w.exportDir, fmt.Sprintf("%s_worker_%d.%s", stem, workerID, ext))
+}
+
+// Streams every batch into a single JSON array. With compression the whole
+// file is one gzip stream, which compresses better than per-batch files but
+// can only be decoded from the start.
+type singleFileWriter struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	gz   *gzip.Writer
+	buf  *bufio.Writer
+	json *jsonArrayWriter
+
+	// Periodic fsync so a hard crash loses at most this much output
+	syncInterval time.Duration
+	syncDocs     int
+	unsynced     int
+	lastSync     time.Time
+}
+
+func newSingleFileWriter(path string, compress bool, jsonRoot string, jsonRootMeta bool, idKey string, bench *benchmark) (*singleFileWriter, error) {
+	if compress {
+		path += ".gz"
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	w := &singleFileWriter{path: path, file: file, lastSync: time.Now()}
+	out := bench.timed(file)
+	if compress {
+		w.gz = gzip.NewWriter(out)
+		out = w.gz
+	}
+	w.buf = bufio.NewWriter(out)
+	if w.json, err = newJSONArrayWriter(w.buf, jsonRoot, jsonRootMeta, idKey); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return w, nil
+}
+
+func (w *singleFileWriter) WriteBatch(workerID, batchNum int, b *batch) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i := 0; i < b.count(); i++ {
+		if err := w.json.writeDoc(b, i); err != nil {
+			return "", fmt.Errorf("failed to write JSON: %w", err)
+		}
+		w.unsynced++
+		if err := w.maybeSync(); err != nil {
+			return "", err
+		}
+	}
+	return w.path, nil
+}
+
+// Push buffered output down to disk once the document count or interval is reached
+func (w *singleFileWriter) maybeSync() error {
+	due := (w.syncDocs > 0 && w.unsynced >= w.syncDocs) ||
+		(w.syncInterval > 0 && time.Since(w.lastSync) >= w.syncInterval)
+	if !due {
+		return nil
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush file: %w", err)
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return fmt.Errorf("failed to compress: %w", err)
+		}
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	w.unsynced = 0
+	w.lastSync = time.Now()
+	return nil
+}
+
+func (w *singleFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.json.close(); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush file: %w", err)
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			return fmt.Errorf("failed to compress: %w", err)
+		}
+	}
+	return w.file.Close()
+}
+
+// Encodes the documents of a batch into one file, a document at a time
+type docEncoder interface {
+	writeDoc(b *batch, i int) error
+	close() error
+}
+
+// Writes CSV rows under a header line
+type csvEncoder struct {
+	w     *csv.Writer
+	table *csvTable
+}
+
+func newCSVEncoder(out io.Writer, table *csvTable) (*csvEncoder, error) {
+	e := &csvEncoder{w: csv.NewWriter(out), table: table}
+	if err := e.w.Write(table.columns); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *csvEncoder) writeDoc(b *batch, i int) error {
+	if err := e.w.WriteAll(e.table.rows[i]); err != nil {
+		return err
+	}
+	// Flush per row so the byte counter sees it for rollover
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Writes raw documents back to back, the layout mongodump uses for .bson files
+type bsonEncoder struct {
+	out io.Writer
+}
+
+func (e *bsonEncoder) writeDoc(b *batch, i int) error {
+	_, err := e.out.Write(b.raw[i])
+	return err
+}
+
+func (e *bsonEncoder) close() error {
+	return nil
+}
+
+// Writes one relaxed extended JSON document per line, transcoding straight
+// from the raw BSON
+type extJSONEncoder struct {
+	out       io.Writer
+	normalize bool // --normalize-ejson
+}
+
+func (e *extJSONEncoder) writeDoc(b *batch, i int) error {
+	marshal := func(doc bson.Raw) ([]byte, error) { return bson.MarshalExtJSON(doc, false, false) }
+	if e.normalize {
+		marshal = marshalNormalizedExtJSON
+	}
+	data, err := marshal(b.raw[i])
+	if err != nil {
+		return err
+	}
+	if _, err := e.out.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (e *extJSONEncoder) close() error {
+	return nil
+}
+
+// Streams every document to stdout as one line of JSON, or extended JSON for
+// ejson, for piping into other tools. Batches are written whole under the
+// lock, so lines from different workers never interleave.
+type stdoutWriter struct {
+	mu  sync.Mutex
+	buf *bufio.Writer
+	enc docEncoder
+}
+
+func newStdoutWriter(cfg *config) *stdoutWriter {
+	w := &stdoutWriter{buf: bufio.NewWriter(cfg.bench.timed(os.Stdout))}
+	if cfg.format == "ejson" {
+		w.enc = &extJSONEncoder{out: w.buf, normalize: cfg.normalizeEJSON}
+	} else {
+		w.enc = &jsonLinesEncoder{out: w.buf}
+	}
+	return w
+}
+
+func (w *stdoutWriter) WriteBatch(workerID, batchNum int, b *batch) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := 0; i < b.count(); i++ {
+		if err := w.enc.writeDoc(b, i); err != nil {
+			return "", fmt.Errorf("failed to write to stdout: %w", err)
+		}
+	}
+	// Hand each batch on right away rather than when the buffer fills
+	if err := w.buf.Flush(); err != nil {
+		return "", fmt.Errorf("failed to write to stdout: %w", err)
+	}
+	return "stdout", nil
+}
+
+func (w *stdoutWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Flush()
+}
+
+// Writes decoded documents as compact JSON, one per line
+type jsonLinesEncoder struct {
+	out io.Writer
+}
+
+func (e *jsonLinesEncoder) writeDoc(b *batch, i int) error {
+	var doc interface{}
+	if b.ordered != nil {
+		doc = orderedDoc(b.ordered[i])
+	} else {
+		doc = b.docs[i]
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = e.out.Write(append(data, '\n'))
+	return err
+}
+
+func (e *jsonLinesEncoder) close() error {
+	return nil
+}
+
+// Writes documents as one indented JSON array, laid out the same way as
+// json.Encoder with SetIndent("", "  "). With a root key the array is wrapped
+// in an object, {"<root>": [...]}, optionally followed by the document count
+// and first/last _id as sibling fields.
+type jsonArrayWriter struct {
+	out     io.Writer
+	root    string
+	meta    bool
+	idKey   string // Field holding the _id, for the first_id/last_id metadata
+	indent  string
+	count   int
+	firstID interface{}
+	lastID  interface{}
+}
+
+func newJSONArrayWriter(out io.Writer, root string, meta bool, idKey string) (*jsonArrayWriter, error) {
+	w := &jsonArrayWriter{out: out, root: root, meta: meta, idKey: idKey, indent: "  "}
+	opening := "["
+	if root != "" {
+		key, err := json.Marshal(root)
+		if err != nil {
+			return nil, err
+		}
+		opening = "{\n  " + string(key) + ": ["
+		w.indent = "    "
+	}
+	if _, err := io.WriteString(out, opening); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *jsonArrayWriter) writeDoc(b *batch, i int) error {
+	if b.ordered != nil {
+		doc := b.ordered[i]
+		return w.write(orderedDoc(doc), lookupD(doc, w.idKey))
+	}
+	return w.write(b.docs[i], b.docs[i][w.idKey])
+}
+
+func (w *jsonArrayWriter) write(doc interface{}, id interface{}) error {
+	data, err := json.MarshalIndent(doc, w.indent, "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n" + w.indent
+	if w.count == 0 {
+		sep = "\n" + w.indent
+		w.firstID = id
+	}
+	if _, err := io.WriteString(w.out, sep); err != nil {
+		return err
+	}
+	if _, err := w.out.Write(data); err != nil {
+		return err
+	}
+	w.lastID = id
+	w.count++
+	return nil
+}
+
+func (w *jsonArrayWriter) close() error {
+	closing := "]"
+	if w.count > 0 {
+		closing = "\n" + w.indent[2:] + "]"
+	}
+	if w.root != "" {
+		if w.meta {
+			meta, err := w.metadata()
+			if err != nil {
+				return err
+			}
+			closing += meta
+		}
+		closing += "\n}"
+	}
+	_, err := io.WriteString(w.out, closing+"\n")
+	return err
+}
+
+// Sibling fields written after the wrapped array
+func (w *jsonArrayWriter) metadata() (string, error) {
+	firstID, err := json.Marshal(w.firstID)
+	if err != nil {
+		return "", err
+	}
+	lastID, err := json.Marshal(w.lastID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(",\n  \"count\": %d,\n  \"first_id\": %s,\n  \"last_id\": %s", w.count, firstID, lastID), nil
+}
+
+// A bson.D that marshals to a JSON object with its fields in document order,
+// where encoding/json would otherwise emit a list of Key/Value pairs
+type orderedDoc bson.D
+
+func (d orderedDoc) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, e := range d {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(e.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(orderedValue(e.Value))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Wrap nested documents, including those inside arrays, so they keep their order too
+func orderedValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case primitive.D:
+		return orderedDoc(v)
+	case primitive.A:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = orderedValue(item)
+		}
+		return values
+	}
+	return v
+}
+
+// Value of a top-level field of a bson.D, nil if it's missing
+func lookupD(doc bson.D, key string) interface{} {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+// Field the written documents keep their _id in
+func idKey(cfg *config) string {
+	if cfg.idKey != "" {
+		return cfg.idKey
+	}
+	return "_id"
+}
+
+// Copy of a raw document with its _id element renamed to key, in place
+func renameRawID(doc bson.Raw, key string) (bson.Raw, error) {
+	elements, err := doc.Elements()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := doc.LookupErr(key); err == nil {
+		return nil, idKeyCollision(key)
+	}
+	idx, out := bsoncore.AppendDocumentStart(nil)
+	for _, element := range elements {
+		name := element.Key()
+		if name == "_id" {
+			name = key
+		}
+		value := element.Value()
+		out = bsoncore.AppendHeader(out, value.Type, name)
+		out = append(out, value.Value...)
+	}
+	out, err = bsoncore.AppendDocumentEnd(out, idx)
+	return bson.Raw(out), err
+}
+
+// Rename the _id field of a bson.D to key, keeping its position
+func renameOrderedID(doc bson.D, key string) error {
+	id := -1
+	for i := range doc {
+		switch doc[i].Key {
+		case key:
+			return idKeyCollision(key)
+		case "_id":
+			id = i
+		}
+	}
+	if id >= 0 {
+		doc[id].Key = key
+	}
+	return nil
+}
+
+// Store the document's original _id under key instead of _id. A document
+// that had no _id is left without either, like the raw and bson.D forms.
+func renameID(doc bson.M, id interface{}, hasID bool, key string) error {
+	if _, exists := doc[key]; exists {
+		return idKeyCollision(key)
+	}
+	delete(doc, "_id")
+	if hasID {
+		doc[key] = id
+	}
+	return nil
+}
+
+// A document that already has the --id-as field would lose one of the two values
+func idKeyCollision(key string) error {
+	return fmt.Errorf("--id-as field %q already exists in the document", key)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBatchFileWriterFailedWrite(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxFileBytes int64
+	}{
+		{name: "single file", maxFileBytes: 0},
+		// The first parts are complete and renamed before the write fails
+		{name: "rolled over parts", maxFileBytes: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := &config{checkpointDir: dir, idType: idTypeObjectID}
+			checkpoint := objectDocID(primitive.NewObjectID())
+			saveLastID(cfg, checkpoint)
+
+			// NaN can't be written as JSON, so the write fails after the
+			// first documents have gone into the temp file
+			b := &batch{docs: []bson.M{
+				{"_id": primitive.NewObjectID(), "n": 1},
+				{"_id": primitive.NewObjectID(), "n": 2},
+				{"_id": primitive.NewObjectID(), "n": math.NaN()},
+			}}
+			b.scanned = len(b.docs)
+			b.lastID = objectDocID(b.docs[2]["_id"].(primitive.ObjectID))
+
+			w := &batchFileWriter{exportDir: dir, format: "json", idKey: "_id", maxFileBytes: tt.maxFileBytes}
+			if _, err := w.WriteBatch(1, 1, b); err == nil {
+				t.Fatal("WriteBatch() succeeded, want an error")
+			}
+
+			entries, err := os.ReadDir(filepath.Join(dir, "worker_1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				t.Errorf("failed batch left %s behind", entry.Name())
+			}
+			// exportFromID only checkpoints a batch once WriteBatch has
+			// returned nil for it
+			if got := loadLastID(cfg); got != checkpoint {
+				t.Errorf("%s = %s after the failed batch, want %s", lastIDFile, got, checkpoint)
+			}
+		})
+	}
+}
+
+func TestRenameIDForms(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name      string
+		doc       bson.D
+		want      bson.D // Expected document, in field order
+		collision bool
+	}{
+		{
+			name: "renamed in place",
+			doc:  bson.D{{Key: "a", Value: 1}, {Key: "_id", Value: id}, {Key: "b", Value: 2}},
+			want: bson.D{{Key: "a", Value: 1}, {Key: "id", Value: id}, {Key: "b", Value: 2}},
+		},
+		{
+			name:      "target field already exists",
+			doc:       bson.D{{Key: "_id", Value: id}, {Key: "id", Value: "taken"}},
+			collision: true,
+		},
+		{
+			name:      "target field exists and no _id",
+			doc:       bson.D{{Key: "id", Value: "taken"}},
+			collision: true,
+		},
+		{
+			name: "no _id",
+			doc:  bson.D{{Key: "a", Value: 1}},
+			want: bson.D{{Key: "a", Value: 1}},
+		},
+	}
+
+	check := func(t *testing.T, form string, err error, got bson.D, want bson.D, collision bool) {
+		t.Helper()
+		if collision {
+			if err == nil || !strings.Contains(err.Error(), `--id-as field "id" already exists`) {
+				t.Errorf("%s: error = %v, want an --id-as collision", form, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s: error = %v", form, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", form, got, want)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// bson.M, from a fresh copy since it's changed in place
+			m := bson.M{}
+			for _, e := range tt.doc {
+				m[e.Key] = e.Value
+			}
+			original, hasID := m["_id"]
+			err := renameID(m, original, hasID, "id")
+			var got bson.D
+			if err == nil {
+				for _, e := range tt.want {
+					got = append(got, bson.E{Key: e.Key, Value: m[e.Key]})
+				}
+				if len(m) != len(tt.want) {
+					t.Errorf("bson.M: got %v, want %v", m, tt.want)
+				}
+			}
+			check(t, "bson.M", err, got, tt.want, tt.collision)
+
+			// bson.D
+			d := append(bson.D(nil), tt.doc...)
+			err = renameOrderedID(d, "id")
+			check(t, "bson.D", err, d, tt.want, tt.collision)
+
+			// Raw
+			raw, err := bson.Marshal(tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			renamed, err := renameRawID(raw, "id")
+			got = nil
+			if err == nil {
+				if err := bson.Unmarshal(renamed, &got); err != nil {
+					t.Fatal(err)
+				}
+				// Unmarshalled numbers come back as int32
+				for i := range got {
+					if n, ok := got[i].Value.(int32); ok {
+						got[i].Value = int(n)
+					}
+				}
+			}
+			check(t, "raw", err, got, tt.want, tt.collision)
+		})
+	}
+}
+
+func TestNextBatchNum(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string // Relative to the export directory
+		want  int
+	}{
+		{name: "empty directory", want: 1},
+		{name: "worker directory", files: []string{"worker_0/batch_1.json", "worker_0/batch_12.json.gz", "worker_0/batch_3.json"}, want: 13},
+		{name: "parts and sidecars", files: []string{"worker_0/batch_4.part2.csv", "worker_0/batch_5.meta.json"}, want: 6},
+		{name: "merged files", files: []string{"worker_0/batch_2.json", "batch_7_worker_0.json", "batch_9_worker_0.part2.json"}, want: 10},
+		{name: "temp file of an interrupted batch", files: []string{"worker_0/batch_2.json", "worker_0/batch_3.json.tmp"}, want: 3},
+		{name: "other workers", files: []string{"worker_1/batch_8.json", "batch_9_worker_1.json", "batch_9_worker_10.json"}, want: 1},
+		{name: "other files", files: []string{"worker_0/manifest.jsonl", "worker_0/batch_.json", "worker_0/batch_x.json", "errors.jsonl"}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := nextBatchNum(dir, "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("nextBatchNum() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("file prefix", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, "worker_0"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"orders_batch_4.json", "batch_9.json"} {
+			if err := os.WriteFile(filepath.Join(dir, "worker_0", name), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, err := nextBatchNum(dir, "orders_", 0); err != nil || got != 5 {
+			t.Errorf("nextBatchNum() = %d, %v, want 5", got, err)
+		}
+	})
+}